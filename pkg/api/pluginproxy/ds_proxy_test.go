@@ -696,6 +696,69 @@ func TestDataSourceProxy_requestHandling(t *testing.T) {
 	})
 }
 
+func TestDataSourceProxy_influxDBRoutes(t *testing.T) {
+	b, err := os.ReadFile("../../../public/app/plugins/datasource/influxdb/plugin.json")
+	require.NoError(t, err)
+	var plugin struct {
+		Routes []*plugins.Route `json:"routes"`
+	}
+	require.NoError(t, json.Unmarshal(b, &plugin))
+
+	ds := &datasources.DataSource{
+		Type:     datasources.DS_INFLUXDB,
+		URL:      "http://influxdb:8181",
+		JsonData: simplejson.NewFromAny(map[string]any{"version": "SQL"}),
+	}
+	validate := func(role org.RoleType, method, path string) (*DataSourceProxy, error) {
+		req, err := http.NewRequest(method, "http://localhost/asd", nil)
+		require.NoError(t, err)
+		ctx := &contextmodel.ReqContext{
+			Context:      &web.Context{Req: req},
+			SignedInUser: &user.SignedInUser{OrgRole: role},
+		}
+		proxy, err := setupDSProxyTest(t, ctx, ds, plugin.Routes, path)
+		require.NoError(t, err)
+		return proxy, proxy.validateRequest()
+	}
+
+	tests := []struct {
+		method, path string
+		allowed      org.RoleType
+		denied       org.RoleType
+	}{
+		{method: http.MethodGet, path: "api/v3/configure/database", allowed: org.RoleViewer},
+		{method: http.MethodPost, path: "api/v3/write_lp", allowed: org.RoleEditor, denied: org.RoleViewer},
+		{method: http.MethodGet, path: "api/v3/configure/token", allowed: org.RoleAdmin, denied: org.RoleEditor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			proxy, err := validate(tt.allowed, tt.method, tt.path)
+			require.NoError(t, err)
+			require.NotNil(t, proxy.matchedRoute)
+
+			req, err := http.NewRequest(tt.method, "http://localhost/asd", nil)
+			require.NoError(t, err)
+			ApplyRoute(req.Context(), req, proxy.proxyPath, proxy.matchedRoute, DSInfo{
+				DecryptedSecureJSONData: map[string]string{"token": "secret"},
+			}, proxy.cfg)
+			assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+
+			if tt.denied != "" {
+				_, err := validate(tt.denied, tt.method, tt.path)
+				require.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("other paths keep the authentication of the datasource", func(t *testing.T) {
+		for _, path := range []string{"api/v2/write", "query", "write"} {
+			proxy, err := validate(org.RoleViewer, http.MethodPost, path)
+			require.NoError(t, err)
+			assert.Nil(t, proxy.matchedRoute, path)
+		}
+	})
+}
+
 func TestNewDataSourceProxy_InvalidURL(t *testing.T) {
 	ctx := contextmodel.ReqContext{
 		Context:      &web.Context{},
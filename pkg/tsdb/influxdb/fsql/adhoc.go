@@ -0,0 +1,215 @@
+package fsql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// adhocFilter is a dashboard ad hoc filter sent along with a query.
+type adhocFilter struct {
+	Key       string `json:"key"`
+	Operator  string `json:"operator"`
+	Value     string `json:"value"`
+	Condition string `json:"condition"`
+	// Values holds the operands of the multi-value operators (one of, not one
+	// of, between).
+	Values []string `json:"values"`
+	// Table is the table the filter is scoped to, possibly schema-qualified,
+	// overriding the scope of its key: the key is then the column name, even
+	// if it contains dots. See [adhocFilter.scope].
+	Table string `json:"table"`
+}
+
+// tableReferencePattern matches the table named after FROM or JOIN,
+// optionally schema-qualified and quoted.
+var tableReferencePattern = regexp.MustCompile(`(?i)\b(?:from|join)\s+((?:"[^"]+"|[\w$]+)(?:\.(?:"[^"]+"|[\w$]+))*)`)
+
+// adhocFilterCTE is the name under which the user's SQL is wrapped when ad
+// hoc filters are applied.
+const adhocFilterCTE = "__grafana_adhoc"
+
+// applyAdhocFilters wraps the SQL in a CTE and applies the filters in an
+// outer WHERE clause. Unlike appending to the user's WHERE clause, this works
+// for any query, including ones with joins, grouping or their own CTEs.
+func applyAdhocFilters(sql string, filters []adhocFilter) (string, error) {
+	if len(filters) == 0 {
+		return sql, nil
+	}
+
+	tables := referencedTables(sql)
+	preds := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if table, column, ok := f.scope(); ok {
+			// Filters scoped to a table the query doesn't read from are
+			// meant for other panels of the dashboard.
+			if !referencesTable(tables, table) {
+				continue
+			}
+			f.Key = column
+		}
+
+		pred, err := f.predicate()
+		if err != nil {
+			return "", err
+		}
+		if len(preds) > 0 {
+			cond := strings.ToUpper(f.Condition)
+			if cond == "" {
+				cond = "AND"
+			}
+			if cond != "AND" && cond != "OR" {
+				return "", fmt.Errorf("ad hoc filter %q: unsupported condition %q", f.Key, f.Condition)
+			}
+			pred = cond + " " + pred
+		}
+		preds = append(preds, pred)
+	}
+	if len(preds) == 0 {
+		return sql, nil
+	}
+
+	return fmt.Sprintf("WITH %s AS %s SELECT * FROM %s WHERE %s",
+		adhocFilterCTE, subquery(sql), adhocFilterCTE, strings.Join(preds, " ")), nil
+}
+
+// scope returns the table the filter is scoped to and the column it applies
+// to. Filters are scoped to their table, if set, or else by keys of the form
+// table.column, so a dashboard mixing several tables only filters the queries
+// reading from the table. The table may itself be schema-qualified.
+func (f adhocFilter) scope() (table, column string, ok bool) {
+	if f.Table != "" {
+		return f.Table, f.Key, true
+	}
+	i := strings.LastIndex(f.Key, ".")
+	if i <= 0 || i == len(f.Key)-1 {
+		return "", "", false
+	}
+	return f.Key[:i], f.Key[i+1:], true
+}
+
+// referencedTables returns the lower-cased names of the tables the SQL reads
+// from. Schema-qualified tables are recorded both with and without their
+// schema.
+func referencedTables(sql string) map[string]bool {
+	tables := map[string]bool{}
+	for _, name := range tableNames(sql) {
+		name = strings.ToLower(name)
+		tables[name] = true
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			tables[name[i+1:]] = true
+		}
+	}
+	return tables
+}
+
+// tableNames returns the names of the tables the SQL reads from, without
+// quotes, in order of appearance and without duplicates.
+func tableNames(sql string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range tableReferencePattern.FindAllStringSubmatch(sql, -1) {
+		name := strings.ReplaceAll(m[1], `"`, "")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// referencesTable reports whether the table, or its unqualified name when it
+// is schema-qualified, is among the referenced tables.
+func referencesTable(tables map[string]bool, table string) bool {
+	table = strings.ToLower(table)
+	if tables[table] {
+		return true
+	}
+	i := strings.LastIndex(table, ".")
+	return i >= 0 && tables[table[i+1:]]
+}
+
+// predicate renders the filter as a SQL predicate.
+func (f adhocFilter) predicate() (string, error) {
+	if f.Key == "" {
+		return "", fmt.Errorf("ad hoc filter: missing key")
+	}
+	key := quoteIdentifier(f.Key)
+
+	values := f.Values
+	if len(values) == 0 && f.Value != "" {
+		values = []string{f.Value}
+	}
+
+	op := f.Operator
+	if op == "" {
+		op = "="
+	}
+	switch strings.ToLower(op) {
+	case "=", "!=", "<", ">", "<=", ">=":
+		return fmt.Sprintf("%s %s %s", key, op, sqlLiteral(f.Value)), nil
+	case "=~":
+		return fmt.Sprintf("%s ~ %s", key, quoteString(trimRegex(f.Value))), nil
+	case "!~":
+		return fmt.Sprintf("%s !~ %s", key, quoteString(trimRegex(f.Value))), nil
+	case "=|", "!=|":
+		if len(values) == 0 {
+			return "", fmt.Errorf("ad hoc filter %q: missing values", f.Key)
+		}
+		literals := make([]string, 0, len(values))
+		for _, v := range values {
+			literals = append(literals, sqlLiteral(v))
+		}
+		in := "IN"
+		if op == "!=|" {
+			in = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", key, in, strings.Join(literals, ", ")), nil
+	case "between":
+		if len(values) != 2 {
+			return "", fmt.Errorf("ad hoc filter %q: between expects 2 values, received %d", f.Key, len(values))
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", key, sqlLiteral(values[0]), sqlLiteral(values[1])), nil
+	case "is null":
+		return fmt.Sprintf("%s IS NULL", key), nil
+	case "is not null":
+		return fmt.Sprintf("%s IS NOT NULL", key), nil
+	default:
+		return "", fmt.Errorf("ad hoc filter %q: unsupported operator %q", f.Key, f.Operator)
+	}
+}
+
+// trimRegex removes the slashes delimiting a regex value, if any.
+func trimRegex(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// subquery parenthesizes the SQL query to nest it in another one. Trailing
+// semicolons are dropped, and the closing parenthesis goes on its own line
+// so a trailing line comment doesn't comment it out.
+func subquery(sql string) string {
+	return "(" + strings.TrimRight(sql, "; \t\r\n") + "\n)"
+}
+
+// quoteIdentifier quotes a SQL identifier.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteString quotes a SQL string literal.
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
+
+// sqlLiteral renders the value as a number when it is one, and as a string
+// literal otherwise.
+func sqlLiteral(s string) string {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	return quoteString(s)
+}
@@ -0,0 +1,120 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyAdhocFilters(t *testing.T) {
+	cs := []struct {
+		name    string
+		sql     string
+		filters []adhocFilter
+		out     string
+		err     string
+	}{
+		{
+			name: "no filters",
+			sql:  `select * from cpu`,
+			out:  `select * from cpu`,
+		},
+		{
+			name: "single filter",
+			sql:  `select host, avg(usage) from cpu group by host;`,
+			filters: []adhocFilter{
+				{Key: "host", Operator: "=", Value: "server'1"},
+			},
+			out: `WITH __grafana_adhoc AS (select host, avg(usage) from cpu group by host
+) SELECT * FROM __grafana_adhoc WHERE "host" = 'server''1'`,
+		},
+		{
+			name: "conditions and numbers",
+			sql:  `select * from cpu`,
+			filters: []adhocFilter{
+				{Key: "usage", Operator: ">", Value: "0.5"},
+				{Key: "region", Value: "eu"},
+				{Key: "region", Operator: "!=", Value: "us", Condition: "or"},
+			},
+			out: `WITH __grafana_adhoc AS (select * from cpu
+) SELECT * FROM __grafana_adhoc WHERE "usage" > 0.5 AND "region" = 'eu' OR "region" != 'us'`,
+		},
+		{
+			name: "advanced operators",
+			sql:  `select * from cpu`,
+			filters: []adhocFilter{
+				{Key: "host", Operator: "=~", Value: "/^web-.*/"},
+				{Key: "region", Operator: "!~", Value: "test"},
+				{Key: "dc", Operator: "!=|", Values: []string{"a", "b"}},
+				{Key: "code", Operator: "=|", Values: []string{"500", "503"}},
+				{Key: "usage", Operator: "between", Values: []string{"0.1", "0.9"}},
+				{Key: "owner", Operator: "is null"},
+				{Key: "team", Operator: "IS NOT NULL"},
+			},
+			out: `WITH __grafana_adhoc AS (select * from cpu
+) SELECT * FROM __grafana_adhoc WHERE "host" ~ '^web-.*' AND "region" !~ 'test' AND "dc" NOT IN ('a', 'b') AND "code" IN (500, 503) AND "usage" BETWEEN 0.1 AND 0.9 AND "owner" IS NULL AND "team" IS NOT NULL`,
+		},
+		{
+			name:    "between without two values",
+			sql:     `select * from cpu`,
+			filters: []adhocFilter{{Key: "usage", Operator: "between", Value: "1"}},
+			err:     `ad hoc filter "usage": between expects 2 values, received 1`,
+		},
+		{
+			name: "table-scoped filters",
+			sql:  `select * from iox."CPU" c join mem on c.host = mem.host`,
+			filters: []adhocFilter{
+				{Key: "disk.device", Value: "sda"},
+				{Key: "cpu.host", Value: "a", Condition: "or"},
+				{Key: "iox.mem.free", Operator: ">", Value: "10"},
+				{Key: "region", Value: "eu"},
+			},
+			out: `WITH __grafana_adhoc AS (select * from iox."CPU" c join mem on c.host = mem.host
+) SELECT * FROM __grafana_adhoc WHERE "host" = 'a' AND "free" > 10 AND "region" = 'eu'`,
+		},
+		{
+			name:    "table-scoped filters for other tables",
+			sql:     `select * from cpu`,
+			filters: []adhocFilter{{Key: "disk.device", Value: "sda"}, {Key: "device", Value: "sda", Table: "disk"}},
+			out:     `select * from cpu`,
+		},
+		{
+			name:    "keys with dots scoped to a table",
+			sql:     `select * from cpu`,
+			filters: []adhocFilter{{Key: "disk.device", Value: "sda", Table: "cpu"}},
+			out: `WITH __grafana_adhoc AS (select * from cpu
+) SELECT * FROM __grafana_adhoc WHERE "disk.device" = 'sda'`,
+		},
+		{
+			name:    "trailing semicolons",
+			sql:     "select * from cpu;;\n",
+			filters: []adhocFilter{{Key: "host", Value: "a"}},
+			out: `WITH __grafana_adhoc AS (select * from cpu
+) SELECT * FROM __grafana_adhoc WHERE "host" = 'a'`,
+		},
+		{
+			name:    "trailing line comment",
+			sql:     "select * from cpu -- all hosts",
+			filters: []adhocFilter{{Key: "host", Value: "a"}},
+			out: `WITH __grafana_adhoc AS (select * from cpu -- all hosts
+) SELECT * FROM __grafana_adhoc WHERE "host" = 'a'`,
+		},
+		{
+			name:    "unsupported operator",
+			sql:     `select * from cpu`,
+			filters: []adhocFilter{{Key: "host", Operator: "LIKE", Value: "a"}},
+			err:     `ad hoc filter "host": unsupported operator "LIKE"`,
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			sql, err := applyAdhocFilters(c.sql, c.filters)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.out, sql)
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package fsql
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/apache/arrow/go/v13/arrow"
@@ -32,10 +34,27 @@ type recordReader interface {
 // newQueryDataResponse builds a [backend.DataResponse] from a stream of
 // [arrow.Record]s.
 //
-// The backend.DataResponse contains a single [data.Frame].
-func newQueryDataResponse(reader recordReader, query sqlutil.Query, headers metadata.MD) backend.DataResponse {
-	var resp backend.DataResponse
-	frame, err := frameForRecords(reader)
+// The backend.DataResponse contains a single [data.Frame]. Panics raised by
+// unexpected Arrow data are recovered into an error response for the query
+// alone, without any half-converted frame.
+func newQueryDataResponse(reader recordReader, qm *queryModel, headers metadata.MD) (resp backend.DataResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp = conversionPanicResponse(qm, &conversionPanic{value: r, stack: debug.Stack()})
+		}
+	}()
+
+	query := qm.Query
+	frame, err := frameForRecords(reader, qm.Limits)
+	var panicErr *conversionPanic
+	if errors.As(err, &panicErr) {
+		return conversionPanicResponse(qm, panicErr)
+	}
+	if errors.Is(err, errRowLimit) {
+		resp.Error = err
+		resp.Frames = data.Frames{}
+		return resp
+	}
 	if err != nil {
 		resp.Error = err
 	}
@@ -50,14 +69,35 @@ func newQueryDataResponse(reader recordReader, query sqlutil.Query, headers meta
 	frame.Meta.ExecutedQueryString = query.RawSQL
 	frame.Meta.DataTopic = data.DataTopic(query.RawSQL)
 
+	// Numbers must be parsed before long frames are widened, which would turn
+	// their string columns into labels.
+	parseNumericStrings(frame, qm.NumberFormat)
+
 	switch query.Format {
 	case sqlutil.FormatOptionTimeSeries:
-		if _, idx := frame.FieldByName("time"); idx == -1 {
+		idx := findTimeField(frame, qm.timeColumns())
+		if idx == -1 && qm.TimeColumn != "" {
+			resp.Error = fmt.Errorf("time column %q not found", qm.TimeColumn)
+			return resp
+		}
+		if idx == -1 {
 			resp.Error = fmt.Errorf("no time column found")
 			return resp
 		}
+		idx = preferFields(frame, idx, qm.ValueColumns)
+
+		// Servers fetching partitions in parallel may return rows out of
+		// order, which panels and LongToWide don't cope with.
+		sortByTime(frame, idx)
 
-		if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeLong {
+		if qm.Pivot != nil {
+			var err error
+			frame, err = pivotFrame(frame, qm.Pivot)
+			if err != nil {
+				resp.Error = err
+				return resp
+			}
+		} else if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeLong {
 			var err error
 			frame, err = data.LongToWide(frame, nil)
 			if err != nil {
@@ -65,7 +105,7 @@ func newQueryDataResponse(reader recordReader, query sqlutil.Query, headers meta
 				return resp
 			}
 		}
-	case sqlutil.FormatOptionTable:
+	case sqlutil.FormatOptionTable, formatOptionTrace:
 		// No changes to the output. Send it as is.
 	case sqlutil.FormatOptionLogs:
 		// TODO(brett): We need to find out what this actually is and if its
@@ -74,16 +114,117 @@ func newQueryDataResponse(reader recordReader, query sqlutil.Query, headers meta
 		resp.Error = fmt.Errorf("unsupported format")
 	}
 
+	// Strings are truncated once long frames are widened, so only values are
+	// cut and not the labels of the series.
+	truncateStrings(frame, qm.MaxStringLength)
+
+	frame.Meta.PreferredVisualization = preferredVisualization(query.Format)
 	resp.Frames = data.Frames{frame}
 	return resp
 }
 
+// formatOptionTrace formats the query results as a table of spans shown
+// with the trace visualization. sqlutil has no trace format.
+const formatOptionTrace = sqlutil.FormatOptionLogs + 1
+
+// preferredVisualization returns the visualization Explore uses for results
+// of the given format.
+func preferredVisualization(format sqlutil.FormatQueryOption) data.VisType {
+	switch format {
+	case sqlutil.FormatOptionTable:
+		return data.VisTypeTable
+	case sqlutil.FormatOptionLogs:
+		return data.VisTypeLogs
+	case formatOptionTrace:
+		return data.VisTypeTrace
+	default:
+		return data.VisTypeGraph
+	}
+}
+
+// findTimeField returns the index of the first field whose name matches one
+// of names, in order of preference and ignoring case, or -1.
+func findTimeField(frame *data.Frame, names []string) int {
+	for _, name := range names {
+		for i, f := range frame.Fields {
+			if strings.EqualFold(f.Name, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// preferFields moves the fields matching valueNames (ignoring case), in order
+// of preference, before the other fields but the time field at timeIdx, and
+// returns the new index of the time field. Panels picking the first numeric
+// field then use the preferred value column. The time field is moved first
+// when other time fields come before it, which would otherwise be taken for
+// the time of the series. The other fields keep their order.
+func preferFields(frame *data.Frame, timeIdx int, valueNames []string) int {
+	preferred := make([]*data.Field, 0, len(valueNames))
+	used := make([]bool, len(frame.Fields))
+	used[timeIdx] = true
+	for _, name := range valueNames {
+		for i, f := range frame.Fields {
+			if !used[i] && strings.EqualFold(f.Name, name) {
+				preferred = append(preferred, f)
+				used[i] = true
+			}
+		}
+	}
+	timeFirst := false
+	for _, f := range frame.Fields[:timeIdx] {
+		if f.Type().Time() {
+			timeFirst = true
+		}
+	}
+	if len(preferred) == 0 && !timeFirst {
+		return timeIdx
+	}
+
+	timeField := frame.Fields[timeIdx]
+	ordered := make([]*data.Field, 0, len(frame.Fields))
+	if timeFirst {
+		ordered = append(ordered, timeField)
+	}
+	for i, f := range frame.Fields {
+		switch {
+		case f == timeField:
+			if !timeFirst {
+				ordered = append(ordered, f)
+			}
+		case !used[i]:
+			ordered = append(ordered, preferred...)
+			preferred = nil
+			ordered = append(ordered, f)
+		}
+	}
+	frame.Fields = append(ordered, preferred...)
+	for i, f := range frame.Fields {
+		if f == timeField {
+			return i
+		}
+	}
+	return timeIdx
+}
+
+// errRowLimit is returned for results exceeding the row limit of queries
+// which must not be truncated.
+var errRowLimit = errors.New("row limit exceeded")
+
 // frameForRecords creates a [data.Frame] from a stream of [arrow.Record]s.
-func frameForRecords(reader recordReader) (*data.Frame, error) {
+// Results exceeding the row limit are truncated with a notice, or refused
+// when the limits are strict.
+func frameForRecords(reader recordReader, limits queryLimits) (*data.Frame, error) {
 	var (
-		frame = newFrame(reader.Schema())
-		rows  int64
+		frame   = newFrame(reader.Schema())
+		rows    int64
+		maxRows = limits.maxRows
 	)
+	if maxRows <= 0 {
+		maxRows = rowLimit
+	}
 	for reader.Next() {
 		record := reader.Record()
 		for i, col := range record.Columns() {
@@ -93,10 +234,13 @@ func frameForRecords(reader recordReader) (*data.Frame, error) {
 		}
 
 		rows += record.NumRows()
-		if rows > rowLimit {
+		if rows > maxRows {
+			if limits.strict {
+				return frame, fmt.Errorf("%w: results have more than %v rows", errRowLimit, maxRows)
+			}
 			frame.AppendNotices(data.Notice{
 				Severity: data.NoticeSeverityWarning,
-				Text:     fmt.Sprintf("Results have been limited to %v because the SQL row limit was reached", rowLimit),
+				Text:     fmt.Sprintf("Results have been limited to %v because the SQL row limit was reached", maxRows),
 			})
 			return frame, nil
 		}
@@ -105,25 +249,75 @@ func frameForRecords(reader recordReader) (*data.Frame, error) {
 			return frame, err
 		}
 	}
+	// Errors ending the stream are only reported once Next returns false.
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return frame, err
+	}
 	return frame, nil
 }
 
 // newFrame builds a new Data Frame from an Arrow Schema.
+//
+// Duplicate column names, common with joins, are suffixed deterministically
+// (value, value_2, ...) and reported in a notice rather than producing
+// fields that overwrite each other.
 func newFrame(schema *arrow.Schema) *data.Frame {
 	fields := schema.Fields()
 	df := &data.Frame{
 		Fields: make([]*data.Field, len(fields)),
 		Meta:   &data.FrameMeta{},
 	}
+
+	names := uniqueNames(fields)
+	var renamed []string
 	for i, f := range fields {
 		df.Fields[i] = newField(f)
+		if names[i] != f.Name {
+			df.Fields[i].Name = names[i]
+			renamed = append(renamed, fmt.Sprintf("%s as %s", f.Name, names[i]))
+		}
+	}
+	if len(renamed) > 0 {
+		df.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Duplicate column names were renamed: %s", strings.Join(renamed, ", ")),
+		})
 	}
 	return df
 }
 
+// uniqueNames returns the names of the fields, suffixing repeated names with
+// _2, _3, ... while avoiding collisions with the other names.
+func uniqueNames(fields []arrow.Field) []string {
+	taken := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		taken[f.Name] = true
+	}
+
+	seen := make(map[string]int, len(fields))
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		seen[f.Name]++
+		if seen[f.Name] == 1 {
+			names[i] = f.Name
+			continue
+		}
+		n := seen[f.Name]
+		name := fmt.Sprintf("%s_%d", f.Name, n)
+		for taken[name] {
+			n++
+			name = fmt.Sprintf("%s_%d", f.Name, n)
+		}
+		seen[f.Name] = n
+		taken[name] = true
+		names[i] = name
+	}
+	return names
+}
+
 func newField(f arrow.Field) *data.Field {
 	switch f.Type.ID() {
-	case arrow.STRING:
+	case arrow.STRING, arrow.LARGE_STRING, arrow.BINARY, arrow.LARGE_BINARY:
 		return newDataField[string](f)
 	case arrow.FLOAT32:
 		return newDataField[float32](f)
@@ -156,20 +350,44 @@ func newField(f arrow.Field) *data.Field {
 	}
 }
 
+// newDataField returns a nullable field of T for the Arrow field. Fields are
+// nullable whatever the nullability of the Arrow field: servers don't
+// reliably mark the columns holding nulls as nullable, and nulls must not be
+// turned into zero values skewing aggregations and alerts.
 func newDataField[T any](f arrow.Field) *data.Field {
-	if f.Nullable {
-		var s []*T
-		return data.NewField(f.Name, nil, s)
-	}
-	var s []T
+	var s []*T
 	return data.NewField(f.Name, nil, s)
 }
 
+// conversionPanic is a panic recovered while converting Arrow data.
+type conversionPanic struct {
+	// column and dataType describe the column being converted, if known.
+	column   string
+	dataType arrow.DataType
+	value    any
+	stack    []byte
+}
+
+func (e *conversionPanic) Error() string {
+	if e.dataType == nil {
+		return fmt.Sprintf("panic: %v", e.value)
+	}
+	return fmt.Sprintf("column %q of type %s: panic: %v", e.column, e.dataType, e.value)
+}
+
+// conversionPanicResponse logs the panic recovered while converting the
+// results of the query and returns the error response of the query.
+func conversionPanicResponse(qm *queryModel, e *conversionPanic) backend.DataResponse {
+	glog.Error("Recovered from panic converting FlightSQL results", "refId", qm.RefID, "sql", qm.RawSQL, "err", e.Error(), "stack", string(e.stack))
+	return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("failed to convert the results of query %s: %s", qm.RefID, e))
+}
+
 // copyData copies the contents of an Arrow column into a Data Frame field.
-func copyData(field *data.Field, col arrow.Array) error {
+// Panics are returned as a [conversionPanic] error.
+func copyData(field *data.Field, col arrow.Array) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(fmt.Errorf("panic: %s %s", r, string(debug.Stack())))
+			err = &conversionPanic{column: field.Name, dataType: col.DataType(), value: r, stack: debug.Stack()}
 		}
 	}()
 
@@ -199,6 +417,11 @@ func copyData(field *data.Field, col arrow.Array) error {
 				return err
 			}
 			value := sc.(*scalar.DenseUnion).ChildValue()
+			if !value.IsValid() {
+				var m *json.RawMessage
+				field.Append(m)
+				continue
+			}
 
 			var d any
 			switch value.DataType().ID() {
@@ -219,10 +442,17 @@ func copyData(field *data.Field, col arrow.Array) error {
 			if err != nil {
 				return err
 			}
-			field.Append(json.RawMessage(b))
+			m := json.RawMessage(b)
+			field.Append(&m)
 		}
 	case arrow.STRING:
 		copyBasic[string](field, array.NewStringData(colData))
+	case arrow.LARGE_STRING:
+		copyBasic[string](field, array.NewLargeStringData(colData))
+	case arrow.BINARY:
+		copyBasic[string](field, binaryStrings{array.NewBinaryData(colData)})
+	case arrow.LARGE_BINARY:
+		copyBasic[string](field, binaryStrings{array.NewLargeBinaryData(colData)})
 	case arrow.UINT8:
 		copyBasic[uint8](field, array.NewUint8Data(colData))
 	case arrow.UINT16:
@@ -260,6 +490,31 @@ type arrowArray[T any] interface {
 	Len() int
 }
 
+// binaryStrings reads the values of a binary array as base64 encoded
+// strings: frames have no binary fields, and the bytes may not be valid
+// UTF-8, which the JSON of frames requires.
+type binaryStrings struct {
+	values binaryArray
+}
+
+type binaryArray interface {
+	IsNull(int) bool
+	Value(int) []byte
+	Len() int
+}
+
+func (a binaryStrings) IsNull(i int) bool {
+	return a.values.IsNull(i)
+}
+
+func (a binaryStrings) Value(i int) string {
+	return base64.StdEncoding.EncodeToString(a.values.Value(i))
+}
+
+func (a binaryStrings) Len() int {
+	return a.values.Len()
+}
+
 func copyBasic[T any, Array arrowArray[T]](dst *data.Field, src Array) {
 	for i := 0; i < src.Len(); i++ {
 		if dst.Nullable() {
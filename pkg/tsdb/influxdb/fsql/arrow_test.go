@@ -11,10 +11,14 @@ import (
 	"github.com/apache/arrow/go/v13/arrow/array"
 	"github.com/apache/arrow/go/v13/arrow/memory"
 	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
 )
 
 func TestNewQueryDataResponse(t *testing.T) {
@@ -79,7 +83,7 @@ func TestNewQueryDataResponse(t *testing.T) {
 	assert.NoError(t, err)
 
 	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, metadata.MD{})
 	assert.NoError(t, resp.Error)
 	assert.Len(t, resp.Frames, 1)
 	assert.Len(t, resp.Frames[0].Fields, 13)
@@ -87,67 +91,67 @@ func TestNewQueryDataResponse(t *testing.T) {
 	frame := resp.Frames[0]
 	f0 := frame.Fields[0]
 	assert.Equal(t, f0.Name, "i8")
-	assert.Equal(t, f0.Type(), data.FieldTypeInt8)
+	assert.Equal(t, f0.Type(), data.FieldTypeNullableInt8)
 	assert.Equal(t, []int8{1, -2, 3}, extractFieldValues[int8](t, f0))
 
 	f1 := frame.Fields[1]
 	assert.Equal(t, f1.Name, "i16")
-	assert.Equal(t, f1.Type(), data.FieldTypeInt16)
+	assert.Equal(t, f1.Type(), data.FieldTypeNullableInt16)
 	assert.Equal(t, []int16{1, -2, 3}, extractFieldValues[int16](t, f1))
 
 	f2 := frame.Fields[2]
 	assert.Equal(t, f2.Name, "i32")
-	assert.Equal(t, f2.Type(), data.FieldTypeInt32)
+	assert.Equal(t, f2.Type(), data.FieldTypeNullableInt32)
 	assert.Equal(t, []int32{1, -2, 3}, extractFieldValues[int32](t, f2))
 
 	f3 := frame.Fields[3]
 	assert.Equal(t, f3.Name, "i64")
-	assert.Equal(t, f3.Type(), data.FieldTypeInt64)
+	assert.Equal(t, f3.Type(), data.FieldTypeNullableInt64)
 	assert.Equal(t, []int64{1, -2, 3}, extractFieldValues[int64](t, f3))
 
 	f4 := frame.Fields[4]
 	assert.Equal(t, f4.Name, "u8")
-	assert.Equal(t, f4.Type(), data.FieldTypeUint8)
+	assert.Equal(t, f4.Type(), data.FieldTypeNullableUint8)
 	assert.Equal(t, []uint8{1, 2, 3}, extractFieldValues[uint8](t, f4))
 
 	f5 := frame.Fields[5]
 	assert.Equal(t, f5.Name, "u16")
-	assert.Equal(t, f5.Type(), data.FieldTypeUint16)
+	assert.Equal(t, f5.Type(), data.FieldTypeNullableUint16)
 	assert.Equal(t, []uint16{1, 2, 3}, extractFieldValues[uint16](t, f5))
 
 	f6 := frame.Fields[6]
 	assert.Equal(t, f6.Name, "u32")
-	assert.Equal(t, f6.Type(), data.FieldTypeUint32)
+	assert.Equal(t, f6.Type(), data.FieldTypeNullableUint32)
 	assert.Equal(t, []uint32{1, 2, 3}, extractFieldValues[uint32](t, f6))
 
 	f7 := frame.Fields[7]
 	assert.Equal(t, f7.Name, "u64")
-	assert.Equal(t, f7.Type(), data.FieldTypeUint64)
+	assert.Equal(t, f7.Type(), data.FieldTypeNullableUint64)
 	assert.Equal(t, []uint64{1, 2, 3}, extractFieldValues[uint64](t, f7))
 
 	f8 := frame.Fields[8]
 	assert.Equal(t, f8.Name, "f32")
-	assert.Equal(t, f8.Type(), data.FieldTypeFloat32)
+	assert.Equal(t, f8.Type(), data.FieldTypeNullableFloat32)
 	assert.Equal(t, []float32{1.1, -2.2, 3.0}, extractFieldValues[float32](t, f8))
 
 	f9 := frame.Fields[9]
 	assert.Equal(t, f9.Name, "f64")
-	assert.Equal(t, f9.Type(), data.FieldTypeFloat64)
+	assert.Equal(t, f9.Type(), data.FieldTypeNullableFloat64)
 	assert.Equal(t, []float64{1.1, -2.2, 3.0}, extractFieldValues[float64](t, f9))
 
 	f10 := frame.Fields[10]
 	assert.Equal(t, f10.Name, "utf8")
-	assert.Equal(t, f10.Type(), data.FieldTypeString)
+	assert.Equal(t, f10.Type(), data.FieldTypeNullableString)
 	assert.Equal(t, []string{"foo", "bar", "baz"}, extractFieldValues[string](t, f10))
 
 	f11 := frame.Fields[11]
 	assert.Equal(t, f11.Name, "duration")
-	assert.Equal(t, f11.Type(), data.FieldTypeInt64)
+	assert.Equal(t, f11.Type(), data.FieldTypeNullableInt64)
 	assert.Equal(t, []int64{0, 1, -2}, extractFieldValues[int64](t, f11))
 
 	f12 := frame.Fields[12]
 	assert.Equal(t, f12.Name, "timestamp")
-	assert.Equal(t, f12.Type(), data.FieldTypeTime)
+	assert.Equal(t, f12.Type(), data.FieldTypeNullableTime)
 	assert.Equal(t,
 		[]time.Time{
 			time.Unix(0, 0).UTC(),
@@ -200,7 +204,7 @@ func TestNewQueryDataResponse_Error(t *testing.T) {
 		err:          fmt.Errorf("explosion!"),
 	}
 	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
-	resp := newQueryDataResponse(wrappedReader, query, metadata.MD{})
+	resp := newQueryDataResponse(wrappedReader, &queryModel{Query: &query}, metadata.MD{})
 	assert.Error(t, resp.Error)
 	assert.Equal(t, fmt.Errorf("explosion!"), resp.Error)
 }
@@ -240,7 +244,7 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 	reader, err := array.NewRecordReader(schema, records)
 	assert.NoError(t, err)
 
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, sqlutil.Query{}, metadata.MD{})
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &sqlutil.Query{}}, metadata.MD{})
 	assert.NoError(t, resp.Error)
 	assert.Len(t, resp.Frames, 1)
 	assert.Equal(t, 3, resp.Frames[0].Rows())
@@ -252,17 +256,17 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 	// label=bar
 	assert.Equal(t, "value", frame.Fields[1].Name)
 	assert.Equal(t, data.Labels{"label": "bar"}, frame.Fields[1].Labels)
-	assert.Equal(t, []int64{0, 2, 0}, extractFieldValues[int64](t, frame.Fields[1]))
+	assert.Equal(t, []*int64{nil, ptr[int64](2), nil}, fieldValues[*int64](frame.Fields[1]))
 
 	// label=baz
 	assert.Equal(t, "value", frame.Fields[2].Name)
 	assert.Equal(t, data.Labels{"label": "baz"}, frame.Fields[2].Labels)
-	assert.Equal(t, []int64{0, 0, 3}, extractFieldValues[int64](t, frame.Fields[2]))
+	assert.Equal(t, []*int64{nil, nil, ptr[int64](3)}, fieldValues[*int64](frame.Fields[2]))
 
 	// label=foo
 	assert.Equal(t, "value", frame.Fields[3].Name)
 	assert.Equal(t, data.Labels{"label": "foo"}, frame.Fields[3].Labels)
-	assert.Equal(t, []int64{1, 0, 0}, extractFieldValues[int64](t, frame.Fields[3]))
+	assert.Equal(t, []*int64{ptr[int64](1), nil, nil}, fieldValues[*int64](frame.Fields[3]))
 }
 
 func extractFieldValues[T any](t *testing.T, field *data.Field) []T {
@@ -270,7 +274,11 @@ func extractFieldValues[T any](t *testing.T, field *data.Field) []T {
 
 	values := make([]T, 0, field.Len())
 	for i := 0; i < cap(values); i++ {
-		values = append(values, field.CopyAt(i).(T))
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			t.Fatalf("unexpected null at %d in field %q", i, field.Name)
+		}
+		values = append(values, v.(T))
 	}
 	return values
 }
@@ -309,8 +317,8 @@ func TestNewFrame(t *testing.T) {
 	actual := newFrame(schema)
 	expected := &data.Frame{
 		Fields: []*data.Field{
-			data.NewField("name", nil, []string{}),
-			data.NewField("time", nil, []time.Time{}),
+			data.NewField("name", nil, []*string{}),
+			data.NewField("time", nil, []*time.Time{}),
 			data.NewField("extra", nil, []*int64{}),
 		},
 	}
@@ -319,6 +327,28 @@ func TestNewFrame(t *testing.T) {
 	}
 }
 
+func TestNewFrame_DuplicateNames(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "value", Type: &arrow.Int64Type{}},
+		{Name: "value", Type: &arrow.Int64Type{}},
+		{Name: "value_2", Type: &arrow.Int64Type{}},
+		{Name: "value", Type: &arrow.Int64Type{}},
+		{Name: "host", Type: &arrow.StringType{}},
+	}, nil)
+
+	frame := newFrame(schema)
+
+	var names []string
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"value", "value_3", "value_2", "value_4", "host"}, names)
+	assert.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityInfo,
+		Text:     "Duplicate column names were renamed: value as value_3, value as value_4",
+	}}, frame.Meta.Notices)
+}
+
 func cmpFrame(a, b data.Frame) bool {
 	if len(a.Fields) != len(b.Fields) {
 		return false
@@ -484,7 +514,7 @@ func TestCustomMetadata(t *testing.T) {
 	query := sqlutil.Query{
 		Format: sqlutil.FormatOptionTable,
 	}
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, query, md)
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, md)
 	assert.NoError(t, resp.Error)
 
 	assert.Equal(t, map[string]any{
@@ -494,3 +524,361 @@ func TestCustomMetadata(t *testing.T) {
 		},
 	}, resp.Frames[0].Meta.Custom)
 }
+
+func TestNewQueryDataResponse_UnsortedTimeSeries(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	reader := newTestRecordReader(t, schema,
+		`["2023-01-01T00:00:02Z", "2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z"]`,
+		`[3, 1, 2]`,
+	)
+
+	query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, metadata.MD{})
+	assert.NoError(t, resp.Error)
+
+	frame := resp.Frames[0]
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 1, 1, 0, 0, 1, 0, time.UTC),
+		time.Date(2023, 1, 1, 0, 0, 2, 0, time.UTC),
+	}, extractFieldValues[time.Time](t, frame.Fields[0]))
+	assert.Equal(t, []int64{1, 2, 3}, extractFieldValues[int64](t, frame.Fields[1]))
+}
+
+// newTestRecordReader returns a reader over a single record whose columns
+// are parsed from JSON according to the schema.
+func newTestRecordReader(t *testing.T, schema *arrow.Schema, columns ...string) array.RecordReader {
+	t.Helper()
+
+	arrs := make([]arrow.Array, 0, len(columns))
+	for i, col := range columns {
+		arr, _, err := array.FromJSON(memory.DefaultAllocator, schema.Field(i).Type, strings.NewReader(col))
+		if err != nil {
+			t.Fatal(err)
+		}
+		arrs = append(arrs, arr)
+	}
+
+	record := array.NewRecord(schema, arrs, -1)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestNewQueryDataResponse_ProjectColumns(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "host", Type: &arrow.StringType{}},
+			{Name: "payload", Type: &arrow.StringType{}},
+			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	cs := []struct {
+		name     string
+		sel      []string
+		excl     []string
+		expected []string
+	}{
+		{name: "no projection", expected: []string{"host", "payload", "value"}},
+		{name: "exclude", excl: []string{"payload"}, expected: []string{"host", "value"}},
+		{name: "select", sel: []string{"value", "host"}, expected: []string{"host", "value"}},
+		{name: "select and exclude", sel: []string{"host", "payload"}, excl: []string{"payload"}, expected: []string{"host"}},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			reader := newTestRecordReader(t, schema, `["a", "b"]`, `["{}", "{}"]`, `[1, 2]`)
+			query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
+			resp := newQueryDataResponse(projectColumns(errReader{RecordReader: reader}, c.sel, c.excl), &queryModel{Query: &query}, metadata.MD{})
+			assert.NoError(t, resp.Error)
+
+			var names []string
+			for _, f := range resp.Frames[0].Fields {
+				names = append(names, f.Name)
+				assert.Equal(t, 2, f.Len())
+			}
+			assert.Equal(t, c.expected, names)
+		})
+	}
+}
+
+func TestChunkRecords(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	newReader := func() recordReader {
+		return errReader{RecordReader: newTestRecordReader(t, schema, `[1, 2, 3, 4, 5]`)}
+	}
+
+	t.Run("chunks records", func(t *testing.T) {
+		reader := chunkRecords(newReader(), 2)
+		var sizes []int64
+		for reader.Next() {
+			sizes = append(sizes, reader.Record().NumRows())
+		}
+		assert.Equal(t, []int64{2, 2, 1}, sizes)
+	})
+
+	t.Run("converts every chunk", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
+		resp := newQueryDataResponse(chunkRecords(newReader(), 2), &queryModel{Query: &query}, metadata.MD{})
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, []int64{1, 2, 3, 4, 5}, extractFieldValues[int64](t, resp.Frames[0].Fields[0]))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		reader := newReader()
+		assert.Equal(t, reader, chunkRecords(reader, 0))
+	})
+}
+
+func TestPreferFields(t *testing.T) {
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("host", nil, []string{"a"}),
+			data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+			data.NewField("a", nil, []int64{1}),
+			data.NewField("b", nil, []int64{2}),
+			data.NewField("c", nil, []int64{3}),
+		)
+	}
+	fieldNames := func(frame *data.Frame) []string {
+		var names []string
+		for _, f := range frame.Fields {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	t.Run("no preferred fields", func(t *testing.T) {
+		frame := newFrame()
+		assert.Equal(t, 1, preferFields(frame, 1, []string{"missing"}))
+		assert.Equal(t, []string{"host", "time", "a", "b", "c"}, fieldNames(frame))
+	})
+
+	t.Run("preferred fields", func(t *testing.T) {
+		frame := newFrame()
+		assert.Equal(t, 3, preferFields(frame, 1, []string{"C", "b"}))
+		assert.Equal(t, []string{"c", "b", "host", "time", "a"}, fieldNames(frame))
+	})
+
+	t.Run("time field after other time fields", func(t *testing.T) {
+		frame := newFrame()
+		frame.Fields = append([]*data.Field{data.NewField("created", nil, []time.Time{time.Unix(0, 0)})}, frame.Fields...)
+		assert.Equal(t, 0, preferFields(frame, 2, nil))
+		assert.Equal(t, []string{"time", "created", "host", "a", "b", "c"}, fieldNames(frame))
+	})
+}
+
+func TestNewQueryDataResponse_TimeColumnDetection(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "usage", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "idle", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "TIMESTAMP", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+		},
+		nil,
+	)
+	newReader := func() recordReader {
+		return errReader{RecordReader: newTestRecordReader(t, schema, `[1, 2]`, `[3, 4]`, `["2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z"]`)}
+	}
+	fieldNames := func(frame *data.Frame) []string {
+		var names []string
+		for _, f := range frame.Fields {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	t.Run("without configured names", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		resp := newQueryDataResponse(newReader(), &queryModel{Query: &query}, metadata.MD{})
+		assert.EqualError(t, resp.Error, "no time column found")
+	})
+
+	t.Run("with configured names", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		qm := &queryModel{
+			Query:        &query,
+			TimeColumns:  []string{"time", "timestamp"},
+			ValueColumns: []string{"Idle"},
+		}
+		resp := newQueryDataResponse(newReader(), qm, metadata.MD{})
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, []string{"idle", "usage", "TIMESTAMP"}, fieldNames(resp.Frames[0]))
+	})
+
+	t.Run("time column matches ignoring case", func(t *testing.T) {
+		schema := arrow.NewSchema(
+			[]arrow.Field{
+				{Name: "Time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+				{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+			},
+			nil,
+		)
+		reader := newTestRecordReader(t, schema, `["2023-01-01T00:00:00Z"]`, `[1]`)
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, metadata.MD{})
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, []string{"Time", "value"}, fieldNames(resp.Frames[0]))
+	})
+	t.Run("time column chosen by the query", func(t *testing.T) {
+		schema := arrow.NewSchema(
+			[]arrow.Field{
+				{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+				{Name: "host", Type: arrow.BinaryTypes.String},
+				{Name: "created", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+				{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+			},
+			nil,
+		)
+		newReader := func() recordReader {
+			return errReader{RecordReader: newTestRecordReader(t, schema,
+				`["2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z"]`,
+				`["a", "a"]`,
+				`["2023-01-01T00:00:01Z", "2023-01-01T00:00:00Z"]`,
+				`[1, 2]`,
+			)}
+		}
+
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		resp := newQueryDataResponse(newReader(), &queryModel{Query: &query, TimeColumn: "created"}, metadata.MD{})
+		assert.NoError(t, resp.Error)
+		frame := resp.Frames[0]
+		assert.Equal(t, []string{"created", "time", "value"}, fieldNames(frame))
+		// Rows are sorted on the chosen time column.
+		assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), frame.Fields[0].At(0))
+		assert.Equal(t, ptr(time.Date(2023, 1, 1, 0, 0, 1, 0, time.UTC)), frame.Fields[1].At(0))
+		assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[1].Type())
+
+		resp = newQueryDataResponse(newReader(), &queryModel{Query: &query, TimeColumn: "missing"}, metadata.MD{})
+		assert.EqualError(t, resp.Error, `time column "missing" not found`)
+	})
+}
+
+func TestNewQueryDataResponse_PreferredVisualization(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+
+	for _, tc := range []struct {
+		format string
+		want   data.VisType
+	}{
+		{format: "time_series", want: data.VisTypeGraph},
+		{format: "table", want: data.VisTypeTable},
+		{format: "logs", want: data.VisTypeLogs},
+		{format: "trace", want: data.VisTypeTrace},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			qm, err := getQueryModel(backend.DataQuery{
+				JSON: []byte(fmt.Sprintf(`{"rawSql": "select 1", "format": %q}`, tc.format)),
+			}, &models.DatasourceInfo{})
+			require.NoError(t, err)
+
+			reader := newTestRecordReader(t, schema, `["2023-01-01T00:00:00Z"]`, `[1]`)
+			resp := newQueryDataResponse(errReader{RecordReader: reader}, qm, metadata.MD{})
+			require.NoError(t, resp.Error)
+			require.Equal(t, tc.want, resp.Frames[0].Meta.PreferredVisualization)
+		})
+	}
+}
+
+// panicReader is a [recordReader] panicking when reading its records.
+type panicReader struct {
+	errReader
+}
+
+func (r panicReader) Next() bool {
+	panic("unexpected record")
+}
+
+func TestNewQueryDataResponse_Panic(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "value", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	query := sqlutil.Query{RefID: "A", Format: sqlutil.FormatOptionTable}
+
+	t.Run("reading records", func(t *testing.T) {
+		reader := panicReader{errReader{RecordReader: newTestRecordReader(t, schema, `[1]`)}}
+		resp := newQueryDataResponse(reader, &queryModel{Query: &query}, metadata.MD{})
+		assert.Equal(t, backend.StatusInternal, resp.Status)
+		assert.EqualError(t, resp.Error, "failed to convert the results of query A: panic: unexpected record")
+		assert.Empty(t, resp.Frames)
+	})
+
+	t.Run("copying a column", func(t *testing.T) {
+		field := data.NewField("value", nil, []string{})
+		col := array.NewInt64Builder(memory.DefaultAllocator)
+		col.Append(1)
+		err := copyData(field, col.NewArray())
+
+		var panicErr *conversionPanic
+		require.ErrorAs(t, err, &panicErr)
+		assert.Contains(t, err.Error(), `column "value" of type int64: panic:`)
+	})
+}
+
+func TestNewQueryDataResponse_Nulls(t *testing.T) {
+	// Servers don't always mark the columns holding nulls as nullable.
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "value", Type: arrow.PrimitiveTypes.Float64},
+	}, nil)
+	reader := newTestRecordReader(t, schema,
+		`["2023-01-01T00:00:00Z", null]`,
+		`[null, "a"]`,
+		`[0, null]`,
+	)
+
+	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, metadata.MD{})
+	require.NoError(t, resp.Error)
+	frame := resp.Frames[0]
+	assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[0].Type())
+	assert.Equal(t, []*time.Time{ptr(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)), nil}, fieldValues[*time.Time](frame.Fields[0]))
+	assert.Equal(t, []*string{nil, ptr("a")}, fieldValues[*string](frame.Fields[1]))
+	assert.Equal(t, []*float64{ptr(0.0), nil}, fieldValues[*float64](frame.Fields[2]))
+}
+
+func TestNewQueryDataResponse_LargeTypes(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "large_string", Type: arrow.BinaryTypes.LargeString},
+		{Name: "binary", Type: arrow.BinaryTypes.Binary},
+		{Name: "large_binary", Type: arrow.BinaryTypes.LargeBinary},
+	}, nil)
+	// Binary values are base64 encoded in JSON, and in frames: 0xff isn't
+	// valid UTF-8.
+	reader := newTestRecordReader(t, schema,
+		`["`+strings.Repeat("a", 1<<16)+`", null, "b"]`,
+		`["Zm9v", null, "/w=="]`,
+		`[null, "YmFy", "AA=="]`,
+	)
+
+	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
+	resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query}, metadata.MD{})
+	require.NoError(t, resp.Error)
+	frame := resp.Frames[0]
+	for _, f := range frame.Fields {
+		assert.Equal(t, data.FieldTypeNullableString, f.Type(), f.Name)
+	}
+	assert.Equal(t, []*string{ptr(strings.Repeat("a", 1<<16)), nil, ptr("b")}, fieldValues[*string](frame.Fields[0]))
+	assert.Equal(t, []*string{ptr("Zm9v"), nil, ptr("/w==")}, fieldValues[*string](frame.Fields[1]))
+	assert.Equal(t, []*string{nil, ptr("YmFy"), ptr("AA==")}, fieldValues[*string](frame.Fields[2]))
+	_, err := frame.MarshalJSON()
+	require.NoError(t, err)
+}
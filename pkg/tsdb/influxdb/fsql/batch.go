@@ -0,0 +1,54 @@
+package fsql
+
+import (
+	"github.com/apache/arrow/go/v13/arrow"
+)
+
+// chunkRecords wraps the reader so that records larger than size rows are
+// converted in chunks of at most size rows, bounding the work done between
+// row limit checks regardless of the batch size chosen by the server. The
+// reader is returned as is when size is not positive.
+func chunkRecords(reader recordReader, size int) recordReader {
+	if size <= 0 {
+		return reader
+	}
+	return &chunkedReader{recordReader: reader, size: int64(size)}
+}
+
+// chunkedReader is a [recordReader] splitting the records of the underlying
+// reader into chunks of at most size rows.
+type chunkedReader struct {
+	recordReader
+	size int64
+
+	record  arrow.Record
+	offset  int64
+	current arrow.Record
+}
+
+func (r *chunkedReader) Next() bool {
+	if r.current != nil {
+		r.current.Release()
+		r.current = nil
+	}
+	for r.record == nil || r.offset >= r.record.NumRows() {
+		if !r.recordReader.Next() {
+			r.record = nil
+			return false
+		}
+		r.record = r.recordReader.Record()
+		r.offset = 0
+	}
+
+	end := r.offset + r.size
+	if end > r.record.NumRows() {
+		end = r.record.NumRows()
+	}
+	r.current = r.record.NewSlice(r.offset, end)
+	r.offset = end
+	return true
+}
+
+func (r *chunkedReader) Record() arrow.Record {
+	return r.current
+}
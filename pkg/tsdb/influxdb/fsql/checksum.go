@@ -0,0 +1,55 @@
+package fsql
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxChecksums bounds the number of query results whose checksum is kept
+// per connection.
+const maxChecksums = 1000
+
+// resultChecksum returns a checksum of the fields of the frames. Frame
+// metadata, which holds per-call details such as response headers, is not
+// part of it.
+func resultChecksum(frames data.Frames) (string, error) {
+	h := fnv.New64a()
+	for _, frame := range frames {
+		f := *frame
+		f.Meta = nil
+		b, err := f.MarshalArrow()
+		if err != nil {
+			return "", err
+		}
+		_, _ = h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumCache remembers the checksum of the last result of each query.
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+// swap records sum as the checksum of the last result of the query and
+// reports whether it is the same as the previous one.
+func (c *checksumCache) swap(query, sum string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev, ok := c.entries[query]
+	if !ok && len(c.entries) >= maxChecksums {
+		// Start over rather than tracking recency; this only costs a
+		// re-render of panels whose results didn't change.
+		c.entries = nil
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]string)
+	}
+	c.entries[query] = sum
+	return ok && prev == sum
+}
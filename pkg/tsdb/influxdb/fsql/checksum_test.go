@@ -0,0 +1,41 @@
+package fsql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultChecksum(t *testing.T) {
+	newFrames := func(values ...int64) data.Frames {
+		return data.Frames{data.NewFrame("", data.NewField("value", nil, values))}
+	}
+
+	a, err := resultChecksum(newFrames(1, 2))
+	require.NoError(t, err)
+
+	withMeta := newFrames(1, 2)
+	withMeta[0].Meta = &data.FrameMeta{Custom: map[string]any{"headers": "x"}}
+	b, err := resultChecksum(withMeta)
+	require.NoError(t, err)
+	require.Equal(t, a, b, "meta must not change the checksum")
+
+	c, err := resultChecksum(newFrames(1, 3))
+	require.NoError(t, err)
+	require.NotEqual(t, a, c)
+}
+
+func TestChecksumCache(t *testing.T) {
+	var c checksumCache
+	require.False(t, c.swap("q", "a"))
+	require.True(t, c.swap("q", "a"))
+	require.False(t, c.swap("q", "b"))
+	require.False(t, c.swap("other", "b"))
+
+	for i := 0; i < maxChecksums; i++ {
+		c.swap(fmt.Sprint(i), "a")
+	}
+	require.LessOrEqual(t, len(c.entries), maxChecksums)
+}
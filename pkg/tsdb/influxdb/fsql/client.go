@@ -2,7 +2,8 @@ package fsql
 
 import (
 	"context"
-	"crypto/x509"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"sync"
 
@@ -14,11 +15,14 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 type client struct {
 	*flightsql.Client
 	md metadata.MD
+	// addr is the address the client was dialed with.
+	addr string
 }
 
 // FlightClient returns the underlying [flight.Client].
@@ -26,30 +30,36 @@ func (c *client) FlightClient() flight.Client {
 	return c.Client.Client
 }
 
-func newFlightSQLClient(addr string, metadata metadata.MD, secure bool) (*client, error) {
-	dialOptions, err := grpcDialOptions(secure)
+// newFlightSQLClient dials addr, over TLS unless tlsConfig is nil.
+func newFlightSQLClient(addr string, metadata metadata.MD, tlsConfig *tls.Config, serviceConfig string, opts ...grpc.DialOption) (*client, error) {
+	dialOptions, err := grpcDialOptions(tlsConfig, serviceConfig)
 	if err != nil {
 		return nil, fmt.Errorf("grpc dial options: %s", err)
 	}
+	dialOptions = append(dialOptions, opts...)
 	fsqlClient, err := flightsql.NewClient(addr, nil, nil, dialOptions...)
 	if err != nil {
 		return nil, err
 	}
-	return &client{Client: fsqlClient, md: metadata}, nil
+	return &client{Client: fsqlClient, md: metadata, addr: addr}, nil
 }
 
-func grpcDialOptions(secure bool) ([]grpc.DialOption, error) {
+func grpcDialOptions(tlsConfig *tls.Config, serviceConfig string) ([]grpc.DialOption, error) {
 	transport := grpc.WithTransportCredentials(insecure.NewCredentials())
-	if secure {
-		pool, err := x509.SystemCertPool()
-		if err != nil {
-			return nil, fmt.Errorf("x509: %s", err)
-		}
-		transport = grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, ""))
+	if tlsConfig != nil {
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
 	}
 
 	opts := []grpc.DialOption{
 		transport,
+		grpc.WithStatsHandler(statsHandler{}),
+	}
+
+	if serviceConfig != "" {
+		if !json.Valid([]byte(serviceConfig)) {
+			return nil, fmt.Errorf("service config: invalid JSON")
+		}
+		opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig))
 	}
 
 	return opts, nil
@@ -92,6 +102,12 @@ func (s *flightReader) Header() (metadata.MD, error) {
 	return s.extractor.Header()
 }
 
+// Peer returns the address of the server which served the stream, once the
+// headers have been extracted.
+func (s *flightReader) Peer() string {
+	return s.extractor.peer
+}
+
 // headerExtractor collects the stream's headers on the first call to
 // [(*headerExtractor).Recv].
 type headerExtractor struct {
@@ -100,6 +116,7 @@ type headerExtractor struct {
 	once   sync.Once
 	header metadata.MD
 	err    error
+	peer   string
 }
 
 // Header returns the extracted headers if they exist.
@@ -108,10 +125,20 @@ func (s *headerExtractor) Header() (metadata.MD, error) {
 }
 
 // Recv reads from the stream. The first invocation will capture the headers.
+// Schemas with types the IPC reader can't read fail with an
+// [unsupportedTypeError].
 func (s *headerExtractor) Recv() (*flight.FlightData, error) {
 	data, err := s.stream.Recv()
 	s.once.Do(func() {
 		s.header, s.err = s.stream.Header()
+		if p, ok := peer.FromContext(s.stream.Context()); ok && p.Addr != nil {
+			s.peer = p.Addr.String()
+		}
 	})
+	if err == nil {
+		if err := checkSchemaMessage(data.DataHeader); err != nil {
+			return nil, err
+		}
+	}
 	return data, err
 }
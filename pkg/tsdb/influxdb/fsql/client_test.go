@@ -0,0 +1,39 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNewFlightSQLClient_ServiceConfig(t *testing.T) {
+	t.Run("valid service config", func(t *testing.T) {
+		cfg := `{
+			"methodConfig": [{
+				"name": [{"service": "arrow.flight.protocol.FlightService"}],
+				"timeout": "30s",
+				"retryPolicy": {
+					"maxAttempts": 3,
+					"initialBackoff": "0.1s",
+					"maxBackoff": "1s",
+					"backoffMultiplier": 2,
+					"retryableStatusCodes": ["UNAVAILABLE"]
+				}
+			}]
+		}`
+		c, err := newFlightSQLClient("localhost:12345", metadata.MD{}, nil, cfg)
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+	})
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		_, err := newFlightSQLClient("localhost:12345", metadata.MD{}, nil, `{"methodConfig": [`)
+		require.ErrorContains(t, err, "service config: invalid JSON")
+	})
+
+	t.Run("invalid service config", func(t *testing.T) {
+		_, err := newFlightSQLClient("localhost:12345", metadata.MD{}, nil, `{"loadBalancingConfig": [{"no_such_policy": {}}]}`)
+		require.Error(t, err)
+	})
+}
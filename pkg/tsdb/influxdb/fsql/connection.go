@@ -0,0 +1,335 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// warmUpTimeout bounds the background dial performed when a connection is
+// created.
+const warmUpTimeout = 30 * time.Second
+
+// drainTimeout is how long closing a connection waits for the in-flight
+// queries to finish before canceling them.
+const drainTimeout = 10 * time.Second
+
+// idleTimeout is how long the client of a connection may stay unused before
+// it is replaced on next use, so connections silently dropped by load
+// balancers and proxies in the meantime aren't reused.
+const idleTimeout = 10 * time.Minute
+
+// errConnectionClosed is returned when a query is issued on a connection
+// that has been closed.
+var errConnectionClosed = errors.New("flightsql: connection closed")
+
+// Connection is a FlightSQL client shared by every query of a datasource
+// instance. It is dialed when the instance is created and warmed up in the
+// background, so the first dashboard load doesn't pay the connection cost.
+// Clients left idle for idleTimeout, or whose calls found the server
+// unavailable, are replaced by a new one on next use.
+type Connection struct {
+	// dial dials a new client for the datasource.
+	dial func(opts ...grpc.DialOption) (*client, error)
+	// current is the client handed out to new users; see [acquireClient].
+	poolMu      sync.Mutex
+	current     *pooledClient
+	idleTimeout time.Duration
+
+	// ctx is canceled when the connection is closed and the in-flight
+	// queries didn't finish within drainTimeout, which cancels the calls
+	// bound to it.
+	ctx          context.Context
+	cancel       context.CancelFunc
+	drainTimeout time.Duration
+	closeOnce    sync.Once
+	closeErr     error
+	// warmUpCancel cancels the warm-up as soon as the connection is closed.
+	warmUpCancel context.CancelFunc
+	// streamCtx is canceled as soon as the connection is closed, ending the
+	// long-lived streams which would otherwise hold up the drain.
+	streamCtx    context.Context
+	streamCancel context.CancelFunc
+
+	// users tracks the callers currently using the client, so Close only
+	// closes it once they have returned.
+	usersMu sync.Mutex
+	users   sync.WaitGroup
+	closed  bool
+
+	// checksums holds the checksums of the last results of the queries,
+	// to tell when a result hasn't changed.
+	checksums checksumCache
+	// results holds the last results of queries over tables with a data
+	// version, to skip running them again while the data is unchanged.
+	results resultCache
+	// pushes holds the queries whose results are pushed by the server.
+	pushes pushRegistry
+	// streams holds the queries whose results are streamed with DoGet.
+	streams pushRegistry
+	// scheduler limits the number of query requests running at once.
+	scheduler *scheduler
+
+	mu             sync.RWMutex
+	warmedUp       bool
+	connectedSince time.Time
+	err            error
+}
+
+// ConnectionStatus is the outcome of the connection warm-up.
+type ConnectionStatus struct {
+	// Pending is set while the warm-up is still in progress.
+	Pending bool
+	// ConnectedSince is when the endpoint was first reached.
+	ConnectedSince time.Time
+	// Err is the error returned by the warm-up, if any.
+	Err error
+}
+
+// NewConnection dials the FlightSQL endpoint of the datasource and starts
+// warming up the connection in the background.
+func NewConnection(dsInfo *models.DatasourceInfo) (*Connection, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &Connection{
+		dial: func(opts ...grpc.DialOption) (*client, error) {
+			return clientFromDataSource(dsInfo, opts...)
+		},
+		idleTimeout:  idleTimeout,
+		ctx:          ctx,
+		cancel:       cancel,
+		drainTimeout: drainTimeout,
+		scheduler:    newScheduler(dsInfo.MaxConcurrentQueries),
+	}
+	var err error
+	if conn.current, err = conn.dialClient(); err != nil {
+		cancel()
+		return nil, err
+	}
+	conn.streamCtx, conn.streamCancel = context.WithCancel(ctx)
+	if err := conn.acquire(); err != nil {
+		return nil, err
+	}
+	warmUpCtx, warmUpCancel := context.WithTimeout(ctx, warmUpTimeout)
+	conn.warmUpCancel = warmUpCancel
+	go func() {
+		defer conn.release()
+		defer warmUpCancel()
+		conn.warmUp(warmUpCtx)
+	}()
+	return conn, nil
+}
+
+// acquire registers a user of the client. It fails once the connection has
+// been closed.
+func (c *Connection) acquire() error {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+	if c.closed {
+		return errConnectionClosed
+	}
+	c.users.Add(1)
+	return nil
+}
+
+// release unregisters a user of the client.
+func (c *Connection) release() {
+	c.users.Done()
+}
+
+// pooledClient is a client of a connection with its users.
+type pooledClient struct {
+	*client
+	// unavailable is set once a call failed to reach the server.
+	unavailable atomic.Bool
+
+	// The fields below are guarded by the poolMu of the connection.
+	users    int
+	lastUsed time.Time
+	// retired is set once the client has been replaced; it is closed as
+	// soon as its last user releases it.
+	retired bool
+}
+
+// dialClient dials a new client, watching its calls for an unavailable
+// server.
+func (c *Connection) dialClient() (*pooledClient, error) {
+	pc := &pooledClient{lastUsed: time.Now()}
+	cl, err := c.dial(
+		grpc.WithChainUnaryInterceptor(pc.watchUnary),
+		grpc.WithChainStreamInterceptor(pc.watchStream),
+	)
+	if err != nil {
+		return nil, err
+	}
+	pc.client = cl
+	return pc, nil
+}
+
+func (pc *pooledClient) watchUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	pc.watch(err)
+	return err
+}
+
+func (pc *pooledClient) watchStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	pc.watch(err)
+	return stream, err
+}
+
+func (pc *pooledClient) watch(err error) {
+	if status.Code(err) == codes.Unavailable {
+		pc.unavailable.Store(true)
+	}
+}
+
+// acquireClient returns the client of the connection for a new user, first
+// replacing it when it is idle or found the server unavailable. It must be
+// handed back with releaseClient.
+func (c *Connection) acquireClient() *pooledClient {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+
+	pc := c.current
+	idle := pc.users == 0 && time.Since(pc.lastUsed) > c.idleTimeout
+	if idle || pc.unavailable.Load() {
+		next, err := c.dialClient()
+		if err != nil {
+			glog.Warn("Failed to replace FlightSQL client", "err", err)
+		} else {
+			glog.Debug("Replacing FlightSQL client", "idle", idle)
+			c.retireLocked(pc)
+			c.current, pc = next, next
+		}
+	}
+	pc.users++
+	return pc
+}
+
+// releaseClient hands back a client returned by acquireClient.
+func (c *Connection) releaseClient(pc *pooledClient) {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	pc.users--
+	pc.lastUsed = time.Now()
+	if pc.retired && pc.users == 0 {
+		c.closeClient(pc)
+	}
+}
+
+func (c *Connection) retireLocked(pc *pooledClient) {
+	pc.retired = true
+	if pc.users == 0 {
+		c.closeClient(pc)
+	}
+}
+
+func (c *Connection) closeClient(pc *pooledClient) {
+	if err := pc.Close(); err != nil {
+		glog.Warn("Failed to close FlightSQL client", "err", err)
+	}
+}
+
+// warmUp issues a cheap metadata RPC to establish the underlying gRPC
+// connection and records the outcome.
+func (c *Connection) warmUp(ctx context.Context) {
+	pc := c.acquireClient()
+	if pc.md.Len() != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, pc.md)
+	}
+
+	_, err := pc.GetSqlInfo(ctx, []flightsql.SqlInfo{flightsql.SqlInfoFlightSqlServerName})
+	c.releaseClient(pc)
+	// Servers that don't implement GetSqlInfo still answered, which is all we
+	// need to know the connection is up.
+	if status.Code(err) == codes.Unimplemented {
+		err = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warmedUp = true
+	c.err = err
+	if err == nil {
+		c.connectedSince = time.Now()
+	} else {
+		glog.Warn("FlightSQL connection warm-up failed", "err", err)
+	}
+}
+
+// Status reports the outcome of the connection warm-up.
+func (c *Connection) Status() ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ConnectionStatus{
+		Pending:        !c.warmedUp,
+		ConnectedSince: c.connectedSince,
+		Err:            c.err,
+	}
+}
+
+// bind returns a context derived from ctx which is also canceled when the
+// connection is closed.
+func (c *Connection) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// bindStream returns a context derived from ctx which is also canceled as
+// soon as the connection starts closing, for streams not meant to end on
+// their own.
+func (c *Connection) bindStream(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.streamCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Close drains the connection and closes the underlying client. New queries
+// are refused at once, while the in-flight queries are given drainTimeout
+// to finish streaming their results before their calls are canceled, so
+// restarts don't cut responses short. Push streams are ended at once. It
+// is safe to call Close more than once.
+func (c *Connection) Close() error {
+	c.closeOnce.Do(func() {
+		c.usersMu.Lock()
+		c.closed = true
+		c.usersMu.Unlock()
+		c.warmUpCancel()
+		c.streamCancel()
+
+		done := make(chan struct{})
+		go func() {
+			c.users.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(c.drainTimeout):
+			glog.Warn("Canceling FlightSQL queries still running after the drain timeout", "timeout", c.drainTimeout)
+		}
+
+		c.cancel()
+		<-done
+		c.poolMu.Lock()
+		defer c.poolMu.Unlock()
+		c.closeErr = c.current.Close()
+	})
+	return c.closeErr
+}
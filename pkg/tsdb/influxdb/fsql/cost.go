@@ -0,0 +1,148 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// CostEstimate summarizes the cost of a query, estimated without running
+// it, so query editors can warn before launching expensive scans.
+type CostEstimate struct {
+	// SQL is the query estimated, with its macros expanded.
+	SQL string `json:"sql"`
+	// Plan is the physical plan of the query explained by the server.
+	Plan string `json:"plan"`
+	// Partitions, Files and Chunks are the number of file groups and of
+	// Parquet files scanned in parallel, and of in-memory chunks read, in
+	// the plan.
+	Partitions int `json:"partitions"`
+	Files      int `json:"files"`
+	Chunks     int `json:"chunks"`
+	// Bytes is the size of the data scanned, from the statistics of the
+	// scans of the plan, -1 when the plan has none.
+	Bytes int64 `json:"bytes"`
+}
+
+var (
+	planFileGroupsRegexp = regexp.MustCompile(`file_groups=\{(\d+) groups?:`)
+	planFileRegexp       = regexp.MustCompile(`\.parquet\b`)
+	planChunksRegexp     = regexp.MustCompile(`RecordBatchesExec: chunks=(\d+)`)
+	planScanRegexp       = regexp.MustCompile(`\b(?:ParquetExec|RecordBatchesExec|DataSourceExec|MemoryExec):`)
+	planBytesRegexp      = regexp.MustCompile(`\bBytes=(?:Exact|Inexact)\((\d+)\)`)
+)
+
+// EstimateCost estimates the cost of the SQL query from the plan explained
+// by the server. The query itself isn't executed: its parameters, if any,
+// are bound to the EXPLAIN statement.
+func EstimateCost(ctx context.Context, dsInfo *models.DatasourceInfo, query backend.DataQuery) (*CostEstimate, error) {
+	qm, err := getQueryModel(query, dsInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := runnerFromDataSource(dsInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			glog.Warn("Failed to close fsql client", "err", err)
+		}
+	}()
+	ctx, cancel := r.bind(ctx)
+	defer cancel()
+
+	if ctx, err = r.queryContext(ctx, dsInfo, qm); err != nil {
+		return nil, err
+	}
+
+	est := &CostEstimate{SQL: qm.RawSQL}
+	if est.Plan, err = r.explain(ctx, qm.RawSQL, qm.Params); err != nil {
+		return nil, fmt.Errorf("explain query: %s", errorMessage(err))
+	}
+	est.Partitions, est.Files, est.Chunks = planScans(est.Plan)
+	est.Bytes = planBytes(est.Plan)
+	return est, nil
+}
+
+// explain returns the physical plan of the query, explained with the
+// parameters bound.
+func (r *runner) explain(ctx context.Context, sql string, params []boundParam) (string, error) {
+	sql = "EXPLAIN " + sql
+	var info *flight.FlightInfo
+	if len(params) > 0 {
+		stmt, err := r.prepare(ctx, sql, params)
+		if err != nil {
+			return "", err
+		}
+		defer closeStatement(ctx, stmt)
+		if info, err = stmt.Execute(ctx); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		if info, err = r.client.Execute(ctx, sql); err != nil {
+			return "", err
+		}
+	}
+
+	var plan string
+	err := r.readEndpoints(ctx, info, func(record arrow.Record) error {
+		types, ok := stringColumn(record, "plan_type")
+		if !ok {
+			return fmt.Errorf("explain: missing plan_type column")
+		}
+		plans, ok := stringColumn(record, "plan")
+		if !ok {
+			return fmt.Errorf("explain: missing plan column")
+		}
+		for i := 0; i < types.Len(); i++ {
+			if types.Value(i) == "physical_plan" {
+				plan = plans.Value(i)
+			}
+		}
+		return nil
+	})
+	return plan, err
+}
+
+// planScans counts the file groups, Parquet files and in-memory chunks
+// scanned by the physical plan.
+func planScans(plan string) (partitions, files, chunks int) {
+	for _, m := range planFileGroupsRegexp.FindAllStringSubmatch(plan, -1) {
+		n, _ := strconv.Atoi(m[1])
+		partitions += n
+	}
+	files = len(planFileRegexp.FindAllStringIndex(plan, -1))
+	for _, m := range planChunksRegexp.FindAllStringSubmatch(plan, -1) {
+		n, _ := strconv.Atoi(m[1])
+		chunks += n
+	}
+	return partitions, files, chunks
+}
+
+// planBytes sums the sizes of the data scanned in the statistics of the
+// scans of the physical plan, which servers show when configured to. It
+// returns -1 when none of the scans has statistics.
+func planBytes(plan string) int64 {
+	bytes := int64(-1)
+	for _, line := range strings.Split(plan, "\n") {
+		if !planScanRegexp.MatchString(line) {
+			continue
+		}
+		if m := planBytesRegexp.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.ParseInt(m[1], 10, 64)
+			bytes = max(bytes, 0) + n
+		}
+	}
+	return bytes
+}
@@ -0,0 +1,34 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanScans(t *testing.T) {
+	plan := `ProjectionExec: expr=[host@0 as host, usage@1 as usage]
+  UnionExec
+    RecordBatchesExec: chunks=2, projection=[host, usage]
+    ParquetExec: file_groups={2 groups: [[1/1/a.parquet, 1/1/b.parquet], [1/1/c.parquet]]}, projection=[host, usage]
+`
+	partitions, files, chunks := planScans(plan)
+	require.Equal(t, 2, partitions)
+	require.Equal(t, 3, files)
+	require.Equal(t, 2, chunks)
+
+	partitions, files, chunks = planScans("")
+	require.Zero(t, partitions+files+chunks)
+}
+
+func TestPlanBytes(t *testing.T) {
+	plan := `ProjectionExec: expr=[host@0 as host], statistics=[Rows=Inexact(10), Bytes=Inexact(9999), [(Col[0]:)]]
+  UnionExec
+    RecordBatchesExec: chunks=2, projection=[host], statistics=[Rows=Exact(4), Bytes=Exact(100), [(Col[0]:)]]
+    ParquetExec: file_groups={1 group: [[1/1/a.parquet]]}, projection=[host], statistics=[Rows=Inexact(6), Bytes=Inexact(2048), [(Col[0]:)]]
+`
+	require.Equal(t, int64(2148), planBytes(plan))
+
+	require.Equal(t, int64(-1), planBytes(`ParquetExec: file_groups={1 group: [[1/1/a.parquet]]}, projection=[host]`))
+	require.Equal(t, int64(-1), planBytes(`ParquetExec: file_groups={1 group: [[1/1/a.parquet]]}, statistics=[Rows=Absent, Bytes=Absent, [(Col[0]:)]]`))
+}
@@ -0,0 +1,58 @@
+package fsql
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// CredentialsProvider creates the per-RPC credentials attached to every call
+// of the FlightSQL client of a datasource, such as request signatures. It is
+// called whenever a client is dialed for the datasource.
+type CredentialsProvider func(dsInfo *models.DatasourceInfo) (credentials.PerRPCCredentials, error)
+
+var (
+	credentialsProvidersMu sync.RWMutex
+	credentialsProviders   = map[string]CredentialsProvider{}
+)
+
+// RegisterCredentialsProvider makes a credentials provider available to
+// datasources whose auth scheme is name. It lets deployments plug in custom
+// authentication schemes without modifying the datasource. Registering a
+// name twice panics.
+func RegisterCredentialsProvider(name string, provider CredentialsProvider) {
+	credentialsProvidersMu.Lock()
+	defer credentialsProvidersMu.Unlock()
+
+	if provider == nil {
+		panic("fsql: nil credentials provider")
+	}
+	if _, ok := credentialsProviders[name]; ok {
+		panic(fmt.Sprintf("fsql: credentials provider %q registered twice", name))
+	}
+	credentialsProviders[name] = provider
+}
+
+// perRPCCredentials returns the per-RPC credentials of the auth scheme of
+// the datasource, or nil when it doesn't set one.
+func perRPCCredentials(dsInfo *models.DatasourceInfo) (credentials.PerRPCCredentials, error) {
+	if dsInfo.AuthScheme == "" {
+		return nil, nil
+	}
+
+	credentialsProvidersMu.RLock()
+	provider, ok := credentialsProviders[dsInfo.AuthScheme]
+	credentialsProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown auth scheme %q", dsInfo.AuthScheme)
+	}
+
+	creds, err := provider(dsInfo)
+	if err != nil {
+		return nil, fmt.Errorf("auth scheme %q: %w", dsInfo.AuthScheme, err)
+	}
+	return creds, nil
+}
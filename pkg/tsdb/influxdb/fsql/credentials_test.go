@@ -0,0 +1,60 @@
+package fsql
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// signingCredentials is a fake request signer counting the calls it signs.
+type signingCredentials struct {
+	key    string
+	signed atomic.Int64
+}
+
+func (c *signingCredentials) GetRequestMetadata(_ context.Context, uri ...string) (map[string]string, error) {
+	c.signed.Add(1)
+	return map[string]string{"x-signature": c.key}, nil
+}
+
+func (c *signingCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func TestPerRPCCredentials(t *testing.T) {
+	creds := &signingCredentials{key: "secret"}
+	RegisterCredentialsProvider("test-signing", func(dsInfo *models.DatasourceInfo) (credentials.PerRPCCredentials, error) {
+		return creds, nil
+	})
+
+	t.Run("no auth scheme", func(t *testing.T) {
+		c, err := perRPCCredentials(&models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.Nil(t, c)
+	})
+
+	t.Run("registered auth scheme", func(t *testing.T) {
+		c, err := perRPCCredentials(&models.DatasourceInfo{AuthScheme: "test-signing"})
+		require.NoError(t, err)
+		require.Equal(t, creds, c)
+	})
+
+	t.Run("unknown auth scheme", func(t *testing.T) {
+		_, err := perRPCCredentials(&models.DatasourceInfo{AuthScheme: "nope"})
+		require.EqualError(t, err, `unknown auth scheme "nope"`)
+	})
+
+	t.Run("registering twice panics", func(t *testing.T) {
+		require.Panics(t, func() {
+			RegisterCredentialsProvider("test-signing", func(*models.DatasourceInfo) (credentials.PerRPCCredentials, error) {
+				return nil, nil
+			})
+		})
+	})
+
+}
@@ -0,0 +1,99 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// databaseMetadataKey is the call metadata selecting the database queried.
+const databaseMetadataKey = "database"
+
+// databaseMetadataKeys are the call metadata understood by the server as
+// selecting the database or bucket queried.
+var databaseMetadataKeys = []string{databaseMetadataKey, "bucket", "bucket-name"}
+
+// queryContext returns the context of the calls made for the query, with the
+// call metadata of the client and the database queried. The database of the
+// query takes precedence over the metadata of the datasource, which falls
+// back to the default database configured on it. Dashboard variables in the
+// database metadata are interpolated. Without any database, the returned
+// error lists the databases available on the server.
+func (r *runner) queryContext(ctx context.Context, dsInfo *models.DatasourceInfo, qm *queryModel) (context.Context, error) {
+	md := r.client.md.Copy()
+	switch {
+	case qm.Database != "":
+		for _, k := range databaseMetadataKeys {
+			md.Delete(k)
+		}
+		md.Set(databaseMetadataKey, qm.Database)
+	case !hasDatabase(md):
+		db := dsInfo.DbName
+		if db == "" {
+			db = dsInfo.DefaultBucket
+		}
+		if db == "" {
+			return nil, r.noDatabaseError(metadata.NewOutgoingContext(ctx, md))
+		}
+		md.Set(databaseMetadataKey, db)
+	}
+	interpolateMetadata(md, qm.Variables)
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// hasDatabase reports whether the call metadata selects a database.
+func hasDatabase(md metadata.MD) bool {
+	for _, k := range databaseMetadataKeys {
+		for _, v := range md.Get(k) {
+			if v != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// noDatabaseError returns the error of a query without a database. The
+// databases available on the server are listed when it can tell.
+func (r *runner) noDatabaseError(ctx context.Context) error {
+	msg := "no database selected: set the database of the query, the database metadata or the default database of the datasource"
+
+	databases, err := r.listDatabases(ctx)
+	if err != nil {
+		glog.Debug("Failed to list databases", "err", err)
+		return errors.New(msg)
+	}
+	if len(databases) == 0 {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s (available databases: %s)", msg, strings.Join(databases, ", "))
+}
+
+// listDatabases returns the catalogs of the server.
+func (r *runner) listDatabases(ctx context.Context) ([]string, error) {
+	info, err := r.client.GetCatalogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []string
+	err = r.readEndpoints(ctx, info, func(record arrow.Record) error {
+		names, ok := stringColumn(record, "catalog_name")
+		if !ok {
+			return fmt.Errorf("get catalogs: missing catalog_name column")
+		}
+		for i := 0; i < names.Len(); i++ {
+			if names.IsValid(i) {
+				databases = append(databases, names.Value(i))
+			}
+		}
+		return nil
+	})
+	return databases, err
+}
@@ -0,0 +1,87 @@
+package fsql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestQueryContext(t *testing.T) {
+	cs := []struct {
+		name     string
+		md       metadata.MD
+		dsInfo   models.DatasourceInfo
+		database string
+		expected metadata.MD
+	}{
+		{
+			name:     "datasource metadata",
+			md:       metadata.Pairs("bucket", "b", "x", "y"),
+			dsInfo:   models.DatasourceInfo{DbName: "default"},
+			expected: metadata.Pairs("bucket", "b", "x", "y"),
+		},
+		{
+			name:     "default database",
+			md:       metadata.Pairs("x", "y"),
+			dsInfo:   models.DatasourceInfo{DbName: "default", DefaultBucket: "bucket"},
+			expected: metadata.Pairs("database", "default", "x", "y"),
+		},
+		{
+			name:     "default bucket",
+			md:       metadata.MD{},
+			dsInfo:   models.DatasourceInfo{DefaultBucket: "bucket"},
+			expected: metadata.Pairs("database", "bucket"),
+		},
+		{
+			name:     "query database",
+			md:       metadata.Pairs("bucket", "b", "database", "d"),
+			database: "q",
+			expected: metadata.Pairs("database", "q"),
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			r := &runner{client: &client{md: c.md}}
+			ctx, err := r.queryContext(context.Background(), &c.dsInfo, &queryModel{Database: c.database})
+			require.NoError(t, err)
+			md, _ := metadata.FromOutgoingContext(ctx)
+			require.Equal(t, c.expected, md)
+		})
+	}
+
+	t.Run("dashboard variables", func(t *testing.T) {
+		vars := map[string]string{"env": "prod"}
+		r := &runner{client: &client{md: metadata.Pairs("bucket", "${env}_metrics")}}
+		ctx, err := r.queryContext(context.Background(), &models.DatasourceInfo{}, &queryModel{Variables: vars})
+		require.NoError(t, err)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		require.Equal(t, metadata.Pairs("bucket", "prod_metrics"), md)
+		require.Equal(t, metadata.Pairs("bucket", "${env}_metrics"), r.client.md)
+
+		ctx, err = r.queryContext(context.Background(), &models.DatasourceInfo{}, &queryModel{Database: "[[env]]", Variables: vars})
+		require.NoError(t, err)
+		md, _ = metadata.FromOutgoingContext(ctx)
+		require.Equal(t, metadata.Pairs("database", "prod"), md)
+	})
+
+	t.Run("variables are only interpolated in database metadata", func(t *testing.T) {
+		vars := map[string]string{"env": "prod", "token": "stolen"}
+		r := &runner{client: &client{md: metadata.Pairs("database", "${env}", "authorization", "Bearer $token", "x-secret", "[[token]]")}}
+		ctx, err := r.queryContext(context.Background(), &models.DatasourceInfo{}, &queryModel{Variables: vars})
+		require.NoError(t, err)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		require.Equal(t, metadata.Pairs("database", "prod", "authorization", "Bearer $token", "x-secret", "[[token]]"), md)
+	})
+
+	t.Run("client metadata is not modified", func(t *testing.T) {
+		md := metadata.Pairs("x", "y")
+		r := &runner{client: &client{md: md}}
+		_, err := r.queryContext(context.Background(), &models.DatasourceInfo{DbName: "db"}, &queryModel{})
+		require.NoError(t, err)
+		require.Equal(t, metadata.Pairs("x", "y"), md)
+	})
+}
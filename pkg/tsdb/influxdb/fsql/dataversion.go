@@ -0,0 +1,172 @@
+package fsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/grpc/metadata"
+)
+
+// maxCachedResults bounds the number of query results kept per connection
+// for data version checks.
+const maxCachedResults = 100
+
+// dataVersionTableMacro is replaced with the name of each table read by a
+// query in the data version SQL of the datasource.
+const dataVersionTableMacro = "$__table"
+
+// dataVersions returns the data versions of the tables read by the query,
+// using the data version SQL configured on the datasource. It reports false
+// when versions can't be tracked for the query, in which case it must run.
+func (r *runner) dataVersions(ctx context.Context, versionSQL string, qm *queryModel) (string, bool) {
+	if r.conn == nil || versionSQL == "" {
+		return "", false
+	}
+
+	var versions []string
+	for _, table := range tableNames(qm.RawSQL) {
+		if table == adhocFilterCTE {
+			continue
+		}
+		sql := strings.ReplaceAll(versionSQL, dataVersionTableMacro, strings.ReplaceAll(table, "'", "''"))
+		version, err := r.queryScalar(ctx, sql)
+		if err != nil {
+			// Tables the server can't version, such as CTEs, make the
+			// query uncacheable.
+			glog.Debug("Failed to get table data version", "table", table, "err", err)
+			return "", false
+		}
+		versions = append(versions, fmt.Sprintf("%s=%s", table, version))
+	}
+	if len(versions) == 0 {
+		return "", false
+	}
+	return strings.Join(versions, ","), true
+}
+
+// queryScalar runs the SQL and returns the first value of its result.
+func (r *runner) queryScalar(ctx context.Context, sql string) (string, error) {
+	info, err := r.client.Execute(ctx, sql)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		value string
+		found bool
+	)
+	err = r.readEndpoints(ctx, info, func(record arrow.Record) error {
+		if found || record.NumRows() == 0 || record.NumCols() == 0 {
+			return nil
+		}
+		value, found = record.Column(0).ValueStr(0), true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("data version: empty result")
+	}
+	return value, nil
+}
+
+// resultCacheKey identifies a query run with the metadata of ctx and its
+// time range.
+func resultCacheKey(ctx context.Context, qm *queryModel) string {
+	return fmt.Sprintf("%d:%d:%s", qm.TimeRange.From.UnixNano(), qm.TimeRange.To.UnixNano(), queryKey(ctx, qm))
+}
+
+// queryKey identifies a query run with the metadata of ctx, whatever its
+// time range: it hashes the whole query model, so queries sharing their SQL
+// but reading another database or converting their results differently
+// don't share their results.
+func queryKey(ctx context.Context, qm *queryModel) string {
+	normalized := *qm
+	query := *qm.Query
+	query.TimeRange = backend.TimeRange{}
+	normalized.Query = &query
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	b, err := json.Marshal(struct {
+		Query    *queryModel
+		Params   string
+		Limits   string
+		Metadata metadata.MD
+	}{&normalized, paramsKey(qm.Params), fmt.Sprintf("%+v", qm.Limits), md})
+	if err != nil {
+		// Not expected: the query model only holds plain values.
+		b = []byte(fmt.Sprintf("%+v%v", normalized, md))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedResult is a query result and the data versions of its tables.
+type cachedResult struct {
+	versions string
+	resp     backend.DataResponse
+}
+
+// resultCache keeps the last result of queries over versioned tables.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+// get returns the cached result of the query if the data versions of its
+// tables haven't changed since. The frames of the result are shallow copies
+// marked as served from the cache.
+func (c *resultCache) get(key, versions string) (backend.DataResponse, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || entry.versions != versions {
+		return backend.DataResponse{}, false
+	}
+
+	frames := make(data.Frames, len(entry.resp.Frames))
+	for i, frame := range entry.resp.Frames {
+		f := *frame
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			if custom, ok := meta.Custom.(map[string]any); ok {
+				copied := make(map[string]any, len(custom)+1)
+				for k, v := range custom {
+					copied[k] = v
+				}
+				meta.Custom = copied
+			}
+			f.Meta = &meta
+		}
+		setCustomMeta(&f, "dataVersionCached", true)
+		setCustomMeta(&f, "unchanged", true)
+		frames[i] = &f
+	}
+	return backend.DataResponse{Frames: frames, Status: entry.resp.Status}, true
+}
+
+// put caches the result of the query for the given data versions.
+func (c *resultCache) put(key, versions string, resp backend.DataResponse) {
+	if resp.Error != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedResults {
+		c.entries = nil
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]cachedResult)
+	}
+	c.entries[key] = cachedResult{versions: versions, resp: resp}
+}
@@ -0,0 +1,49 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestResultCacheKey(t *testing.T) {
+	query := func(req queryRequest) *queryModel {
+		req.RefID = "A"
+		req.RawQuery = "select * from cpu"
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		qm, err := getQueryModel(backend.DataQuery{RefID: "A", JSON: b}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		return qm
+	}
+	withDatabase := func(db string) context.Context {
+		return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(databaseMetadataKey, db))
+	}
+
+	qm := query(queryRequest{Format: "table"})
+	key := resultCacheKey(withDatabase("prod"), qm)
+	assert.Equal(t, key, resultCacheKey(withDatabase("prod"), query(queryRequest{Format: "table"})))
+
+	// Queries sharing their SQL but reading another database...
+	assert.NotEqual(t, key, resultCacheKey(withDatabase("staging"), qm))
+	assert.NotEqual(t, queryKey(withDatabase("prod"), qm), queryKey(withDatabase("staging"), qm))
+	// ...or converting their results differently don't share their results.
+	for name, req := range map[string]queryRequest{
+		"hide time":       {Format: "table", HideTime: true},
+		"select columns":  {Format: "table", SelectColumns: []string{"usage"}},
+		"null handling":   {Format: "table", NullHandling: "zero"},
+		"max string size": {Format: "table", MaxStringLength: 10},
+		"pivot":           {Format: "table", Pivot: &pivotOptions{NameColumn: "name", ValueColumn: "value"}},
+	} {
+		other := query(req)
+		assert.NotEqual(t, key, resultCacheKey(withDatabase("prod"), other), name)
+		assert.NotEqual(t, queryKey(withDatabase("prod"), qm), queryKey(withDatabase("prod"), other), name)
+	}
+}
@@ -0,0 +1,274 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// endpointPrefetch is the number of records of each endpoint fetched ahead
+// of their reading.
+const endpointPrefetch = 4
+
+// endpointLocation returns the settings of the datasource served at the
+// first location of the endpoint the runner can dial, if any. Endpoints
+// without locations, or whose locations are the server of the runner (such
+// as arrow-flight-reuse-connection:), are fetched with the runner's client.
+// The servers of distributed results share the credentials of the
+// datasource.
+func (r *runner) endpointLocation(endpoint *flight.FlightEndpoint) (*models.DatasourceInfo, bool) {
+	if r.dsInfo == nil {
+		return nil, false
+	}
+	for _, loc := range endpoint.Location {
+		u, err := url.Parse(loc.GetUri())
+		if err != nil || u.Host == "" || u.Host == r.client.addr {
+			continue
+		}
+		var secure bool
+		switch u.Scheme {
+		case "grpc", "grpc+tcp":
+		case "grpc+tls":
+			secure = true
+		default:
+			continue
+		}
+
+		dsInfo := *r.dsInfo
+		dsInfo.URL = "http://" + u.Host
+		dsInfo.SecureGrpc = secure
+		return &dsInfo, true
+	}
+	return nil, false
+}
+
+// hasLocation tells whether the endpoint is fetched from another server than
+// the runner's.
+func (r *runner) hasLocation(endpoint *flight.FlightEndpoint) bool {
+	_, ok := r.endpointLocation(endpoint)
+	return ok
+}
+
+// endpointClient returns the client fetching the results of an endpoint: a
+// new client dialed to its location, if any, or the runner's client. The
+// returned function releases the client.
+func (r *runner) endpointClient(endpoint *flight.FlightEndpoint) (*client, func(), error) {
+	dsInfo, ok := r.endpointLocation(endpoint)
+	if !ok {
+		return r.client, func() {}, nil
+	}
+	c, err := clientFromDataSource(dsInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("endpoint location %s: %w", dsInfo.URL, err)
+	}
+	return c, func() { _ = c.Close() }, nil
+}
+
+// fetchEndpoints returns a reader of the results of all the endpoints of
+// info, in the order of the endpoints. The endpoints are fetched
+// concurrently, so results partitioned by the server over several endpoints
+// come in about the time of the slowest one rather than their sum, but only
+// a few records of each are fetched ahead of their reading: the memory used
+// is bounded whatever the size of the results, and reading stops at the row
+// limit. Reading fails once the results exceed maxBytes, when positive.
+func (r *runner) fetchEndpoints(ctx context.Context, info *flight.FlightInfo, maxBytes int64) (array.RecordReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	reader := &endpointsReader{
+		cancel:   cancel,
+		records:  make([]chan arrow.Record, len(info.Endpoint)),
+		errs:     make([]error, len(info.Endpoint)),
+		maxBytes: maxBytes,
+	}
+	reader.refs.Store(1)
+	for i, endpoint := range info.Endpoint {
+		i, endpoint := i, endpoint
+		records := make(chan arrow.Record, endpointPrefetch)
+		reader.records[i] = records
+		go func() {
+			defer close(records)
+			reader.errs[i] = r.fetchEndpoint(ctx, endpoint, func(record arrow.Record) error {
+				record.Retain()
+				select {
+				case records <- record:
+					return nil
+				case <-ctx.Done():
+					record.Release()
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	// The schema is the one of the records streamed or, without any
+	// record, the one announced, if any.
+	if reader.next() {
+		reader.schema = reader.pending.Schema()
+	} else if reader.err != nil {
+		reader.Release()
+		return nil, reader.err
+	} else if len(info.Schema) > 0 {
+		schema, err := deserializeSchema(info.Schema, memory.DefaultAllocator)
+		if err != nil {
+			reader.Release()
+			return nil, fmt.Errorf("results schema: %w", err)
+		}
+		reader.schema = schema
+	} else {
+		reader.schema = arrow.NewSchema(nil, nil)
+	}
+	return reader, nil
+}
+
+// fetchEndpoint streams the records of an endpoint to fn.
+func (r *runner) fetchEndpoint(ctx context.Context, endpoint *flight.FlightEndpoint, fn func(arrow.Record) error) error {
+	c, release, err := r.endpointClient(endpoint)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reader, err := c.DoGetWithHeaderExtraction(ctx, endpoint.Ticket)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+	for reader.Next() {
+		if err := fn(reader.Record()); err != nil {
+			return err
+		}
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// endpointsReader reads the records fetched from several endpoints, in the
+// order of the endpoints.
+type endpointsReader struct {
+	refs   atomic.Int64
+	schema *arrow.Schema
+	cancel context.CancelFunc
+	// records are the channels of the records of each endpoint, closed
+	// once errs holds the outcome of the endpoint.
+	records []chan arrow.Record
+	errs    []error
+	current int
+
+	// pending is the record read ahead to tell the schema.
+	pending  arrow.Record
+	record   arrow.Record
+	err      error
+	bytes    int64
+	maxBytes int64
+}
+
+func (r *endpointsReader) Retain() {
+	r.refs.Add(1)
+}
+
+func (r *endpointsReader) Release() {
+	if r.refs.Add(-1) != 0 {
+		return
+	}
+	r.cancel()
+	for _, records := range r.records {
+		for record := range records {
+			record.Release()
+		}
+	}
+	for _, record := range []arrow.Record{r.pending, r.record} {
+		if record != nil {
+			record.Release()
+		}
+	}
+	r.pending, r.record = nil, nil
+}
+
+func (r *endpointsReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+func (r *endpointsReader) Record() arrow.Record {
+	return r.record
+}
+
+func (r *endpointsReader) Err() error {
+	return r.err
+}
+
+func (r *endpointsReader) Next() bool {
+	if r.record != nil {
+		r.record.Release()
+		r.record = nil
+	}
+	if r.pending == nil && !r.next() {
+		return false
+	}
+	r.record, r.pending = r.pending, nil
+	if !r.record.Schema().Equal(r.schema) {
+		r.fail(fmt.Errorf("endpoint %d: schema differs from the first endpoint", r.current))
+		return false
+	}
+	return true
+}
+
+// next reads the next record into pending.
+func (r *endpointsReader) next() bool {
+	for r.err == nil && r.current < len(r.records) {
+		record, ok := <-r.records[r.current]
+		if !ok {
+			if err := r.errs[r.current]; err != nil {
+				r.fail(fmt.Errorf("endpoint %d: %w", r.current, err))
+				return false
+			}
+			r.current++
+			continue
+		}
+
+		r.pending = record
+		r.bytes += recordBytes(record)
+		if r.maxBytes > 0 && r.bytes > r.maxBytes {
+			r.fail(fmt.Errorf("results have more than %d bytes", r.maxBytes))
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// fail stops reading with err, and fetching the endpoints.
+func (r *endpointsReader) fail(err error) {
+	r.err = err
+	r.cancel()
+}
+
+// recordBytes returns the size of the buffers of the record.
+func recordBytes(record arrow.Record) int64 {
+	var n int64
+	var add func(arrow.ArrayData)
+	add = func(data arrow.ArrayData) {
+		for _, buf := range data.Buffers() {
+			if buf != nil {
+				n += int64(buf.Len())
+			}
+		}
+		for _, child := range data.Children() {
+			add(child)
+		}
+	}
+	for _, col := range record.Columns() {
+		add(col.Data())
+	}
+	return n
+}
@@ -0,0 +1,285 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/ipc"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// partitionServer is a Flight server returning the results of any query
+// over several endpoints, each streaming its index in records of one row
+// after a delay.
+type partitionServer struct {
+	flight.BaseFlightServer
+	endpoints int
+	// records is the number of records of each endpoint, 1 when 0.
+	records int
+	delay   time.Duration
+	// location is the address serving the endpoints, if not this server.
+	location string
+}
+
+var partitionSchema = arrow.NewSchema([]arrow.Field{{Name: "partition", Type: arrow.PrimitiveTypes.Int64}}, nil)
+
+func (s *partitionServer) GetFlightInfo(context.Context, *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	info := &flight.FlightInfo{Schema: flight.SerializeSchema(partitionSchema, memory.DefaultAllocator)}
+	for i := 0; i < s.endpoints; i++ {
+		endpoint := &flight.FlightEndpoint{Ticket: &flight.Ticket{Ticket: []byte(strconv.Itoa(i))}}
+		if s.location != "" {
+			endpoint.Location = []*flight.Location{{Uri: "grpc+tcp://" + s.location}}
+		}
+		info.Endpoint = append(info.Endpoint, endpoint)
+	}
+	return info, nil
+}
+
+func (s *partitionServer) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	time.Sleep(s.delay)
+	b := array.NewInt64Builder(memory.DefaultAllocator)
+	defer b.Release()
+	i, err := strconv.Atoi(string(ticket.Ticket))
+	if err != nil {
+		return err
+	}
+	b.Append(int64(i))
+	col := b.NewArray()
+	defer col.Release()
+	record := array.NewRecord(partitionSchema, []arrow.Array{col}, 1)
+	defer record.Release()
+
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(partitionSchema))
+	defer func() { _ = w.Close() }()
+	for n := 0; n < max(s.records, 1); n++ {
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startPartitionServer starts a partitionServer and returns its address.
+func startPartitionServer(tb testing.TB, endpoints int, delay time.Duration) string {
+	return startFlightServer(tb, &partitionServer{endpoints: endpoints, delay: delay})
+}
+
+// startFlightServer starts a Flight server and returns its address.
+func startFlightServer(tb testing.TB, service flight.FlightServer) string {
+	server := flight.NewServerWithMiddleware(nil)
+	server.RegisterFlightService(service)
+	require.NoError(tb, server.Init("localhost:0"))
+	go func() { _ = server.Serve() }()
+	tb.Cleanup(server.Shutdown)
+	return server.Addr().String()
+}
+
+func TestQuery_MultipleEndpoints(t *testing.T) {
+	dsInfo := &models.DatasourceInfo{URL: "http://" + startPartitionServer(t, 3, 0), DbName: "influxdb"}
+	conn, err := NewConnection(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	dsInfo.FlightSQL = conn
+
+	resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select partition from events")}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	frame := resp.Responses["A"].Frames[0]
+	// Records are merged in the order of the endpoints.
+	assert.Equal(t, []*int64{ptr(int64(0)), ptr(int64(1)), ptr(int64(2))}, fieldValues[*int64](frame.Fields[0]))
+	assert.Equal(t, 3, frame.Meta.Custom.(map[string]any)["flight"].(flightDetails).Partitions)
+}
+
+func TestQuery_EndpointLocations(t *testing.T) {
+	// The server planning the query hands out endpoints served by another
+	// one, which only knows their tickets.
+	partitions := startFlightServer(t, &partitionServer{endpoints: 2})
+	planner := startFlightServer(t, &partitionServer{endpoints: 2, location: partitions})
+	dsInfo := &models.DatasourceInfo{URL: "http://" + planner, DbName: "influxdb"}
+
+	r, err := runnerFromDataSource(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+	info, err := r.client.Execute(context.Background(), "select partition from events")
+	require.NoError(t, err)
+
+	c, release, err := r.endpointClient(info.Endpoint[0])
+	require.NoError(t, err)
+	defer release()
+	assert.Equal(t, partitions, c.addr)
+
+	for _, endpoints := range []int{1, 2} {
+		info.Endpoint = info.Endpoint[:endpoints]
+		reader, _, _, err := r.readResults(context.Background(), info, 0)
+		require.NoError(t, err)
+		var rows int64
+		for reader.Next() {
+			rows += reader.Record().NumRows()
+		}
+		require.NoError(t, reader.Err())
+		reader.Release()
+		assert.Equal(t, int64(endpoints), rows)
+	}
+
+	// Locations of the runner's server are fetched with its client.
+	c, release, err = r.endpointClient(&flight.FlightEndpoint{Location: []*flight.Location{{Uri: "grpc+tcp://" + planner}, {Uri: "arrow-flight-reuse-connection://?"}}})
+	require.NoError(t, err)
+	defer release()
+	assert.Same(t, r.client, c)
+}
+
+func TestFetchEndpoints_Bounded(t *testing.T) {
+	dsInfo := &models.DatasourceInfo{URL: "http://" + startFlightServer(t, &partitionServer{endpoints: 3, records: 1000}), DbName: "influxdb"}
+	r, err := runnerFromDataSource(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+	info, err := r.client.Execute(context.Background(), "select partition from events")
+	require.NoError(t, err)
+
+	t.Run("row limit", func(t *testing.T) {
+		reader, err := r.fetchEndpoints(context.Background(), info, 0)
+		require.NoError(t, err)
+		// Reading stops at the row limit, and the endpoints still
+		// streaming are canceled.
+		frame, err := frameForRecords(reader, queryLimits{maxRows: 10})
+		require.NoError(t, err)
+		assert.Less(t, frame.Rows(), 20)
+		assert.NotEmpty(t, frame.Meta.Notices)
+		done := make(chan struct{})
+		go func() {
+			reader.Release()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("release blocked on the endpoints")
+		}
+	})
+
+	t.Run("byte limit", func(t *testing.T) {
+		reader, err := r.fetchEndpoints(context.Background(), info, 100)
+		require.NoError(t, err)
+		defer reader.Release()
+		_, err = frameForRecords(reader, queryLimits{maxRows: rowLimit})
+		require.ErrorContains(t, err, "results have more than 100 bytes")
+	})
+}
+
+func TestConnection_ReplacesClients(t *testing.T) {
+	dsInfo := &models.DatasourceInfo{URL: "http://" + startPartitionServer(t, 1, 0), DbName: "influxdb"}
+	conn, err := NewConnection(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	// The warm-up uses the client too.
+	require.Eventually(t, func() bool { return !conn.Status().Pending }, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("in use", func(t *testing.T) {
+		first := conn.acquireClient()
+		second := conn.acquireClient()
+		assert.Same(t, first, second)
+		conn.releaseClient(first)
+		conn.releaseClient(second)
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		conn.idleTimeout = 0
+		defer func() { conn.idleTimeout = idleTimeout }()
+		idle := conn.current
+		pc := conn.acquireClient()
+		defer conn.releaseClient(pc)
+		assert.NotSame(t, idle, pc)
+		assert.True(t, idle.retired)
+	})
+}
+
+func TestConnection_ReplacesUnavailableClients(t *testing.T) {
+	// Nothing listens on the address of a closed listener.
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	require.NoError(t, lis.Close())
+
+	dsInfo := &models.DatasourceInfo{URL: "http://" + lis.Addr().String(), DbName: "influxdb"}
+	conn, err := NewConnection(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.Eventually(t, func() bool { return !conn.Status().Pending }, 5*time.Second, 10*time.Millisecond)
+	require.Error(t, conn.Status().Err)
+
+	unavailable := conn.current
+	assert.True(t, unavailable.unavailable.Load())
+	pc := conn.acquireClient()
+	defer conn.releaseClient(pc)
+	assert.NotSame(t, unavailable, pc)
+	assert.True(t, unavailable.retired)
+	assert.False(t, pc.unavailable.Load())
+}
+
+func BenchmarkFetchEndpoints(b *testing.B) {
+	dsInfo := &models.DatasourceInfo{URL: "http://" + startPartitionServer(b, 4, 5*time.Millisecond), DbName: "influxdb"}
+	r, err := runnerFromDataSource(dsInfo)
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = r.Close() })
+	ctx := context.Background()
+	info, err := r.client.Execute(ctx, "select partition from events")
+	require.NoError(b, err)
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := r.readEndpoints(ctx, info, func(arrow.Record) error { return nil })
+			require.NoError(b, err)
+		}
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			reader, err := r.fetchEndpoints(ctx, info, 0)
+			require.NoError(b, err)
+			for reader.Next() {
+			}
+			require.NoError(b, reader.Err())
+			reader.Release()
+		}
+	})
+}
+
+func BenchmarkQuery(b *testing.B) {
+	addr := startPartitionServer(b, 1, 0)
+	b.Run("dial per query", func(b *testing.B) {
+		dsInfo := &models.DatasourceInfo{URL: "http://" + addr, DbName: "influxdb"}
+		benchmarkQuery(b, dsInfo)
+	})
+	b.Run("pooled connection", func(b *testing.B) {
+		dsInfo := &models.DatasourceInfo{URL: "http://" + addr, DbName: "influxdb"}
+		conn, err := NewConnection(dsInfo)
+		require.NoError(b, err)
+		b.Cleanup(func() { _ = conn.Close() })
+		dsInfo.FlightSQL = conn
+		benchmarkQuery(b, dsInfo)
+	})
+}
+
+func benchmarkQuery(b *testing.B, dsInfo *models.DatasourceInfo) {
+	q, err := json.Marshal(queryRequest{RefID: "A", RawQuery: "select partition from events", Format: "table"})
+	require.NoError(b, err)
+	req := backend.QueryDataRequest{Queries: []backend.DataQuery{{RefID: "A", JSON: q}}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := Query(context.Background(), dsInfo, req)
+		require.NoError(b, err)
+		require.NoError(b, resp.Responses["A"].Error)
+	}
+}
@@ -0,0 +1,81 @@
+package fsql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// errorMessage renders an error returned by the FlightSQL client. When the
+// error is a gRPC status carrying google.rpc details (ErrorInfo, BadRequest,
+// ...), those details are unpacked into a structured message rather than
+// relying on the flattened status string.
+func errorMessage(err error) string {
+	var typeErr *unsupportedTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Sprintf("flightsql: %s", typeErr)
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Sprintf("flightsql: %s", err)
+	}
+
+	parts := []string{fmt.Sprintf("flightsql: %s: %s", st.Code(), st.Message())}
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			parts = append(parts, formatErrorInfo(d))
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				parts = append(parts, fmt.Sprintf("invalid field %q: %s", v.GetField(), v.GetDescription()))
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.GetViolations() {
+				parts = append(parts, fmt.Sprintf("precondition %s failed for %q: %s", v.GetType(), v.GetSubject(), v.GetDescription()))
+			}
+		case *errdetails.QuotaFailure:
+			for _, v := range d.GetViolations() {
+				parts = append(parts, fmt.Sprintf("quota exceeded for %q: %s", v.GetSubject(), v.GetDescription()))
+			}
+		case *errdetails.ResourceInfo:
+			parts = append(parts, fmt.Sprintf("resource %s %q: %s", d.GetResourceType(), d.GetResourceName(), d.GetDescription()))
+		case *errdetails.RetryInfo:
+			parts = append(parts, fmt.Sprintf("retry after %s", d.GetRetryDelay().AsDuration()))
+		case *errdetails.LocalizedMessage:
+			parts = append(parts, d.GetMessage())
+		case *errdetails.Help:
+			for _, l := range d.GetLinks() {
+				parts = append(parts, fmt.Sprintf("see %s (%s)", l.GetUrl(), l.GetDescription()))
+			}
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatErrorInfo(info *errdetails.ErrorInfo) string {
+	msg := fmt.Sprintf("reason: %s", info.GetReason())
+	if info.GetDomain() != "" {
+		msg += fmt.Sprintf(" (domain: %s)", info.GetDomain())
+	}
+	md := info.GetMetadata()
+	if len(md) == 0 {
+		return msg
+	}
+
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", k, md[k]))
+	}
+	return fmt.Sprintf("%s [%s]", msg, strings.Join(kvs, ", "))
+}
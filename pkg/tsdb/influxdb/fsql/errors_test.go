@@ -0,0 +1,48 @@
+package fsql
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorMessage(t *testing.T) {
+	t.Run("plain error", func(t *testing.T) {
+		require.Equal(t, "flightsql: boom", errorMessage(errors.New("boom")))
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		err := fmt.Errorf("arrow/ipc: could not read message schema: %w", &unsupportedTypeError{Column: "s", Type: "Utf8View"})
+		require.Equal(t, `flightsql: column "s" has type Utf8View, which the datasource can't read yet; cast it in the query, for example with arrow_cast(s, 'Utf8')`, errorMessage(err))
+	})
+
+	t.Run("status without details", func(t *testing.T) {
+		err := status.Error(codes.NotFound, "table not found")
+		require.Equal(t, "flightsql: NotFound: table not found", errorMessage(err))
+	})
+
+	t.Run("status with details", func(t *testing.T) {
+		st, err := status.New(codes.InvalidArgument, "bad query").WithDetails(
+			&errdetails.ErrorInfo{
+				Reason:   "UNKNOWN_COLUMN",
+				Domain:   "iox.influxdata.com",
+				Metadata: map[string]string{"table": "cpu", "column": "usr"},
+			},
+			&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "query", Description: "column usr does not exist"},
+				},
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t,
+			`flightsql: InvalidArgument: bad query; reason: UNKNOWN_COLUMN (domain: iox.influxdata.com) [column=usr, table=cpu]; invalid field "query": column usr does not exist`,
+			errorMessage(st.Err()),
+		)
+	})
+}
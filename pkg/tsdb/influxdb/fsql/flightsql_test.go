@@ -4,23 +4,60 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/apache/arrow/go/v13/arrow/flight"
 	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
 	"github.com/apache/arrow/go/v13/arrow/flight/flightsql/example"
 	"github.com/apache/arrow/go/v13/arrow/memory"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
 )
 
 type FSQLTestSuite struct {
 	suite.Suite
-	db     *sql.DB
-	server flight.Server
+	db         *sql.DB
+	server     flight.Server
+	statements *statementsServer
+}
+
+// statementsServer records the prepared statements created and closed by
+// the clients of the SQLite server.
+type statementsServer struct {
+	*example.SQLiteFlightSQLServer
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *statementsServer) CreatePreparedStatement(ctx context.Context, req flightsql.ActionCreatePreparedStatementRequest) (flightsql.ActionCreatePreparedStatementResult, error) {
+	s.record("create")
+	return s.SQLiteFlightSQLServer.CreatePreparedStatement(ctx, req)
+}
+
+func (s *statementsServer) ClosePreparedStatement(ctx context.Context, req flightsql.ActionClosePreparedStatementRequest) error {
+	s.record("close")
+	return s.SQLiteFlightSQLServer.ClosePreparedStatement(ctx, req)
+}
+
+func (s *statementsServer) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *statementsServer) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
 }
 
 func (suite *FSQLTestSuite) SetupTest() {
@@ -30,8 +67,9 @@ func (suite *FSQLTestSuite) SetupTest() {
 	sqliteServer, err := example.NewSQLiteFlightSQLServer(db)
 	require.NoError(suite.T(), err)
 	sqliteServer.Alloc = memory.NewCheckedAllocator(memory.DefaultAllocator)
+	statements := &statementsServer{SQLiteFlightSQLServer: sqliteServer}
 	server := flight.NewServerWithMiddleware(nil)
-	server.RegisterFlightService(flightsql.NewFlightServer(sqliteServer))
+	server.RegisterFlightService(flightsql.NewFlightServer(statements))
 	err = server.Init("localhost:12345")
 	require.NoError(suite.T(), err)
 	go func() {
@@ -40,6 +78,7 @@ func (suite *FSQLTestSuite) SetupTest() {
 	}()
 	suite.db = db
 	suite.server = server
+	suite.statements = statements
 }
 
 func (suite *FSQLTestSuite) AfterTest(suiteName, testName string) {
@@ -98,6 +137,508 @@ func (suite *FSQLTestSuite) TestIntegration_QueryData() {
 		for _, f := range frame.Fields {
 			require.Equal(suite.T(), 4, f.Len())
 		}
+
+		details, ok := frame.Meta.Custom.(map[string]any)["flight"].(flightDetails)
+		require.True(suite.T(), ok)
+		require.Equal(suite.T(), "127.0.0.1:12345", details.Address)
+		require.Equal(suite.T(), 1, details.Partitions)
+		require.Equal(suite.T(), 1, details.Tickets)
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_SchemaQuery() {
+	dsInfo := &models.DatasourceInfo{
+		URL:        "http://localhost:12345",
+		DbName:     "influxdb",
+		SecureGrpc: false,
+	}
+
+	suite.Run("should describe the table columns", func() {
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{
+					RefID:     "A",
+					QueryType: queryTypeSchema,
+					JSON:      []byte(`{"refId": "A", "table": "intTable"}`),
+				},
+			},
+		})
+		require.NoError(suite.T(), err)
+
+		respA := resp.Responses["A"]
+		require.NoError(suite.T(), respA.Error)
+		frame := respA.Frames[0]
+		require.Equal(suite.T(), 4, frame.Rows())
+
+		var columns []string
+		for i := 0; i < frame.Rows(); i++ {
+			require.Equal(suite.T(), "intTable", frame.Fields[0].At(i))
+			columns = append(columns, frame.Fields[1].At(i).(string))
+		}
+		require.Equal(suite.T(), []string{"id", "keyName", "value", "foreignId"}, columns)
+	})
+
+	suite.Run("should fail for unknown tables", func() {
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{
+					RefID:     "A",
+					QueryType: queryTypeSchema,
+					JSON:      []byte(`{"refId": "A", "table": "noSuchTable"}`),
+				},
+			},
+		})
+		require.NoError(suite.T(), err)
+		require.ErrorContains(suite.T(), resp.Responses["A"].Error, `table "noSuchTable" not found`)
+	})
+
+	suite.Run("should match the table name exactly", func() {
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{
+					RefID:     "A",
+					QueryType: queryTypeSchema,
+					JSON:      []byte(`{"refId": "A", "table": "%Table"}`),
+				},
+			},
+		})
+		require.NoError(suite.T(), err)
+		require.ErrorContains(suite.T(), resp.Responses["A"].Error, `table "%Table" not found`)
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Database() {
+	query := func(dsInfo *models.DatasourceInfo, json string) backend.DataResponse {
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(json)}},
+		})
+		require.NoError(suite.T(), err)
+		return resp.Responses["A"]
+	}
+
+	suite.Run("should fall back to the default database", func() {
+		dsInfo := &models.DatasourceInfo{URL: "http://localhost:12345", DefaultBucket: "bucket"}
+		resp := query(dsInfo, `{"refId": "A", "format": "table", "rawSql": "select 1"}`)
+		require.NoError(suite.T(), resp.Error)
+	})
+
+	suite.Run("should use the database of the query", func() {
+		dsInfo := &models.DatasourceInfo{URL: "http://localhost:12345"}
+		resp := query(dsInfo, `{"refId": "A", "format": "table", "rawSql": "select 1", "database": "main"}`)
+		require.NoError(suite.T(), resp.Error)
+	})
+
+	suite.Run("should list the databases when none is selected", func() {
+		dsInfo := &models.DatasourceInfo{URL: "http://localhost:12345"}
+		resp := query(dsInfo, `{"refId": "A", "format": "table", "rawSql": "select 1"}`)
+		require.Equal(suite.T(), backend.StatusBadRequest, resp.Status)
+		require.ErrorContains(suite.T(), resp.Error, "no database selected")
+		require.ErrorContains(suite.T(), resp.Error, "(available databases: main)")
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_PerRPCCredentials() {
+	suite.Run("should sign every call", func() {
+		creds := &signingCredentials{key: "secret"}
+		RegisterCredentialsProvider("test-signing-integration", func(*models.DatasourceInfo) (credentials.PerRPCCredentials, error) {
+			return creds, nil
+		})
+
+		resp, err := Query(context.Background(), &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			AuthScheme: "test-signing-integration",
+		}, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(suite.T(), "A", "select 1")}},
+		})
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), resp.Responses["A"].Error)
+		// GetFlightInfo and DoGet.
+		require.Equal(suite.T(), int64(2), creds.signed.Load())
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Connection() {
+	suite.Run("should warm up and reuse the instance connection", func() {
+		dsInfo := &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}
+		conn, err := NewConnection(dsInfo)
+		require.NoError(suite.T(), err)
+		defer func() { require.NoError(suite.T(), conn.Close()) }()
+		dsInfo.FlightSQL = conn
+
+		require.Eventually(suite.T(), func() bool {
+			return !conn.Status().Pending
+		}, 5*time.Second, 10*time.Millisecond)
+		st := conn.Status()
+		require.NoError(suite.T(), st.Err)
+		require.False(suite.T(), st.ConnectedSince.IsZero())
+
+		for i := 0; i < 2; i++ {
+			resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+				Queries: []backend.DataQuery{
+					{
+						RefID: "A",
+						JSON:  mustQueryJSON(suite.T(), "A", "select 1"),
+					},
+				},
+			})
+			require.NoError(suite.T(), err)
+			require.NoError(suite.T(), resp.Responses["A"].Error)
+
+			// The second identical query returns the same result.
+			custom := resp.Responses["A"].Frames[0].Meta.Custom.(map[string]any)
+			require.NotEmpty(suite.T(), custom["checksum"])
+			require.Equal(suite.T(), i > 0, custom["unchanged"])
+		}
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_PushUnsupported() {
+	suite.Run("should query once when the server doesn't support push", func() {
+		dsInfo := &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}
+		conn, err := NewConnection(dsInfo)
+		require.NoError(suite.T(), err)
+		defer func() { require.NoError(suite.T(), conn.Close()) }()
+		dsInfo.FlightSQL = conn
+
+		b, err := json.Marshal(queryRequest{RefID: "A", RawQuery: "select 1", Format: "table", Push: true})
+		require.NoError(suite.T(), err)
+		query := func() backend.DataResponse {
+			resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+				PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "influx"}},
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: b}},
+			})
+			require.NoError(suite.T(), err)
+			require.NoError(suite.T(), resp.Responses["A"].Error)
+			return resp.Responses["A"]
+		}
+
+		resp := query()
+		ch, err := live.ParseChannel(resp.Frames[0].Meta.Channel)
+		require.NoError(suite.T(), err)
+		err = RunPush(context.Background(), dsInfo, ch.Path, backend.NewStreamSender(make(packetSender, 1)))
+		require.ErrorIs(suite.T(), err, errPushUnsupported)
+
+		resp = query()
+		require.Empty(suite.T(), resp.Frames[0].Meta.Channel)
+		require.Equal(suite.T(), 1, resp.Frames[0].Rows())
+		require.Len(suite.T(), resp.Frames[0].Meta.Notices, 1)
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_DataVersion() {
+	suite.Run("should reuse results while the data version is unchanged", func() {
+		dsInfo := &models.DatasourceInfo{
+			URL:            "http://localhost:12345",
+			DbName:         "influxdb",
+			SecureGrpc:     false,
+			DataVersionSQL: "select count(*) from $__table",
+		}
+		conn, err := NewConnection(dsInfo)
+		require.NoError(suite.T(), err)
+		defer func() { require.NoError(suite.T(), conn.Close()) }()
+		dsInfo.FlightSQL = conn
+
+		query := func() backend.DataResponse {
+			resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+				Queries: []backend.DataQuery{
+					{
+						RefID: "A",
+						JSON:  mustQueryJSON(suite.T(), "A", "select * from intTable"),
+					},
+				},
+			})
+			require.NoError(suite.T(), err)
+			require.NoError(suite.T(), resp.Responses["A"].Error)
+			return resp.Responses["A"]
+		}
+		cached := func(resp backend.DataResponse) bool {
+			return resp.Frames[0].Meta.Custom.(map[string]any)["dataVersionCached"] == true
+		}
+
+		require.False(suite.T(), cached(query()))
+		resp := query()
+		require.True(suite.T(), cached(resp))
+		require.Equal(suite.T(), 4, resp.Frames[0].Rows())
+
+		_, err = suite.db.Exec(`INSERT INTO intTable (keyName, value, foreignId) VALUES ('two', 2, 1)`)
+		require.NoError(suite.T(), err)
+		resp = query()
+		require.False(suite.T(), cached(resp))
+		require.Equal(suite.T(), 5, resp.Frames[0].Rows())
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_SplitRanges() {
+	suite.Run("should merge the results of the chunks of the time range", func() {
+		_, err := suite.db.Exec(`CREATE TABLE events (ts TEXT, value INTEGER)`)
+		require.NoError(suite.T(), err)
+		_, err = suite.db.Exec(`INSERT INTO events (ts, value) VALUES
+			('2023-01-01T00:00:00Z', 1),
+			('2023-01-01T00:30:00Z', 2),
+			('2023-01-01T01:00:00Z', 3),
+			('2023-01-01T01:30:00Z', 4),
+			('2023-01-01T02:00:00Z', 5),
+			('2023-01-01T03:00:00Z', 6)`)
+		require.NoError(suite.T(), err)
+
+		resp, err := Query(context.Background(), &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{
+					RefID: "A",
+					JSON:  []byte(`{"refId": "A", "format": "table", "splitRanges": 4, "rawSql": "select value from events where $__timeFilter(ts) order by ts"}`),
+					TimeRange: backend.TimeRange{
+						From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+						To:   time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC),
+					},
+				},
+			},
+		})
+		require.NoError(suite.T(), err)
+
+		respA := resp.Responses["A"]
+		require.NoError(suite.T(), respA.Error)
+		require.Len(suite.T(), respA.Frames, 1)
+		frame := respA.Frames[0]
+		require.Equal(suite.T(), 4, frame.Meta.Custom.(map[string]any)["chunks"])
+		var values []int64
+		for i := 0; i < frame.Rows(); i++ {
+			v, _ := frame.Fields[0].ConcreteAt(i)
+			values = append(values, v.(int64))
+		}
+		require.Equal(suite.T(), []int64{1, 2, 3, 4, 5}, values)
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_EstimateCost() {
+	// The SQLite example server fails to explain queries, unlike DataFusion,
+	// whose plans the estimate is made from.
+	suite.Run("should fail without a plan", func() {
+		_, err := EstimateCost(context.Background(), &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}, backend.DataQuery{
+			RefID: "A",
+			JSON:  []byte(`{"refId": "A", "format": "table", "rawSql": "select * from intTable where $__timeFilter(value)"}`),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC),
+			},
+		})
+		require.ErrorContains(suite.T(), err, "explain query: ")
+	})
+
+	suite.Run("should explain queries with params", func() {
+		_, err := EstimateCost(context.Background(), &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}, backend.DataQuery{
+			RefID: "A",
+			JSON:  []byte(`{"refId": "A", "format": "table", "rawSql": "select * from intTable where keyName = ?", "params": [{"name": "name", "type": "string", "value": "one"}]}`),
+		})
+		require.ErrorContains(suite.T(), err, "explain query: ")
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Params() {
+	dsInfo := &models.DatasourceInfo{
+		URL:        "http://localhost:12345",
+		DbName:     "influxdb",
+		SecureGrpc: false,
+	}
+	query := func(name string) backend.DataResponse {
+		b, err := json.Marshal(queryRequest{
+			RefID:    "A",
+			RawQuery: "select keyName, value from intTable where keyName = ?",
+			Format:   "table",
+			Params:   []queryParam{{Name: "name", Type: "string", Value: json.RawMessage(strconv.Quote(name))}},
+		})
+		require.NoError(suite.T(), err)
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: b}},
+		})
+		require.NoError(suite.T(), err)
+		return resp.Responses["A"]
+	}
+
+	suite.Run("should bind params with a prepared statement", func() {
+		resp := query("one")
+		require.NoError(suite.T(), resp.Error)
+		require.Equal(suite.T(), 1, resp.Frames[0].Rows())
+		require.Equal(suite.T(), int64(1), *resp.Frames[0].Fields[1].At(0).(*int64))
+	})
+
+	suite.Run("should not interpolate params into the SQL", func() {
+		resp := query("one' OR '1'='1")
+		require.NoError(suite.T(), resp.Error)
+		for _, frame := range resp.Frames {
+			require.Equal(suite.T(), 0, frame.Rows())
+		}
+	})
+
+	suite.Run("should close the statement of each query before the next one", func() {
+		var queries []backend.DataQuery
+		for _, refID := range []string{"A", "B"} {
+			b, err := json.Marshal(queryRequest{
+				RefID:    refID,
+				RawQuery: "select keyName, value from intTable where keyName = ?",
+				Format:   "table",
+				Params:   []queryParam{{Name: "name", Type: "string", Value: json.RawMessage(`"one"`)}},
+			})
+			require.NoError(suite.T(), err)
+			queries = append(queries, backend.DataQuery{RefID: refID, JSON: b})
+		}
+
+		before := len(suite.statements.recorded())
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{Queries: queries})
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), resp.Responses["A"].Error)
+		require.NoError(suite.T(), resp.Responses["B"].Error)
+		require.Equal(suite.T(), []string{"create", "close", "create", "close"}, suite.statements.recorded()[before:])
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Metadata() {
+	dsInfo := &models.DatasourceInfo{
+		URL:        "http://localhost:12345",
+		DbName:     "influxdb",
+		SecureGrpc: false,
+	}
+
+	suite.Run("should list the schemas", func() {
+		schemas, err := Schemas(context.Background(), dsInfo, "")
+		require.NoError(suite.T(), err)
+		require.Equal(suite.T(), []DBSchema{{Catalog: "main", Name: ""}}, schemas)
+	})
+
+	suite.Run("should list the tables", func() {
+		tables, err := Tables(context.Background(), dsInfo, "", "")
+		require.NoError(suite.T(), err)
+		require.Contains(suite.T(), tables, Table{Catalog: "main", Name: "intTable", Type: "table"})
+		require.Contains(suite.T(), tables, Table{Catalog: "main", Name: "foreignTable", Type: "table"})
+	})
+
+	suite.Run("should match the schema name exactly", func() {
+		tables, err := Tables(context.Background(), dsInfo, "", "%")
+		require.NoError(suite.T(), err)
+		b, err := json.Marshal(tables)
+		require.NoError(suite.T(), err)
+		require.JSONEq(suite.T(), "[]", string(b))
+	})
+
+	suite.Run("should list the columns of a table", func() {
+		columns, err := Columns(context.Background(), dsInfo, "", "", "intTable")
+		require.NoError(suite.T(), err)
+		var names []string
+		for _, c := range columns {
+			names = append(names, c.Name)
+		}
+		require.Equal(suite.T(), []string{"id", "keyName", "value", "foreignId"}, names)
+		require.Equal(suite.T(), "int64", columns[0].Type)
+	})
+
+	suite.Run("should fail for unknown tables", func() {
+		_, err := Columns(context.Background(), dsInfo, "", "", "noSuchTable")
+		require.ErrorIs(suite.T(), err, ErrTableNotFound)
+	})
+
+	suite.Run("should match the table name exactly", func() {
+		_, err := Columns(context.Background(), dsInfo, "", "", "intTabl_")
+		require.ErrorIs(suite.T(), err, ErrTableNotFound)
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Dispose() {
+	suite.Run("should cancel in-flight calls when the instance is disposed", func() {
+		dsInfo := &models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		}
+		conn, err := NewConnection(dsInfo)
+		require.NoError(suite.T(), err)
+		dsInfo.FlightSQL = conn
+
+		ctx, cancel := conn.bind(context.Background())
+		defer cancel()
+
+		dsInfo.Dispose()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			suite.T().Fatal("bound context was not canceled")
+		}
+
+		// Disposing twice must be harmless.
+		dsInfo.Dispose()
+	})
+}
+
+func (suite *FSQLTestSuite) TestIntegration_Drain() {
+	newConnection := func(timeout time.Duration) *Connection {
+		conn, err := NewConnection(&models.DatasourceInfo{
+			URL:        "http://localhost:12345",
+			DbName:     "influxdb",
+			SecureGrpc: false,
+		})
+		require.NoError(suite.T(), err)
+		conn.drainTimeout = timeout
+		return conn
+	}
+
+	suite.Run("should let in-flight queries finish", func() {
+		conn := newConnection(time.Minute)
+		require.NoError(suite.T(), conn.acquire())
+		ctx, cancel := conn.bind(context.Background())
+		defer cancel()
+
+		closed := make(chan error)
+		go func() { closed <- conn.Close() }()
+
+		require.Eventually(suite.T(), func() bool {
+			conn.usersMu.Lock()
+			defer conn.usersMu.Unlock()
+			return conn.closed
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(suite.T(), errConnectionClosed, conn.acquire())
+		select {
+		case <-closed:
+			suite.T().Fatal("connection closed while a query was in flight")
+		case <-ctx.Done():
+			suite.T().Fatal("in-flight query canceled within the drain timeout")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		conn.release()
+		require.NoError(suite.T(), <-closed)
+	})
+
+	suite.Run("should cancel queries still running after the drain timeout", func() {
+		conn := newConnection(50 * time.Millisecond)
+		require.NoError(suite.T(), conn.acquire())
+		ctx, cancel := conn.bind(context.Background())
+		defer cancel()
+		go func() {
+			<-ctx.Done()
+			conn.release()
+		}()
+
+		require.NoError(suite.T(), conn.Close())
+		require.Error(suite.T(), ctx.Err())
 	})
 }
 
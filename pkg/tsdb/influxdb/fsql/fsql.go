@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"net/url"
 
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -30,59 +35,275 @@ func Query(ctx context.Context, dsInfo *models.DatasourceInfo, req backend.Query
 	if err != nil {
 		return tRes, err
 	}
-	defer func(client *client) {
-		err := client.Close()
+	defer func(r *runner) {
+		err := r.Close()
 		if err != nil {
 			logger.Warn("Failed to close fsql client", "err", err)
 		}
-	}(r.client)
+	}(r)
 
-	if r.client.md.Len() != 0 {
-		ctx = metadata.NewOutgoingContext(ctx, r.client.md)
+	ctx, cancel := r.bind(ctx)
+	defer cancel()
+
+	if r.conn != nil {
+		if err := r.conn.scheduler.acquire(ctx, requestPriority(req.Headers)); err != nil {
+			return tRes, err
+		}
+		defer r.conn.scheduler.release()
 	}
+	limits := requestLimits(dsInfo, req.Headers)
 
 	for _, q := range req.Queries {
-		qm, err := getQueryModel(q)
-		if err != nil {
-			tRes.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusInternal, "bad request")
-			continue
+		resp, stop := r.queryResponse(ctx, req.PluginContext, dsInfo, q, limits)
+		tRes.Responses[q.RefID] = resp
+		if stop {
+			break
 		}
+	}
 
-		logger.Info(fmt.Sprintf("InfluxDB executing SQL: %s", qm.RawSQL))
-		info, err := r.client.Execute(ctx, qm.RawSQL)
-		if err != nil {
-			tRes.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err))
-			return tRes, nil
+	return tRes, nil
+}
+
+// queryResponse runs the query q, within the timeout of the limits. Its
+// statement and results are released before it returns. stop tells whether
+// the remaining queries of the request should be skipped, the server having
+// failed to run the query.
+func (r *runner) queryResponse(ctx context.Context, pCtx backend.PluginContext, dsInfo *models.DatasourceInfo, q backend.DataQuery, limits queryLimits) (resp backend.DataResponse, stop bool) {
+	qm, err := getQueryModel(q, dsInfo)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("bad request: %s", err)), false
+	}
+	qm.Limits = limits
+
+	ctx, cancel := limits.withTimeout(ctx)
+	defer cancel()
+	ctx, err = r.queryContext(ctx, dsInfo, qm)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error()), false
+	}
+
+	if qm.QueryType == queryTypeSchema {
+		return r.tableSchemaResponse(ctx, qm.Table), false
+	}
+
+	// Servers refusing DoExchange have push queries run as usual.
+	var pushNotices []data.Notice
+	if qm.Push {
+		if r.conn != nil && r.conn.pushes.supported() {
+			return r.channelResponse(ctx, pCtx, qm, &r.conn.pushes, pushPathPrefix), false
 		}
-		if len(info.Endpoint) != 1 {
-			tRes.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("unsupported endpoint count in response: %d", len(info.Endpoint)))
-			return tRes, nil
+		pushNotices = append(pushNotices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "The results of the query can't be pushed by the server, they were queried once instead",
+		})
+	}
+
+	if qm.Stream && r.conn != nil {
+		return r.channelResponse(ctx, pCtx, qm, &r.conn.streams, streamPathPrefix), false
+	}
+
+	versions, versioned := r.dataVersions(ctx, dsInfo.DataVersionSQL, qm)
+	if versioned {
+		if cached, ok := r.conn.results.get(resultCacheKey(ctx, qm), versions); ok {
+			return cached, false
 		}
+	}
 
-		reader, err := r.client.DoGetWithHeaderExtraction(ctx, info.Endpoint[0].Ticket)
-		if err != nil {
-			tRes.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err))
-			return tRes, nil
+	if len(qm.Chunks) > 1 {
+		resp = r.splitResponse(ctx, dsInfo, qm)
+		if versioned && resp.Error == nil {
+			r.conn.results.put(resultCacheKey(ctx, qm), versions, resp)
 		}
-		defer reader.Release()
+		return resp, false
+	}
 
-		headers, err := reader.Header()
+	glog.FromContext(ctx).Info(fmt.Sprintf("InfluxDB executing SQL: %s", qm.RawSQL))
+	var (
+		info    *flight.FlightInfo
+		retries int
+	)
+	if len(qm.Params) > 0 {
+		var stmt *flightsql.PreparedStatement
+		stmt, err = r.prepare(ctx, qm.RawSQL, qm.Params)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to extract headers: %s", err))
+			return backend.ErrDataResponse(backend.StatusInternal, errorMessage(err)), true
 		}
+		defer closeStatement(ctx, stmt)
+		info, retries, err = executePrepared(ctx, stmt, limits.maxRetries)
+	} else {
+		info, retries, err = r.executeWithRetry(ctx, qm.RawSQL, limits.maxRetries)
+	}
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, errorMessage(err)), true
+	}
 
-		tRes.Responses[q.RefID] = newQueryDataResponse(reader, *qm.Query, headers)
+	est := estimateSize(info)
+	refused, notices := preflight(est, dsInfo)
+	notices = append(pushNotices, notices...)
+	if retries > 0 {
+		notices = append(notices, retryNotice(retries))
+	}
+	if refused != nil {
+		return *refused, false
 	}
 
-	return tRes, nil
+	reader, headers, peer, err := r.readResults(ctx, info, dsInfo.MaxResultBytes)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, errorMessage(err)), true
+	}
+	defer reader.Release()
+
+	resp = newQueryDataResponse(chunkRecords(projectColumns(validateRecords(reader), qm.SelectColumns, qm.ExcludeColumns), dsInfo.BatchSize), qm, headers)
+	transformResponse(&resp, qm)
+	r.markUnchanged(ctx, &resp, qm)
+	details := newFlightDetails(info, peer, r.client.addr)
+	for _, frame := range resp.Frames {
+		setCustomMeta(frame, "flight", details)
+		if est != nil {
+			setCustomMeta(frame, "estimate", est)
+		}
+		frame.AppendNotices(notices...)
+	}
+	if versioned {
+		r.conn.results.put(resultCacheKey(ctx, qm), versions, resp)
+	}
+	return resp, false
+}
+
+// flightDetails describes how the results of a query were served, so users
+// of distributed deployments can tell which querier served their panel.
+type flightDetails struct {
+	// Address is the address of the server which streamed the results.
+	Address string `json:"address"`
+	// Locations are the locations advertised by the endpoints, if any.
+	Locations []string `json:"locations,omitempty"`
+	// Partitions is the number of endpoints of the results.
+	Partitions int `json:"partitions"`
+	// Tickets is the number of tickets handed out for the results.
+	Tickets int `json:"tickets"`
+}
+
+// newFlightDetails describes the results of info streamed from peer. The
+// dialed address is reported when the peer is unknown.
+func newFlightDetails(info *flight.FlightInfo, peer, dialed string) flightDetails {
+	d := flightDetails{
+		Address:    peer,
+		Partitions: len(info.Endpoint),
+	}
+	if d.Address == "" {
+		d.Address = dialed
+	}
+	for _, endpoint := range info.Endpoint {
+		if endpoint.Ticket != nil {
+			d.Tickets++
+		}
+		for _, loc := range endpoint.Location {
+			d.Locations = append(d.Locations, loc.Uri)
+		}
+	}
+	return d
+}
+
+// readResults returns a reader of the results described by info, along with
+// the headers and the address of the peer streaming them when they come from
+// a single endpoint served by the runner's server. The results of several
+// endpoints are fetched concurrently, failing once they exceed maxBytes when
+// positive.
+func (r *runner) readResults(ctx context.Context, info *flight.FlightInfo, maxBytes int64) (array.RecordReader, metadata.MD, string, error) {
+	if len(info.Endpoint) != 1 || r.hasLocation(info.Endpoint[0]) {
+		reader, err := r.fetchEndpoints(ctx, info, maxBytes)
+		return reader, metadata.MD{}, "", err
+	}
+
+	reader, err := r.client.DoGetWithHeaderExtraction(ctx, info.Endpoint[0].Ticket)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	headers, err := reader.Header()
+	if err != nil {
+		glog.Error("Failed to extract headers", "err", err)
+	}
+	return reader, headers, reader.Peer(), nil
 }
 
 type runner struct {
 	client *client
+	// dsInfo is the datasource of the runner, whose settings are used to
+	// dial the locations of endpoints.
+	dsInfo *models.DatasourceInfo
+	// conn is set when the client belongs to the datasource instance's
+	// [Connection] and must outlive the runner.
+	conn   *Connection
+	pooled *pooledClient
+}
+
+// Close releases the runner's client unless it is shared with the instance.
+func (r *runner) Close() error {
+	if r.conn != nil {
+		r.conn.releaseClient(r.pooled)
+		r.conn.release()
+		return nil
+	}
+	return r.client.Close()
+}
+
+// bind returns a context for the runner's calls which is canceled when the
+// instance's connection is closed.
+func (r *runner) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.conn != nil {
+		return r.conn.bind(ctx)
+	}
+	return context.WithCancel(ctx)
+}
+
+// markUnchanged sets the checksum of the result in the meta of its frames.
+// When the instance has a [Connection], it also tells whether the result is
+// the same as the previous result of the query, so panels can skip
+// re-rendering it.
+func (r *runner) markUnchanged(ctx context.Context, resp *backend.DataResponse, qm *queryModel) {
+	if resp.Error != nil || len(resp.Frames) == 0 {
+		return
+	}
+	sum, err := resultChecksum(resp.Frames)
+	if err != nil {
+		glog.Warn("Failed to compute result checksum", "err", err)
+		return
+	}
+
+	unchanged := false
+	if r.conn != nil {
+		unchanged = r.conn.checksums.swap(queryKey(ctx, qm), sum)
+	}
+	for _, frame := range resp.Frames {
+		setCustomMeta(frame, "checksum", sum)
+		setCustomMeta(frame, "unchanged", unchanged)
+	}
 }
 
 // runnerFromDataSource creates a runner from the datasource model (the datasource instance's configuration).
+// When the instance holds a [Connection], its client is reused.
 func runnerFromDataSource(dsInfo *models.DatasourceInfo) (*runner, error) {
+	if conn, ok := dsInfo.FlightSQL.(*Connection); ok {
+		if err := conn.acquire(); err != nil {
+			return nil, err
+		}
+		pc := conn.acquireClient()
+		return &runner{client: pc.client, dsInfo: dsInfo, conn: conn, pooled: pc}, nil
+	}
+
+	fsqlClient, err := clientFromDataSource(dsInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runner{
+		client: fsqlClient,
+		dsInfo: dsInfo,
+	}, nil
+}
+
+// clientFromDataSource dials a new FlightSQL client for the datasource.
+func clientFromDataSource(dsInfo *models.DatasourceInfo, opts ...grpc.DialOption) (*client, error) {
 	if dsInfo.URL == "" {
 		return nil, fmt.Errorf("missing URL from datasource configuration")
 	}
@@ -109,16 +330,28 @@ func runnerFromDataSource(dsInfo *models.DatasourceInfo) (*runner, error) {
 		}
 	}
 
+	// Custom headers are set after the metadata, which they override, but
+	// not the token.
+	for k, v := range dsInfo.Headers {
+		md.Set(k, v)
+	}
+
 	if dsInfo.Token != "" {
 		md.Set("Authorization", fmt.Sprintf("Bearer %s", dsInfo.Token))
 	}
 
-	fsqlClient, err := newFlightSQLClient(addr, md, dsInfo.SecureGrpc)
+	creds, err := perRPCCredentials(dsInfo)
 	if err != nil {
 		return nil, err
 	}
+	if creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
 
-	return &runner{
-		client: fsqlClient,
-	}, nil
+	tlsConfig, err := clientTLSConfig(dsInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFlightSQLClient(addr, md, tlsConfig, dsInfo.GrpcServiceConfig, opts...)
 }
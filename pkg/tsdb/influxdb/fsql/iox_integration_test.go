@@ -0,0 +1,193 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// The IOx suite runs the queries of the datasource against a real InfluxDB 3
+// server, catching behaviors the SQLite example server doesn't reproduce.
+// Use the docker/blocks/influxdb3_tests/docker-compose.yaml to spin up an
+// InfluxDB 3 Core server suitable for running these tests, then:
+//
+//	GRAFANA_TEST_DB=influxdb3 go test -run TestIntegrationIOx ./pkg/tsdb/influxdb/fsql/
+//
+// The server is expected at localhost:8181 unless INFLUXDB3_HOST is set
+// (host:port).
+
+const ioxDatabase = "grafana"
+
+// ioxLines is the data written to the database before the suite runs.
+var ioxLines = []string{
+	"cpu,host=a usage=1.5 1672531200000000000",
+	"cpu,host=b usage=2.5 1672531200000000000",
+	"cpu,host=a usage=3.5 1672531260000000000",
+	"cpu,host=b usage=4.5 1672531260000000000",
+}
+
+type IOxTestSuite struct {
+	suite.Suite
+	dsInfo *models.DatasourceInfo
+}
+
+func TestIntegrationIOx(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	if !isTestDbIOx() {
+		t.Skip()
+	}
+	suite.Run(t, new(IOxTestSuite))
+}
+
+func isTestDbIOx() bool {
+	if db, present := os.LookupEnv("GRAFANA_TEST_DB"); present {
+		return db == "influxdb3"
+	}
+	return false
+}
+
+func (suite *IOxTestSuite) SetupSuite() {
+	t := suite.T()
+
+	host := os.Getenv("INFLUXDB3_HOST")
+	if host == "" {
+		host = "localhost:8181"
+	}
+	url := "http://" + host
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url + "/health")
+		if err != nil {
+			return false
+		}
+		defer func() { _ = resp.Body.Close() }()
+		return resp.StatusCode == http.StatusOK
+	}, time.Minute, time.Second, "InfluxDB 3 is not healthy at %s", url)
+
+	resp, err := http.Post(
+		fmt.Sprintf("%s/api/v3/write_lp?db=%s&precision=nanosecond", url, ioxDatabase),
+		"text/plain",
+		strings.NewReader(strings.Join(ioxLines, "\n")),
+	)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Less(t, resp.StatusCode, 300, "write: %s", resp.Status)
+
+	suite.dsInfo = &models.DatasourceInfo{
+		URL:        url,
+		DbName:     ioxDatabase,
+		Metadata:   []map[string]string{{"database": ioxDatabase}},
+		SecureGrpc: false,
+	}
+}
+
+func (suite *IOxTestSuite) query(refID, json string) backend.DataResponse {
+	resp, err := Query(context.Background(), suite.dsInfo, backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{
+				RefID: refID,
+				JSON:  []byte(json),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	})
+	require.NoError(suite.T(), err)
+	return resp.Responses[refID]
+}
+
+func (suite *IOxTestSuite) TestTable() {
+	resp := suite.query("A", `{"refId": "A", "format": "table", "rawSql": "select host, usage from cpu order by host, time"}`)
+	require.NoError(suite.T(), resp.Error)
+	require.Len(suite.T(), resp.Frames, 1)
+
+	frame := resp.Frames[0]
+	require.Equal(suite.T(), 4, frame.Rows())
+	require.Equal(suite.T(), "host", frame.Fields[0].Name)
+	require.Equal(suite.T(), "usage", frame.Fields[1].Name)
+}
+
+func (suite *IOxTestSuite) TestTimeSeries() {
+	resp := suite.query("A", `{"refId": "A", "format": "time_series", "rawSql": "select time, host, usage from cpu where $__timeFilter(time)"}`)
+	require.NoError(suite.T(), resp.Error)
+	require.Len(suite.T(), resp.Frames, 1)
+
+	frame := resp.Frames[0]
+	require.Equal(suite.T(), data.FrameTypeTimeSeriesWide, frame.Meta.Type)
+	// One value field per host.
+	require.Len(suite.T(), frame.Fields, 3)
+	require.Equal(suite.T(), 2, frame.Rows())
+}
+
+func (suite *IOxTestSuite) TestDateBin() {
+	resp := suite.query("A", `{"refId": "A", "format": "table", "intervalMs": 60000, "rawSql": "select $__dateBin(time) as t, avg(usage) as usage from cpu group by t order by t"}`)
+	require.NoError(suite.T(), resp.Error)
+	require.Len(suite.T(), resp.Frames, 1)
+	require.Equal(suite.T(), 2, resp.Frames[0].Rows())
+}
+
+func (suite *IOxTestSuite) TestAdhocFilters() {
+	hosts := func(filters string) []string {
+		resp := suite.query("A", `{"refId": "A", "format": "table", "rawSql": "select host, usage from cpu order by host", "adhocFilters": `+filters+`}`)
+		require.NoError(suite.T(), resp.Error)
+		require.Len(suite.T(), resp.Frames, 1)
+		var hosts []string
+		for i := 0; i < resp.Frames[0].Rows(); i++ {
+			v, _ := resp.Frames[0].Fields[0].ConcreteAt(i)
+			hosts = append(hosts, v.(string))
+		}
+		return hosts
+	}
+
+	suite.Run("filters scoped to the table", func() {
+		require.Equal(suite.T(), []string{"a", "a"}, hosts(`[{"key": "cpu.host", "operator": "=", "value": "a"}]`))
+	})
+	suite.Run("filters scoped to other tables", func() {
+		require.Equal(suite.T(), []string{"a", "a", "b", "b"}, hosts(`[{"key": "disk.host", "operator": "=", "value": "a"}]`))
+	})
+	suite.Run("filters with an explicit table", func() {
+		require.Equal(suite.T(), []string{"b", "b"}, hosts(`[{"key": "host", "operator": "=", "value": "b", "table": "cpu"}]`))
+	})
+}
+
+func (suite *IOxTestSuite) TestSchemaQuery() {
+	resp, err := Query(context.Background(), suite.dsInfo, backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{
+				RefID:     "A",
+				QueryType: queryTypeSchema,
+				JSON:      []byte(`{"refId": "A", "table": "cpu"}`),
+			},
+		},
+	})
+	require.NoError(suite.T(), err)
+
+	respA := resp.Responses["A"]
+	require.NoError(suite.T(), respA.Error)
+	columns := respA.Frames[0].Fields[1]
+	var names []string
+	for i := 0; i < columns.Len(); i++ {
+		names = append(names, columns.At(i).(string))
+	}
+	require.Subset(suite.T(), names, []string{"host", "time", "usage"})
+}
+
+func (suite *IOxTestSuite) TestError() {
+	resp := suite.query("A", `{"refId": "A", "format": "table", "rawSql": "select nope from cpu"}`)
+	require.Error(suite.T(), resp.Error)
+	require.Equal(suite.T(), backend.StatusInternal, resp.Status)
+}
@@ -0,0 +1,130 @@
+package fsql
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// unsupportedTypes are the types added to the Arrow format after the Arrow
+// version used here, by their ID in the flatbuffers schema of IPC messages
+// (Schema.fbs). Its IPC reader fails on results with columns of these
+// types, so schemas are checked for them before being read.
+var unsupportedTypes = map[byte]string{
+	23: "BinaryView",
+	24: "Utf8View",
+	25: "ListView",
+	26: "LargeListView",
+}
+
+// Slots of the flatbuffers tables of IPC schema messages (Message.fbs and
+// Schema.fbs).
+const (
+	messageHeaderTypeSlot = 6
+	messageHeaderSlot     = 8
+	messageHeaderSchema   = 1
+	schemaFieldsSlot      = 6
+	fieldNameSlot         = 4
+	fieldTypeTypeSlot     = 8
+	fieldChildrenSlot     = 14
+)
+
+// unsupportedTypeError is returned for results with columns of a type the
+// datasource can't read.
+type unsupportedTypeError struct {
+	Column string
+	Type   string
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return fmt.Sprintf("column %q has type %s, which the datasource can't read yet; cast it in the query, for example with arrow_cast(%s, 'Utf8')",
+		e.Column, e.Type, e.Column)
+}
+
+// checkSchemaMessage returns an [unsupportedTypeError] when the IPC message,
+// a flatbuffers Message as found in the header of Flight data, is a schema
+// with fields of unsupported types. Other messages, and messages which can't
+// be parsed, are left to the IPC reader.
+func checkSchemaMessage(msg []byte) (err error) {
+	if len(msg) < flatbuffers.SizeUOffsetT {
+		return nil
+	}
+	defer func() {
+		// Malformed messages are reported by the IPC reader.
+		if recover() != nil {
+			err = nil
+		}
+	}()
+
+	message := flatbuffers.Table{Bytes: msg, Pos: flatbuffers.GetUOffsetT(msg)}
+	if message.GetByteSlot(messageHeaderTypeSlot, 0) != messageHeaderSchema {
+		return nil
+	}
+	o := message.Offset(messageHeaderSlot)
+	if o == 0 {
+		return nil
+	}
+	var schema flatbuffers.Table
+	message.Union(&schema, flatbuffers.UOffsetT(o))
+	return checkFields(&schema, schemaFieldsSlot, "")
+}
+
+// checkFields checks the types of the fields in the vector at slot of the
+// table, and of their children.
+func checkFields(table *flatbuffers.Table, slot flatbuffers.VOffsetT, parent string) error {
+	o := flatbuffers.UOffsetT(table.Offset(slot))
+	if o == 0 {
+		return nil
+	}
+	fields := table.Vector(o)
+	for i := 0; i < table.VectorLen(o); i++ {
+		field := flatbuffers.Table{Bytes: table.Bytes}
+		field.Pos = table.Indirect(fields + flatbuffers.UOffsetT(i)*flatbuffers.SizeUOffsetT)
+
+		name := parent
+		if o := field.Offset(fieldNameSlot); o != 0 {
+			if name != "" {
+				name += "."
+			}
+			name += field.String(field.Pos + flatbuffers.UOffsetT(o))
+		}
+		if typ, ok := unsupportedTypes[field.GetByteSlot(fieldTypeTypeSlot, 0)]; ok {
+			return &unsupportedTypeError{Column: name, Type: typ}
+		}
+		if err := checkFields(&field, fieldChildrenSlot, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSchema is [checkSchemaMessage] for schemas serialized in the IPC
+// encapsulated format, such as the schemas of FlightInfo.
+func checkSchema(b []byte) error {
+	// The message is prefixed by its length, itself prefixed by a
+	// continuation marker since format version 0.15.
+	if len(b) >= 8 && binary.LittleEndian.Uint32(b) == 0xFFFFFFFF {
+		b = b[4:]
+	}
+	if len(b) < 4 {
+		return nil
+	}
+	n := binary.LittleEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil
+	}
+	return checkSchemaMessage(b[4 : 4+n])
+}
+
+// deserializeSchema is [flight.DeserializeSchema], failing with an
+// [unsupportedTypeError] for schemas with types it can't read.
+func deserializeSchema(b []byte, mem memory.Allocator) (*arrow.Schema, error) {
+	if err := checkSchema(b); err != nil {
+		return nil, err
+	}
+	return flight.DeserializeSchema(b, mem)
+}
@@ -0,0 +1,62 @@
+package fsql
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/require"
+)
+
+// viewSchema returns the serialized schema of a string column s and of a
+// struct column st with a string child c, with the type of the field at the
+// path changed to Utf8View, which arrow.NewSchema can't build.
+func viewSchema(t *testing.T, path ...int) []byte {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "s", Type: arrow.BinaryTypes.String},
+		{Name: "st", Type: arrow.StructOf(arrow.Field{Name: "c", Type: arrow.BinaryTypes.String})},
+	}, nil)
+	b := flight.SerializeSchema(schema, memory.DefaultAllocator)
+	if len(path) == 0 {
+		return b
+	}
+
+	// The message follows the continuation marker and its length.
+	msg := b[8 : 8+binary.LittleEndian.Uint32(b[4:])]
+	message := flatbuffers.Table{Bytes: msg, Pos: flatbuffers.GetUOffsetT(msg)}
+	var table flatbuffers.Table
+	message.Union(&table, flatbuffers.UOffsetT(message.Offset(messageHeaderSlot)))
+	slot := flatbuffers.VOffsetT(schemaFieldsSlot)
+	for _, i := range path {
+		o := flatbuffers.UOffsetT(table.Offset(slot))
+		table.Pos = table.Indirect(table.Vector(o) + flatbuffers.UOffsetT(i)*flatbuffers.SizeUOffsetT)
+		slot = fieldChildrenSlot
+	}
+	require.True(t, table.MutateByteSlot(fieldTypeTypeSlot, 24))
+	return b
+}
+
+func TestCheckSchema(t *testing.T) {
+	require.NoError(t, checkSchema(viewSchema(t)))
+	require.NoError(t, checkSchema(nil))
+
+	err := checkSchema(viewSchema(t, 0))
+	require.Equal(t, &unsupportedTypeError{Column: "s", Type: "Utf8View"}, err)
+
+	err = checkSchema(viewSchema(t, 1, 0))
+	require.Equal(t, &unsupportedTypeError{Column: "st.c", Type: "Utf8View"}, err)
+}
+
+func TestDeserializeSchema(t *testing.T) {
+	schema, err := deserializeSchema(viewSchema(t), memory.DefaultAllocator)
+	require.NoError(t, err)
+	require.Len(t, schema.Fields(), 2)
+
+	_, err = deserializeSchema(viewSchema(t, 0), memory.DefaultAllocator)
+	var typeErr *unsupportedTypeError
+	require.ErrorAs(t, err, &typeErr)
+}
@@ -0,0 +1,64 @@
+package fsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// Default limits of the queries of alert rules.
+const (
+	defaultAlertQueryTimeout = 30 * time.Second
+	defaultAlertMaxRetries   = 3
+)
+
+// queryLimits bound the execution of the queries of a request. Alert
+// evaluations need bounded, deterministic behavior, while interactive
+// queries get best-effort results.
+type queryLimits struct {
+	// timeout bounds each query; zero leaves it to the request.
+	timeout time.Duration
+	// maxRows is the number of rows results are limited to.
+	maxRows int64
+	// strict fails queries whose results exceed maxRows instead of
+	// truncating them.
+	strict bool
+	// maxRetries bounds the number of times a query the server asks to
+	// retry later is executed again.
+	maxRetries int
+}
+
+// requestLimits returns the limits of the queries of a request with the
+// given headers: the alert limits of the datasource for alert evaluations.
+func requestLimits(dsInfo *models.DatasourceInfo, headers map[string]string) queryLimits {
+	if requestPriority(headers) != priorityAlert {
+		return queryLimits{maxRows: rowLimit, maxRetries: defaultMaxRetries}
+	}
+
+	l := queryLimits{
+		timeout:    defaultAlertQueryTimeout,
+		maxRows:    rowLimit,
+		strict:     true,
+		maxRetries: defaultAlertMaxRetries,
+	}
+	if dsInfo.AlertQueryTimeout > 0 {
+		l.timeout = time.Duration(dsInfo.AlertQueryTimeout) * time.Second
+	}
+	if dsInfo.AlertMaxRows > 0 {
+		l.maxRows = dsInfo.AlertMaxRows
+	}
+	if dsInfo.AlertMaxRetries > 0 {
+		l.maxRetries = dsInfo.AlertMaxRetries
+	}
+	return l
+}
+
+// withTimeout returns a context for a query bounded by the timeout of the
+// limits, if any.
+func (l queryLimits) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, l.timeout)
+}
@@ -0,0 +1,63 @@
+package fsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestRequestLimits(t *testing.T) {
+	alert := map[string]string{"FromAlert": "true"}
+
+	assert.Equal(t, queryLimits{maxRows: rowLimit, maxRetries: defaultMaxRetries}, requestLimits(&models.DatasourceInfo{AlertMaxRows: 10}, nil))
+	assert.Equal(t, queryLimits{
+		timeout:    defaultAlertQueryTimeout,
+		maxRows:    rowLimit,
+		strict:     true,
+		maxRetries: defaultAlertMaxRetries,
+	}, requestLimits(&models.DatasourceInfo{}, alert))
+	assert.Equal(t, queryLimits{
+		timeout:    5 * time.Second,
+		maxRows:    10,
+		strict:     true,
+		maxRetries: 1,
+	}, requestLimits(&models.DatasourceInfo{AlertQueryTimeout: 5, AlertMaxRows: 10, AlertMaxRetries: 1}, alert))
+}
+
+func TestNewQueryDataResponse_RowLimit(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "value", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
+
+	t.Run("truncated", func(t *testing.T) {
+		reader := newTestRecordReader(t, schema, `[1, 2, 3, 4]`)
+		resp := newQueryDataResponse(reader, &queryModel{Query: &query, Limits: queryLimits{maxRows: 1}}, metadata.MD{})
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		assert.Equal(t, 4, resp.Frames[0].Rows())
+		require.Len(t, resp.Frames[0].Meta.Notices, 1)
+		assert.Equal(t, data.NoticeSeverityWarning, resp.Frames[0].Meta.Notices[0].Severity)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		reader := newTestRecordReader(t, schema, `[1, 2, 3, 4]`)
+		resp := newQueryDataResponse(reader, &queryModel{Query: &query, Limits: queryLimits{maxRows: 3, strict: true}}, metadata.MD{})
+		require.ErrorIs(t, resp.Error, errRowLimit)
+		assert.Empty(t, resp.Frames)
+	})
+
+	t.Run("strict within limit", func(t *testing.T) {
+		reader := newTestRecordReader(t, schema, `[1, 2, 3, 4]`)
+		resp := newQueryDataResponse(reader, &queryModel{Query: &query, Limits: queryLimits{maxRows: 4, strict: true}}, metadata.MD{})
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		assert.Equal(t, 4, resp.Frames[0].Rows())
+	})
+}
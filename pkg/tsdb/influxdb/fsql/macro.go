@@ -2,79 +2,112 @@ package fsql
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 )
 
-var macros = sqlutil.Macros{
-	"dateBin":        macroDateBin(""),
-	"dateBinAlias":   macroDateBin("_binned"),
-	"interval":       macroInterval,
-	"timeGroup":      macroTimeGroup,
-	"timeGroupAlias": macroTimeGroupAlias,
-
-	// The behaviors of timeFrom and timeTo as defined in the SDK are different
-	// from all other Grafana SQL plugins. Instead we'll take the implementations,
-	// rename them and define timeFrom and timeTo ourselves.
-	"timeTo":   macroTo,
-	"timeFrom": macroFrom,
-}
+var macros = newMacros(time.UTC)
+
+// newMacros returns the macros of queries made from a dashboard in the
+// given timezone.
+func newMacros(loc *time.Location) sqlutil.Macros {
+	return sqlutil.Macros{
+		"dateBin":        macroDateBin("", loc),
+		"dateBinAlias":   macroDateBin("_binned", loc),
+		"interval":       macroInterval,
+		"timeGroup":      macroTimeGroup(false, loc),
+		"timeGroupAlias": macroTimeGroup(true, loc),
 
-func macroTimeGroup(query *sqlutil.Query, args []string) (string, error) {
-	if len(args) != 2 {
-		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		// The behaviors of timeFrom and timeTo as defined in the SDK are different
+		// from all other Grafana SQL plugins. Instead we'll take the implementations,
+		// rename them and define timeFrom and timeTo ourselves.
+		"timeTo":   macroTo,
+		"timeFrom": macroFrom,
 	}
+}
 
-	column := args[0]
+// plainMacroRegexp matches the macros taking no arguments, with the opening
+// parenthesis following them, if any.
+var plainMacroRegexp = regexp.MustCompile(`\$__(interval|timeFrom|timeTo)\b(\s*\()?`)
 
-	res := ""
-	switch args[1] {
-	case "minute":
-		res += fmt.Sprintf("datepart('minute', %s),", column)
-		fallthrough
-	case "hour":
-		res += fmt.Sprintf("datepart('hour', %s),", column)
-		fallthrough
-	case "day":
-		res += fmt.Sprintf("datepart('day', %s),", column)
-		fallthrough
-	case "month":
-		res += fmt.Sprintf("datepart('month', %s),", column)
-		fallthrough
-	case "year":
-		res += fmt.Sprintf("datepart('year', %s)", column)
+// interpolateMacros expands the macros of the SQL of the query. The macros
+// taking no arguments are expanded first: the SDK takes the next
+// parenthesis following a macro, even further in the query, for its
+// arguments, breaking queries like
+// "time >= $__timeFrom and $__timeFilter(time)".
+func interpolateMacros(query *sqlutil.Query, macros sqlutil.Macros) (string, error) {
+	var err error
+	sql := plainMacroRegexp.ReplaceAllStringFunc(query.RawSQL, func(match string) string {
+		m := plainMacroRegexp.FindStringSubmatch(match)
+		if m[2] != "" || err != nil {
+			return match
+		}
+		expanded, macroErr := macros[m[1]](query, nil)
+		if macroErr != nil {
+			err = macroErr
+			return match
+		}
+		return expanded
+	})
+	if err != nil {
+		return "", err
 	}
-
-	return res, nil
+	return sqlutil.Interpolate(query.WithSQL(sql), macros)
 }
 
-func macroTimeGroupAlias(query *sqlutil.Query, args []string) (string, error) {
-	if len(args) != 2 {
-		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
-	}
+// timeGroupParts are the date parts $__timeGroup groups on, from the most
+// to the least precise.
+var timeGroupParts = []string{"minute", "hour", "day", "month", "year"}
 
-	column := args[0]
+// queryIntervalRegexp matches the expansion of $__interval, which may be
+// expanded before the macros taking it as argument.
+var queryIntervalRegexp = regexp.MustCompile(`^interval '\d+ second'$`)
 
-	res := ""
-	switch args[1] {
-	case "minute":
-		res += fmt.Sprintf("datepart('minute', %s) as %s_minute,", column, column)
-		fallthrough
-	case "hour":
-		res += fmt.Sprintf("datepart('hour', %s) as %s_hour,", column, column)
-		fallthrough
-	case "day":
-		res += fmt.Sprintf("datepart('day', %s) as %s_day,", column, column)
-		fallthrough
-	case "month":
-		res += fmt.Sprintf("datepart('month', %s) as %s_month,", column, column)
-		fallthrough
-	case "year":
-		res += fmt.Sprintf("datepart('year', %s) as %s_year", column, column)
-	}
+// macroTimeGroup groups the column by its date parts down to the precision
+// given by the second argument (minute, hour, day, month or year), each
+// aliased as <column>_<part> with alias. Other arguments are bin widths, 5m
+// or $__interval for instance, binning the column like $__dateBin.
+func macroTimeGroup(alias bool, loc *time.Location) sqlutil.MacroFunc {
+	return func(query *sqlutil.Query, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		}
 
-	return res, nil
+		column, width := args[0], strings.TrimSpace(args[1])
+		if width == "$__interval" || queryIntervalRegexp.MatchString(width) {
+			width = ""
+		}
+		width = strings.Trim(width, `'"`)
+		for i, part := range timeGroupParts {
+			if part != width {
+				continue
+			}
+			exprs := make([]string, 0, len(timeGroupParts)-i)
+			for _, p := range timeGroupParts[i:] {
+				expr := fmt.Sprintf("datepart('%s', %s)", p, column)
+				if alias {
+					expr += fmt.Sprintf(" as %s_%s", column, p)
+				}
+				exprs = append(exprs, expr)
+			}
+			return strings.Join(exprs, ","), nil
+		}
+
+		suffix := ""
+		if alias {
+			suffix = "_binned"
+		}
+		binArgs := []string{column}
+		if width != "" {
+			binArgs = append(binArgs, width)
+		}
+		return macroDateBin(suffix, loc)(query, binArgs)
+	}
 }
 
 func macroInterval(query *sqlutil.Query, _ []string) (string, error) {
@@ -83,18 +116,23 @@ func macroInterval(query *sqlutil.Query, _ []string) (string, error) {
 
 // https://docs.influxdata.com/influxdb/cloud-serverless/query-data/sql/cast-types/?t=CAST%28%29#cast-to-a-timestamp-type
 func macroFrom(query *sqlutil.Query, _ []string) (string, error) {
-	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.From.Format(time.RFC3339)), nil
+	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.From.UTC().Format(time.RFC3339)), nil
 }
 
 // https://docs.influxdata.com/influxdb/cloud-serverless/query-data/sql/cast-types/?t=CAST%28%29#cast-to-a-timestamp-type
 func macroTo(query *sqlutil.Query, _ []string) (string, error) {
-	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.To.Format(time.RFC3339)), nil
+	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.To.UTC().Format(time.RFC3339)), nil
 }
 
-func macroDateBin(suffix string) sqlutil.MacroFunc {
+// macroDateBin bins the column with date_bin. With a single argument the
+// bins are as wide as the query interval and aligned on the epoch. An
+// optional second argument sets the bin width with calendar-aware tokens
+// (30m, 1d, 1w, 1M, 1y, ...) whose bins are aligned on the calendar
+// boundaries of the dashboard timezone.
+func macroDateBin(suffix string, loc *time.Location) sqlutil.MacroFunc {
 	return func(query *sqlutil.Query, args []string) (string, error) {
-		if len(args) != 1 {
-			return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		if len(args) != 1 && len(args) != 2 {
+			return "", fmt.Errorf("%w: expected 1 or 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
 		}
 		column := args[0]
 		aliasing := func() string {
@@ -103,6 +141,140 @@ func macroDateBin(suffix string) sqlutil.MacroFunc {
 			}
 			return fmt.Sprintf(" as %s%s", column, suffix)
 		}()
-		return fmt.Sprintf("date_bin(interval '%d second', %s, timestamp '1970-01-01T00:00:00Z')%s", int64(query.Interval.Seconds()), column, aliasing), nil
+		if len(args) == 1 {
+			return fmt.Sprintf("date_bin(interval '%d second', %s, timestamp '1970-01-01T00:00:00Z')%s", int64(query.Interval.Seconds()), column, aliasing), nil
+		}
+
+		bin, err := calendarBin(column, strings.TrimSpace(args[1]), query.TimeRange.From.In(loc))
+		if err != nil {
+			return "", err
+		}
+		return bin + aliasing, nil
+	}
+}
+
+var calendarIntervalRegexp = regexp.MustCompile(`^(\d+)([smhdwMy])$`)
+
+// calendarUnits are the date_trunc precisions of the calendar interval
+// units.
+var calendarUnits = map[string]string{"d": "day", "w": "week", "M": "month", "y": "year"}
+
+// calendarBin returns the expression binning the column by the interval
+// token. Bins of seconds, minutes and hours have a fixed width and are
+// aligned on the midnight before from. Days, weeks (starting on Monday),
+// months and years vary in length, with the month or around daylight saving
+// time changes, so they truncate the column converted to the timezone of
+// from instead: date_bin only knows fixed widths.
+func calendarBin(column, token string, from time.Time) (string, error) {
+	m := calendarIntervalRegexp.FindStringSubmatch(token)
+	if m == nil {
+		return "", fmt.Errorf("invalid interval %q: expected a number followed by one of s, m, h, d, w, M, y", token)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid interval %q", token)
+	}
+
+	if unit, ok := calendarUnits[m[2]]; ok {
+		if n != 1 {
+			return "", fmt.Errorf("invalid interval %q: calendar intervals of more than one %s are not supported", token, unit)
+		}
+		if from.Location() != time.UTC {
+			column = fmt.Sprintf("%s AT TIME ZONE '%s'", column, from.Location())
+		}
+		return fmt.Sprintf("date_trunc('%s', %s)", unit, column), nil
+	}
+
+	var unit string
+	switch m[2] {
+	case "s":
+		unit = "second"
+	case "m":
+		unit = "minute"
+	case "h":
+		unit = "hour"
+	}
+	y, mon, d := from.Date()
+	origin := time.Date(y, mon, d, 0, 0, 0, 0, from.Location())
+	return fmt.Sprintf("date_bin(interval '%d %s', %s, timestamp '%s')", n, unit, column, origin.UTC().Format(time.RFC3339)), nil
+}
+
+var (
+	timeShiftRegexp       = regexp.MustCompile(`\$__timeShift\(([^)]*)\)`)
+	timeShiftOffsetRegexp = regexp.MustCompile(`^([+-]?)(\d+)([smhdwMy])$`)
+)
+
+// timeShift is the offset of a $__timeShift(offset) macro.
+type timeShift struct {
+	// n is the number of units the time range is shifted back by.
+	n    int
+	unit string
+}
+
+// add shifts t by n offsets: forward for a positive n, back otherwise. Days,
+// weeks, months and years are calendar-aware; months and years are clamped
+// to the end of the month, so 31 March minus one month is 28 February.
+func (s *timeShift) add(t time.Time, n int) time.Time {
+	n *= s.n
+	switch s.unit {
+	case "s":
+		return t.Add(time.Duration(n) * time.Second)
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "w":
+		return t.AddDate(0, 0, 7*n)
+	case "M":
+		return addMonths(t, n)
+	default:
+		return addMonths(t, 12*n)
+	}
+}
+
+// addMonths adds n months to t, clamping the day to the end of the month.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	if last := time.Date(y, m+time.Month(n)+1, 0, 0, 0, 0, 0, t.Location()).Day(); d > last {
+		d = last
+	}
+	return time.Date(y, m+time.Month(n), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// applyTimeShift removes the $__timeShift(offset) macro from the SQL of the
+// query and shifts its time range back by the offset, so the other time
+// macros of that query only cover the shifted range. A negative offset shifts
+// the range forward. It returns the offset, if any, which the time fields of
+// the results are shifted forward by; see [shiftTimeFields].
+func applyTimeShift(query *sqlutil.Query) (*timeShift, error) {
+	matches := timeShiftRegexp.FindAllStringSubmatch(query.RawSQL, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("$__timeShift: expected at most one per query, found %d", len(matches))
+	}
+
+	offset := strings.Trim(strings.TrimSpace(matches[0][1]), `'"`)
+	m := timeShiftOffsetRegexp.FindStringSubmatch(offset)
+	if m == nil {
+		return nil, fmt.Errorf("$__timeShift: invalid offset %q: expected a number followed by one of s, m, h, d, w, M, y", offset)
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil, fmt.Errorf("$__timeShift: invalid offset %q", offset)
+	}
+	if m[1] == "-" {
+		n = -n
+	}
+
+	shift := &timeShift{n: n, unit: m[3]}
+	query.TimeRange = backend.TimeRange{
+		From: shift.add(query.TimeRange.From, -1),
+		To:   shift.add(query.TimeRange.To, -1),
 	}
+	query.RawSQL = timeShiftRegexp.ReplaceAllString(query.RawSQL, "")
+	return shift, nil
 }
@@ -0,0 +1,107 @@
+package fsql
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// MacroDoc documents a macro supported in SQL queries.
+type MacroDoc struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+	// Example is a use of the macro and Expansion the SQL it expands to for
+	// the time range the documentation was requested for.
+	Example   string `json:"example"`
+	Expansion string `json:"expansion"`
+}
+
+// macroDocs describes the macros of [newMacros] and the SDK defaults they
+// fall back to, along with an example use of each.
+var macroDocs = []MacroDoc{
+	{
+		Name:        "dateBin",
+		Signature:   "$__dateBin(column[, interval])",
+		Description: "Bins the column with date_bin, by the query interval or by the given interval (30m, 6h, ...). Days, weeks, months and years (1d, 1w, 1M, 1y) truncate the column to their start in the dashboard timezone.",
+		Example:     "$__dateBin(time)",
+	},
+	{
+		Name:        "dateBinAlias",
+		Signature:   "$__dateBinAlias(column[, interval])",
+		Description: "Like $__dateBin, aliased as <column>_binned.",
+		Example:     "$__dateBinAlias(time, 1d)",
+	},
+	{
+		Name:        "interval",
+		Signature:   "$__interval",
+		Description: "The query interval as a SQL interval.",
+		Example:     "$__interval",
+	},
+	{
+		Name:        "timeFilter",
+		Signature:   "$__timeFilter(column)",
+		Description: "Restricts the column to the time range of the query.",
+		Example:     "$__timeFilter(time)",
+	},
+	{
+		Name:        "timeFrom",
+		Signature:   "$__timeFrom",
+		Description: "The start of the time range of the query as a timestamp.",
+		Example:     "$__timeFrom",
+	},
+	{
+		Name:        "timeTo",
+		Signature:   "$__timeTo",
+		Description: "The end of the time range of the query as a timestamp.",
+		Example:     "$__timeTo",
+	},
+	{
+		Name:        "timeGroup",
+		Signature:   "$__timeGroup(column, minute|hour|day|month|year|interval)",
+		Description: "Groups the column by the date parts down to the given precision, or bins it like $__dateBin when given an interval (5m, 1d, $__interval, ...).",
+		Example:     "$__timeGroup(time, hour)",
+	},
+	{
+		Name:        "timeGroupAlias",
+		Signature:   "$__timeGroupAlias(column, minute|hour|day|month|year|interval)",
+		Description: "Like $__timeGroup, with each date part aliased as <column>_<part>, or the bins as <column>_binned.",
+		Example:     "$__timeGroupAlias(time, hour)",
+	},
+	{
+		Name:        "timeShift",
+		Signature:   "$__timeShift(offset)",
+		Description: "Shifts the time range of the query back by the offset (1h, 1d, 1w, 1M, ...), and the times of its results forward, so they compare with the panel's range; negative offsets shift the other way. Expands to nothing.",
+		Example:     "$__timeFilter(time) $__timeShift(1d)",
+	},
+}
+
+// MacroDocs returns the documentation of the supported macros, sorted by
+// name, with their examples expanded for the given time range, interval and
+// dashboard timezone.
+func MacroDocs(timeRange backend.TimeRange, interval time.Duration, loc *time.Location) ([]MacroDoc, error) {
+	docs := make([]MacroDoc, 0, len(macroDocs))
+	for _, doc := range macroDocs {
+		query := &sqlutil.Query{
+			RawSQL:    doc.Example,
+			TimeRange: timeRange,
+			Interval:  interval,
+		}
+		if _, err := applyTimeShift(query); err != nil {
+			return nil, fmt.Errorf("macro %s: %w", doc.Name, err)
+		}
+		expansion, err := interpolateMacros(query, newMacros(loc))
+		if err != nil {
+			return nil, fmt.Errorf("macro %s: %w", doc.Name, err)
+		}
+		doc.Expansion = expansion
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Name < docs[j].Name
+	})
+	return docs, nil
+}
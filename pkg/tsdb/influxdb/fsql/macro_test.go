@@ -1,6 +1,7 @@
 package fsql
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -61,3 +62,254 @@ func TestMacros(t *testing.T) {
 		})
 	}
 }
+
+func TestMacroDateBin_Calendar(t *testing.T) {
+	// Wednesday, in the middle of the day in New York.
+	from, _ := time.Parse(time.RFC3339, "2023-03-15T17:30:00Z")
+	query := sqlutil.Query{
+		TimeRange: backend.TimeRange{
+			From: from,
+			To:   from.Add(24 * time.Hour),
+		},
+		Interval: 10 * time.Second,
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cs := []struct {
+		in  string
+		loc *time.Location
+		out string
+	}{
+		{
+			in:  `select $__dateBin(time, 1d)`,
+			loc: time.UTC,
+			out: `select date_trunc('day', time)`,
+		},
+		{
+			in:  `select $__dateBin(time, 1d)`,
+			loc: newYork,
+			out: `select date_trunc('day', time AT TIME ZONE 'America/New_York')`,
+		},
+		{
+			in:  `select $__dateBin(time, 1w)`,
+			loc: newYork,
+			out: `select date_trunc('week', time AT TIME ZONE 'America/New_York')`,
+		},
+		{
+			in:  `select $__dateBin(time, 1M)`,
+			loc: newYork,
+			out: `select date_trunc('month', time AT TIME ZONE 'America/New_York')`,
+		},
+		{
+			in:  `select $__dateBinAlias(time, 15m)`,
+			loc: time.UTC,
+			out: `select date_bin(interval '15 minute', time, timestamp '2023-03-15T00:00:00Z') as time_binned`,
+		},
+		{
+			in:  `select $__dateBin(time, 6h)`,
+			loc: newYork,
+			out: `select date_bin(interval '6 hour', time, timestamp '2023-03-15T04:00:00Z')`,
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.in+" "+c.loc.String(), func(t *testing.T) {
+			sql, err := sqlutil.Interpolate(query.WithSQL(c.in), newMacros(c.loc))
+			require.NoError(t, err)
+			require.Equal(t, c.out, sql)
+		})
+	}
+
+	t.Run("invalid interval", func(t *testing.T) {
+		_, err := sqlutil.Interpolate(query.WithSQL(`select $__dateBin(time, 1q)`), macros)
+		require.ErrorContains(t, err, `invalid interval "1q"`)
+
+		_, err = sqlutil.Interpolate(query.WithSQL(`select $__dateBin(time, 3M)`), macros)
+		require.ErrorContains(t, err, `invalid interval "3M": calendar intervals of more than one month are not supported`)
+	})
+
+	t.Run("daylight saving time", func(t *testing.T) {
+		// Days are truncated in the timezone rather than binned from the
+		// midnight before the change, which would shift the bins after it
+		// by an hour.
+		crossing := query
+		crossing.TimeRange = backend.TimeRange{
+			From: time.Date(2023, 3, 11, 0, 0, 0, 0, newYork),
+			To:   time.Date(2023, 3, 14, 0, 0, 0, 0, newYork),
+		}
+		sql, err := sqlutil.Interpolate(crossing.WithSQL(`select $__dateBin(time, 1d)`), newMacros(newYork))
+		require.NoError(t, err)
+		require.Equal(t, `select date_trunc('day', time AT TIME ZONE 'America/New_York')`, sql)
+	})
+}
+
+func TestMacroTimeGroup(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, "2023-03-15T17:30:00Z")
+	query := sqlutil.Query{
+		TimeRange: backend.TimeRange{
+			From: from,
+			To:   from.Add(time.Hour),
+		},
+		Interval: 10 * time.Second,
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cs := []struct {
+		in  string
+		loc *time.Location
+		out string
+	}{
+		{
+			in:  `select $__timeGroup(time, month)`,
+			loc: time.UTC,
+			out: `select datepart('month', time),datepart('year', time)`,
+		},
+		{
+			in:  `select $__timeGroupAlias(time, 'day')`,
+			loc: time.UTC,
+			out: `select datepart('day', time) as time_day,datepart('month', time) as time_month,datepart('year', time) as time_year`,
+		},
+		{
+			in:  `select $__timeGroup(time, $__interval)`,
+			loc: time.UTC,
+			out: `select date_bin(interval '10 second', time, timestamp '1970-01-01T00:00:00Z')`,
+		},
+		{
+			in:  `select $__timeGroup(time, '5m')`,
+			loc: time.UTC,
+			out: `select date_bin(interval '5 minute', time, timestamp '2023-03-15T00:00:00Z')`,
+		},
+		{
+			in:  `select $__timeGroupAlias(time, 1d)`,
+			loc: newYork,
+			out: `select date_trunc('day', time AT TIME ZONE 'America/New_York') as time_binned`,
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.in+" "+c.loc.String(), func(t *testing.T) {
+			sql, err := sqlutil.Interpolate(query.WithSQL(c.in), newMacros(c.loc))
+			require.NoError(t, err)
+			require.Equal(t, c.out, sql)
+		})
+	}
+
+	t.Run("invalid interval", func(t *testing.T) {
+		_, err := sqlutil.Interpolate(query.WithSQL(`select $__timeGroup(time, week)`), macros)
+		require.ErrorContains(t, err, `invalid interval "week"`)
+	})
+
+	t.Run("missing interval", func(t *testing.T) {
+		_, err := sqlutil.Interpolate(query.WithSQL(`select $__timeGroup(time)`), macros)
+		require.ErrorIs(t, err, sqlutil.ErrorBadArgumentCount)
+	})
+}
+
+func TestInterpolateMacros(t *testing.T) {
+	// Time ranges of dashboards in other timezones are still sent in UTC, and
+	// macros without arguments don't take the arguments of the next ones.
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	from := time.Date(2023, 3, 15, 13, 30, 0, 0, newYork)
+	query := sqlutil.Query{TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)}}
+
+	sql, err := interpolateMacros(query.WithSQL(`select * from x where time >= $__timeFrom and time < $__timeTo and $__timeFilter("time")`), newMacros(newYork))
+	require.NoError(t, err)
+	require.Equal(t, `select * from x where time >= cast('2023-03-15T17:30:00Z' as timestamp) and time < cast('2023-03-15T18:30:00Z' as timestamp) and "time" >= '2023-03-15T17:30:00Z' AND "time" <= '2023-03-15T18:30:00Z'`, sql)
+}
+
+func TestApplyTimeShift(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, "2023-03-31T00:00:00Z")
+	query := sqlutil.Query{
+		TimeRange: backend.TimeRange{
+			From: from,
+			To:   from.Add(time.Hour),
+		},
+	}
+
+	cs := []struct {
+		in   string
+		from string
+		to   string
+	}{
+		{
+			in:   `select * from x where $__timeFilter(time)`,
+			from: "2023-03-31T00:00:00Z",
+			to:   "2023-03-31T01:00:00Z",
+		},
+		{
+			in:   `select * from x where $__timeFilter(time) $__timeShift(1h)`,
+			from: "2023-03-30T23:00:00Z",
+			to:   "2023-03-31T00:00:00Z",
+		},
+		{
+			in:   `select * from x where $__timeFilter(time) $__timeShift('-30m')`,
+			from: "2023-03-31T00:30:00Z",
+			to:   "2023-03-31T01:30:00Z",
+		},
+		{
+			in:   `select * from x where $__timeFilter(time) $__timeShift(1M)`,
+			from: "2023-02-28T00:00:00Z",
+			to:   "2023-02-28T01:00:00Z",
+		},
+		{
+			in:   `select * from x where $__timeFilter(time) $__timeShift(1y)`,
+			from: "2022-03-31T00:00:00Z",
+			to:   "2022-03-31T01:00:00Z",
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.in, func(t *testing.T) {
+			q := query.WithSQL(c.in)
+			_, err := applyTimeShift(q)
+			require.NoError(t, err)
+			require.NotContains(t, q.RawSQL, "$__timeShift")
+
+			sql, err := sqlutil.Interpolate(q, macros)
+			require.NoError(t, err)
+			require.Equal(t, `select * from x where time >= '`+c.from+`' AND time <= '`+c.to+`'`, strings.TrimSpace(sql))
+		})
+	}
+
+	t.Run("invalid offset", func(t *testing.T) {
+		_, err := applyTimeShift(query.WithSQL(`select $__timeShift(1 hour)`))
+		require.ErrorContains(t, err, `invalid offset "1 hour"`)
+	})
+
+	t.Run("more than one", func(t *testing.T) {
+		_, err := applyTimeShift(query.WithSQL(`select $__timeShift(1h), $__timeShift(2h)`))
+		require.EqualError(t, err, "$__timeShift: expected at most one per query, found 2")
+	})
+}
+
+func TestAddMonths(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return ts
+	}
+	require.Equal(t, at("2023-02-28T10:00:00Z"), addMonths(at("2023-03-31T10:00:00Z"), -1))
+	require.Equal(t, at("2024-02-29T00:00:00Z"), addMonths(at("2024-01-31T00:00:00Z"), 1))
+	require.Equal(t, at("2025-02-28T00:00:00Z"), addMonths(at("2024-02-29T00:00:00Z"), 12))
+	require.Equal(t, at("2022-12-15T00:00:00Z"), addMonths(at("2023-01-15T00:00:00Z"), -1))
+}
+
+func TestMacroDocs(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, "2023-01-01T00:00:00Z")
+	timeRange := backend.TimeRange{From: from, To: from.Add(10 * time.Minute)}
+
+	docs, err := MacroDocs(timeRange, 10*time.Second, time.UTC)
+	require.NoError(t, err)
+
+	documented := map[string]MacroDoc{}
+	for _, doc := range docs {
+		documented[doc.Name] = doc
+		require.NotContains(t, doc.Expansion, "$__", "example of %s is not fully expanded", doc.Name)
+	}
+	for name := range newMacros(time.UTC) {
+		require.Contains(t, documented, name, "macro %s is not documented", name)
+	}
+
+	require.Equal(t, `date_bin(interval '10 second', time, timestamp '1970-01-01T00:00:00Z')`, documented["dateBin"].Expansion)
+	require.Equal(t, `time >= '2022-12-31T00:00:00Z' AND time <= '2022-12-31T00:10:00Z' `, documented["timeShift"].Expansion)
+}
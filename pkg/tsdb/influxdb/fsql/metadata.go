@@ -0,0 +1,137 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// ErrTableNotFound is returned by [Columns] for tables the server doesn't
+// list.
+var ErrTableNotFound = errors.New("table not found")
+
+// DBSchema is a schema of a database, as listed by GetDbSchemas.
+type DBSchema struct {
+	Catalog string `json:"catalog,omitempty"`
+	Name    string `json:"name"`
+}
+
+// Table is a table of a database, as listed by GetTables.
+type Table struct {
+	Catalog string `json:"catalog,omitempty"`
+	Schema  string `json:"schema,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+}
+
+// Column is a column of a table, from the schema of the table returned by
+// GetTables.
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// Schemas lists the schemas of the database, the default one of the
+// datasource when database is empty, so query editors can offer them for
+// autocompletion.
+func Schemas(ctx context.Context, dsInfo *models.DatasourceInfo, database string) ([]DBSchema, error) {
+	schemas := []DBSchema{}
+	err := withMetadata(ctx, dsInfo, database, func(ctx context.Context, r *runner) error {
+		info, err := r.client.GetDBSchemas(ctx, &flightsql.GetDBSchemasOpts{})
+		if err != nil {
+			return err
+		}
+		return r.readEndpoints(ctx, info, func(record arrow.Record) error {
+			names, ok := stringColumn(record, "db_schema_name")
+			if !ok {
+				return fmt.Errorf("get db schemas: missing db_schema_name column")
+			}
+			catalogs, _ := stringColumn(record, "catalog_name")
+			for i := 0; i < names.Len(); i++ {
+				schemas = append(schemas, DBSchema{Catalog: stringValue(catalogs, i), Name: names.Value(i)})
+			}
+			return nil
+		})
+	})
+	return schemas, err
+}
+
+// Tables lists the tables of the database, restricted to the schema named
+// exactly schema unless it is empty.
+func Tables(ctx context.Context, dsInfo *models.DatasourceInfo, database, schema string) ([]Table, error) {
+	opts := &flightsql.GetTablesOpts{}
+	if schema != "" {
+		// The filter is a LIKE pattern, in which the _ and % of schema
+		// names are wildcards, so it only narrows down the tables listed.
+		opts.DbSchemaFilterPattern = &schema
+	}
+
+	tables := []Table{}
+	err := withMetadata(ctx, dsInfo, database, func(ctx context.Context, r *runner) error {
+		infos, err := r.getTables(ctx, opts)
+		for _, t := range infos {
+			if schema != "" && t.dbSchema != schema {
+				continue
+			}
+			tables = append(tables, Table{Catalog: t.catalog, Schema: t.dbSchema, Name: t.name, Type: t.tableType})
+		}
+		return err
+	})
+	return tables, err
+}
+
+// Columns lists the columns of a table of the database, in the given schema
+// unless it is empty.
+func Columns(ctx context.Context, dsInfo *models.DatasourceInfo, database, schema, table string) ([]Column, error) {
+	columns := []Column{}
+	err := withMetadata(ctx, dsInfo, database, func(ctx context.Context, r *runner) error {
+		tables, err := r.findTables(ctx, schema, table)
+		if err != nil {
+			return err
+		}
+		if len(tables) == 0 {
+			return fmt.Errorf("%w: %q", ErrTableNotFound, table)
+		}
+		if tables[0].schema == nil {
+			return fmt.Errorf("server did not return the schema of table %q", table)
+		}
+		for _, f := range tables[0].schema.Fields() {
+			columns = append(columns, Column{Name: f.Name, Type: f.Type.String(), Nullable: f.Nullable})
+		}
+		return nil
+	})
+	return columns, err
+}
+
+// withMetadata calls fn with a runner of the datasource and a context
+// selecting the database.
+func withMetadata(ctx context.Context, dsInfo *models.DatasourceInfo, database string, fn func(context.Context, *runner) error) error {
+	r, err := runnerFromDataSource(dsInfo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			glog.Warn("Failed to close fsql client", "err", err)
+		}
+	}()
+	ctx, cancel := r.bind(ctx)
+	defer cancel()
+
+	if ctx, err = r.queryContext(ctx, dsInfo, &queryModel{Database: database}); err != nil {
+		return err
+	}
+	if err := fn(ctx, r); err != nil {
+		if errors.Is(err, ErrTableNotFound) {
+			return err
+		}
+		return errors.New(errorMessage(err))
+	}
+	return nil
+}
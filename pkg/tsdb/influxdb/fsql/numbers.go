@@ -0,0 +1,86 @@
+package fsql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// numberFormat describes how numbers are written in string columns, for
+// federated FlightSQL servers that stringify numerics.
+type numberFormat struct {
+	// Columns restricts parsing to the named columns. When empty every string
+	// column whose values all parse is converted.
+	Columns []string `json:"columns"`
+	// DecimalSeparator defaults to ".".
+	DecimalSeparator string `json:"decimalSeparator"`
+	// ThousandsSeparator is removed before parsing. A space also matches
+	// non-breaking spaces.
+	ThousandsSeparator string `json:"thousandsSeparator"`
+}
+
+// parse parses a number written in the format. Empty strings are reported
+// as missing values.
+func (nf *numberFormat) parse(s string) (v float64, ok bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false, nil
+	}
+
+	if sep := nf.ThousandsSeparator; sep != "" {
+		s = strings.ReplaceAll(s, sep, "")
+		if sep == " " {
+			s = strings.NewReplacer("\u00a0", "", "\u202f", "").Replace(s)
+		}
+	}
+	if sep := nf.DecimalSeparator; sep != "" && sep != "." {
+		s = strings.ReplaceAll(s, sep, ".")
+	}
+
+	v, err = strconv.ParseFloat(s, 64)
+	return v, err == nil, err
+}
+
+// parseNumericStrings replaces the string fields of the frame holding
+// numbers written in nf with nullable float64 fields. Fields with values
+// that don't parse are left untouched.
+func parseNumericStrings(frame *data.Frame, nf *numberFormat) {
+	if nf == nil {
+		return
+	}
+	columns := toSet(nf.Columns)
+
+	for i, f := range frame.Fields {
+		if f.Type().NonNullableType() != data.FieldTypeString {
+			continue
+		}
+		if len(columns) > 0 && !columns[f.Name] {
+			continue
+		}
+
+		values := make([]*float64, f.Len())
+		parsed := true
+		for j := 0; j < f.Len() && parsed; j++ {
+			s, ok := f.ConcreteAt(j)
+			if !ok {
+				continue
+			}
+			v, ok, err := nf.parse(s.(string))
+			if err != nil {
+				parsed = false
+				break
+			}
+			if ok {
+				values[j] = &v
+			}
+		}
+		if !parsed {
+			continue
+		}
+
+		field := data.NewField(f.Name, f.Labels, values)
+		field.Config = f.Config
+		frame.Fields[i] = field
+	}
+}
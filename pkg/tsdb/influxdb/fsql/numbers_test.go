@@ -0,0 +1,40 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNumericStrings(t *testing.T) {
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("price", nil, []string{"1.234,5", "-2,25", ""}),
+			data.NewField("qty", nil, []*string{ptr("1 000"), nil, ptr("3\u00a0000")}),
+			data.NewField("host", nil, []string{"a", "b", "c"}),
+		)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		frame := newFrame()
+		parseNumericStrings(frame, nil)
+		require.Equal(t, data.FieldTypeString, frame.Fields[0].Type())
+	})
+
+	t.Run("decimal comma", func(t *testing.T) {
+		frame := newFrame()
+		parseNumericStrings(frame, &numberFormat{DecimalSeparator: ",", ThousandsSeparator: "."})
+		require.Equal(t, []*float64{ptr(1234.5), ptr(-2.25), nil}, fieldValues[*float64](frame.Fields[0]))
+		// "1 000" doesn't parse with a "." thousands separator.
+		require.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+		require.Equal(t, data.FieldTypeString, frame.Fields[2].Type())
+	})
+
+	t.Run("space thousands separator on selected columns", func(t *testing.T) {
+		frame := newFrame()
+		parseNumericStrings(frame, &numberFormat{Columns: []string{"qty"}, ThousandsSeparator: " "})
+		require.Equal(t, data.FieldTypeString, frame.Fields[0].Type())
+		require.Equal(t, []*float64{ptr(1000.0), nil, ptr(3000.0)}, fieldValues[*float64](frame.Fields[1]))
+	})
+}
@@ -0,0 +1,177 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+)
+
+// queryParam is a parameter of a query, as sent in its JSON. Queries with
+// parameters run as prepared statements: the values are bound to the
+// placeholders of the SQL by the server instead of being interpolated into
+// it, so they need no escaping and can't inject SQL.
+type queryParam struct {
+	Name string `json:"name"`
+	// Type is one of string, int64, float64, boolean or timestamp.
+	Type string `json:"type"`
+	// Value is the value of the parameter, either as a JSON value of its
+	// type or as a string, the way dashboard variables are sent. Timestamps
+	// are RFC 3339 strings or milliseconds since the epoch. A missing value
+	// is bound as null.
+	Value json.RawMessage `json:"value"`
+}
+
+// boundParam is a parameter of a query with its value converted to its type.
+type boundParam struct {
+	Name string
+	Type arrow.DataType
+	// Value is nil for null values.
+	Value any
+}
+
+// paramTypes are the Arrow types of the supported parameter types.
+var paramTypes = map[string]arrow.DataType{
+	"string":    arrow.BinaryTypes.String,
+	"int64":     arrow.PrimitiveTypes.Int64,
+	"float64":   arrow.PrimitiveTypes.Float64,
+	"boolean":   arrow.FixedWidthTypes.Boolean,
+	"timestamp": &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"},
+}
+
+// bindParams converts the values of the parameters of a query to their
+// types.
+func bindParams(params []queryParam) ([]boundParam, error) {
+	bound := make([]boundParam, 0, len(params))
+	for i, p := range params {
+		if p.Name == "" {
+			return nil, fmt.Errorf("param %d: missing name", i)
+		}
+		typ, ok := paramTypes[p.Type]
+		if !ok {
+			return nil, fmt.Errorf("param %q: unsupported type %q", p.Name, p.Type)
+		}
+		value, err := paramValue(p.Type, p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: invalid %s value: %w", p.Name, p.Type, err)
+		}
+		bound = append(bound, boundParam{Name: p.Name, Type: typ, Value: value})
+	}
+	return bound, nil
+}
+
+// paramValue converts a JSON value to a parameter type.
+func paramValue(typ string, raw json.RawMessage) (any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var s string
+	quoted := json.Unmarshal(raw, &s) == nil
+	if !quoted {
+		s = string(raw)
+	}
+	switch typ {
+	case "string":
+		if !quoted {
+			return nil, fmt.Errorf("%s is not a string", raw)
+		}
+		return s, nil
+	case "int64":
+		return strconv.ParseInt(s, 10, 64)
+	case "float64":
+		return strconv.ParseFloat(s, 64)
+	case "boolean":
+		return strconv.ParseBool(s)
+	case "timestamp":
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.UnixMilli(ms).UTC(), nil
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// paramsRecord returns the single-row record binding the parameters, with a
+// field of each parameter's name and type.
+func paramsRecord(params []boundParam) arrow.Record {
+	fields := make([]arrow.Field, len(params))
+	for i, p := range params {
+		fields[i] = arrow.Field{Name: p.Name, Type: p.Type, Nullable: true}
+	}
+	b := array.NewRecordBuilder(memory.DefaultAllocator, arrow.NewSchema(fields, nil))
+	defer b.Release()
+
+	for i, p := range params {
+		if p.Value == nil {
+			b.Field(i).AppendNull()
+			continue
+		}
+		switch fb := b.Field(i).(type) {
+		case *array.StringBuilder:
+			fb.Append(p.Value.(string))
+		case *array.Int64Builder:
+			fb.Append(p.Value.(int64))
+		case *array.Float64Builder:
+			fb.Append(p.Value.(float64))
+		case *array.BooleanBuilder:
+			fb.Append(p.Value.(bool))
+		case *array.TimestampBuilder:
+			fb.Append(arrow.Timestamp(p.Value.(time.Time).UnixNano()))
+		}
+	}
+	return b.NewRecord()
+}
+
+// paramsKey identifies the values of the parameters of a query in the keys
+// of its cached results.
+func paramsKey(params []boundParam) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, p := range params {
+		fmt.Fprintf(&sb, ":%s=%s(%v)", p.Name, p.Type, p.Value)
+	}
+	return sb.String()
+}
+
+// prepare creates a prepared statement for the SQL, bound to the
+// parameters. It must be closed with closeStatement once its results have
+// been read.
+func (r *runner) prepare(ctx context.Context, sql string, params []boundParam) (*flightsql.PreparedStatement, error) {
+	stmt, err := r.client.Prepare(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement: %w", err)
+	}
+	record := paramsRecord(params)
+	defer record.Release()
+	stmt.SetParameters(record)
+	return stmt, nil
+}
+
+// closeStatement closes a prepared statement on the server.
+func closeStatement(ctx context.Context, stmt *flightsql.PreparedStatement) {
+	// Statements are closed even when the query was canceled meanwhile.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := stmt.Close(ctx); err != nil {
+		glog.Warn("Failed to close prepared statement", "err", err)
+	}
+}
+
+// executePrepared executes the prepared statement, retrying like
+// [(*runner).executeWithRetry].
+func executePrepared(ctx context.Context, stmt *flightsql.PreparedStatement, maxRetries int) (*flight.FlightInfo, int, error) {
+	return retryExecute(ctx, maxRetries, func(ctx context.Context) (*flight.FlightInfo, error) {
+		return stmt.Execute(ctx)
+	})
+}
@@ -0,0 +1,92 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestBindParams(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		param queryParam
+		want  any
+		err   string
+	}{
+		{name: "string", param: queryParam{Name: "p", Type: "string", Value: json.RawMessage(`"it's"`)}, want: "it's"},
+		{name: "int64", param: queryParam{Name: "p", Type: "int64", Value: json.RawMessage(`42`)}, want: int64(42)},
+		{name: "int64 from string", param: queryParam{Name: "p", Type: "int64", Value: json.RawMessage(`"42"`)}, want: int64(42)},
+		{name: "float64", param: queryParam{Name: "p", Type: "float64", Value: json.RawMessage(`1.5`)}, want: 1.5},
+		{name: "boolean", param: queryParam{Name: "p", Type: "boolean", Value: json.RawMessage(`true`)}, want: true},
+		{name: "timestamp", param: queryParam{Name: "p", Type: "timestamp", Value: json.RawMessage(`"2023-01-01T00:00:00Z"`)}, want: ts},
+		{name: "timestamp in ms", param: queryParam{Name: "p", Type: "timestamp", Value: json.RawMessage(`1672531200000`)}, want: ts},
+		{name: "null", param: queryParam{Name: "p", Type: "int64", Value: json.RawMessage(`null`)}, want: nil},
+		{name: "missing value", param: queryParam{Name: "p", Type: "string"}, want: nil},
+		{name: "missing name", param: queryParam{Type: "string"}, err: "param 0: missing name"},
+		{name: "unsupported type", param: queryParam{Name: "p", Type: "uuid"}, err: `param "p": unsupported type "uuid"`},
+		{name: "invalid value", param: queryParam{Name: "p", Type: "int64", Value: json.RawMessage(`"one"`)}, err: `param "p": invalid int64 value`},
+		{name: "unquoted string", param: queryParam{Name: "p", Type: "string", Value: json.RawMessage(`1`)}, err: `param "p": invalid string value`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound, err := bindParams([]queryParam{tt.param})
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, bound, 1)
+			assert.Equal(t, tt.want, bound[0].Value)
+		})
+	}
+}
+
+func TestParamsRecord(t *testing.T) {
+	bound, err := bindParams([]queryParam{
+		{Name: "host", Type: "string", Value: json.RawMessage(`"a"`)},
+		{Name: "min", Type: "float64", Value: json.RawMessage(`0.5`)},
+		{Name: "since", Type: "timestamp", Value: json.RawMessage(`1000`)},
+		{Name: "limit", Type: "int64"},
+	})
+	require.NoError(t, err)
+
+	record := paramsRecord(bound)
+	defer record.Release()
+	require.Equal(t, int64(1), record.NumRows())
+	assert.Equal(t, "host", record.Schema().Field(0).Name)
+	assert.Equal(t, "a", record.Column(0).(*array.String).Value(0))
+	assert.Equal(t, 0.5, record.Column(1).(*array.Float64).Value(0))
+	assert.Equal(t, arrow.Timestamp(time.Second), record.Column(2).(*array.Timestamp).Value(0))
+	assert.True(t, record.Column(3).IsNull(0))
+}
+
+func TestGetQueryModel_Params(t *testing.T) {
+	query := func(req queryRequest) (*queryModel, error) {
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		return getQueryModel(backend.DataQuery{RefID: "A", JSON: b}, &models.DatasourceInfo{})
+	}
+	params := []queryParam{{Name: "host", Type: "string", Value: json.RawMessage(`"a"`)}}
+
+	qm, err := query(queryRequest{RawQuery: "select * from cpu where host = ?", Params: params})
+	require.NoError(t, err)
+	assert.Equal(t, []boundParam{{Name: "host", Type: arrow.BinaryTypes.String, Value: "a"}}, qm.Params)
+
+	// Queries whose params differ don't share their cached results.
+	other, err := query(queryRequest{RawQuery: "select * from cpu where host = ?", Params: []queryParam{{Name: "host", Type: "string", Value: json.RawMessage(`"b"`)}}})
+	require.NoError(t, err)
+	assert.NotEqual(t, resultCacheKey(context.Background(), qm), resultCacheKey(context.Background(), other))
+
+	_, err = query(queryRequest{RawQuery: "select * from cpu where host = ?", Params: params, Push: true})
+	require.ErrorContains(t, err, "params: not supported")
+}
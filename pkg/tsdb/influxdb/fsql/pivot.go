@@ -0,0 +1,85 @@
+package fsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// pivotOptions pivots narrow results, with one row per metric and time, into
+// one series per distinct metric name.
+type pivotOptions struct {
+	// NameColumn holds the name of the metric of each row.
+	NameColumn string `json:"nameColumn"`
+	// ValueColumn holds the value of the metric of each row.
+	ValueColumn string `json:"valueColumn"`
+}
+
+// pivotFrame turns a long time series frame whose time field is first into a
+// wide frame with one field per distinct value of the name column, named
+// after it. Other string and boolean columns become labels of the series and
+// the remaining numeric columns are dropped.
+func pivotFrame(frame *data.Frame, p *pivotOptions) (*data.Frame, error) {
+	nameIdx := fieldIndex(frame, p.NameColumn)
+	if nameIdx == -1 {
+		return nil, fmt.Errorf("pivot: name column %q not found", p.NameColumn)
+	}
+	valueIdx := fieldIndex(frame, p.ValueColumn)
+	if valueIdx == -1 {
+		return nil, fmt.Errorf("pivot: value column %q not found", p.ValueColumn)
+	}
+	if !frame.Fields[valueIdx].Type().Numeric() {
+		return nil, fmt.Errorf("pivot: value column %q is not numeric", p.ValueColumn)
+	}
+
+	nameField := frame.Fields[nameIdx]
+	names := make([]string, nameField.Len())
+	for i := range names {
+		if v, ok := nameField.ConcreteAt(i); ok {
+			names[i] = fmt.Sprint(v)
+		}
+	}
+	nameKey := nameField.Name
+
+	long := data.NewFrame(frame.Name, frame.Fields[0])
+	long.Meta = frame.Meta
+	for i, f := range frame.Fields[1:] {
+		if i+1 == nameIdx || i+1 == valueIdx {
+			continue
+		}
+		switch f.Type().NonNullableType() {
+		case data.FieldTypeString, data.FieldTypeBool:
+			long.Fields = append(long.Fields, f)
+		}
+	}
+	long.Fields = append(long.Fields, data.NewField(nameKey, nil, names), frame.Fields[valueIdx])
+
+	wide, err := data.LongToWide(long, nil)
+	if err != nil {
+		return nil, fmt.Errorf("pivot: %w", err)
+	}
+	for _, f := range wide.Fields[1:] {
+		name, ok := f.Labels[nameKey]
+		if !ok {
+			continue
+		}
+		f.Name = name
+		delete(f.Labels, nameKey)
+		if len(f.Labels) == 0 {
+			f.Labels = nil
+		}
+	}
+	return wide, nil
+}
+
+// fieldIndex returns the index of the field with the given name, ignoring
+// case, or -1.
+func fieldIndex(frame *data.Frame, name string) int {
+	for i, f := range frame.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,68 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNewQueryDataResponse_Pivot(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+			{Name: "host", Type: arrow.BinaryTypes.String},
+			{Name: "metric", Type: arrow.BinaryTypes.String, Nullable: true},
+			{Name: "value", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+			{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	newReader := func() recordReader {
+		return errReader{RecordReader: newTestRecordReader(t, schema,
+			`["2023-01-01T00:00:00Z", "2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z", "2023-01-01T00:00:01Z"]`,
+			`["a", "a", "a", "a"]`,
+			`["cpu", "mem", "cpu", "mem"]`,
+			`[1.5, 10, 2.5, null]`,
+			`[1, 1, 1, 1]`,
+		)}
+	}
+
+	t.Run("one series per metric name", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		qm := &queryModel{Query: &query, Pivot: &pivotOptions{NameColumn: "Metric", ValueColumn: "value"}}
+		resp := newQueryDataResponse(newReader(), qm, metadata.MD{})
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+
+		frame := resp.Frames[0]
+		require.Len(t, frame.Fields, 3)
+		assert.Equal(t, "time", frame.Fields[0].Name)
+		assert.Equal(t, 2, frame.Fields[0].Len())
+
+		cpu, mem := frame.Fields[1], frame.Fields[2]
+		assert.Equal(t, "cpu", cpu.Name)
+		assert.Equal(t, data.Labels{"host": "a"}, cpu.Labels)
+		assert.Equal(t, []*float64{ptr(1.5), ptr(2.5)}, fieldValues[*float64](cpu))
+		assert.Equal(t, "mem", mem.Name)
+		assert.Equal(t, []*float64{ptr(10.0), nil}, fieldValues[*float64](mem))
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		qm := &queryModel{Query: &query, Pivot: &pivotOptions{NameColumn: "name", ValueColumn: "value"}}
+		resp := newQueryDataResponse(newReader(), qm, metadata.MD{})
+		assert.EqualError(t, resp.Error, `pivot: name column "name" not found`)
+	})
+
+	t.Run("value column not numeric", func(t *testing.T) {
+		query := sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries}
+		qm := &queryModel{Query: &query, Pivot: &pivotOptions{NameColumn: "metric", ValueColumn: "host"}}
+		resp := newQueryDataResponse(newReader(), qm, metadata.MD{})
+		assert.EqualError(t, resp.Error, `pivot: value column "host" is not numeric`)
+	})
+}
@@ -0,0 +1,70 @@
+package fsql
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// sizeEstimate is the result size announced by the server in the FlightInfo
+// of a query. Unknown totals are -1.
+type sizeEstimate struct {
+	TotalRecords int64 `json:"totalRecords"`
+	TotalBytes   int64 `json:"totalBytes"`
+}
+
+// estimateSize returns the size announced in info, or nil when the server
+// didn't provide any total.
+func estimateSize(info *flight.FlightInfo) *sizeEstimate {
+	if info.TotalRecords < 0 && info.TotalBytes < 0 {
+		return nil
+	}
+	return &sizeEstimate{
+		TotalRecords: info.TotalRecords,
+		TotalBytes:   info.TotalBytes,
+	}
+}
+
+// exceededLimit describes the datasource limit exceeded by the estimate, if
+// any.
+func (e *sizeEstimate) exceededLimit(dsInfo *models.DatasourceInfo) (string, bool) {
+	if dsInfo.MaxResultRows > 0 && e.TotalRecords > dsInfo.MaxResultRows {
+		return fmt.Sprintf("the query is estimated to return %d rows, more than the limit of %d", e.TotalRecords, dsInfo.MaxResultRows), true
+	}
+	if dsInfo.MaxResultBytes > 0 && e.TotalBytes > dsInfo.MaxResultBytes {
+		return fmt.Sprintf("the query is estimated to return %d bytes, more than the limit of %d", e.TotalBytes, dsInfo.MaxResultBytes), true
+	}
+	return "", false
+}
+
+// preflight checks the size estimate of a query against the limits of the
+// datasource before its results are streamed. When the query must be
+// refused it returns the response to send, with the estimate in the frame
+// meta so users understand why. Otherwise it returns the notices to attach
+// to the frames of the query.
+func preflight(est *sizeEstimate, dsInfo *models.DatasourceInfo) (*backend.DataResponse, []data.Notice) {
+	if est == nil {
+		return nil, nil
+	}
+	reason, exceeded := est.exceededLimit(dsInfo)
+	if !exceeded {
+		return nil, nil
+	}
+
+	if dsInfo.AbortOversizedQueries {
+		frame := data.NewFrame("")
+		setCustomMeta(frame, "estimate", est)
+		resp := backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query refused: %s", reason))
+		resp.Frames = data.Frames{frame}
+		return &resp, nil
+	}
+
+	return nil, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Large result: %s", reason),
+	}}
+}
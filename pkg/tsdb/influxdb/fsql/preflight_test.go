@@ -0,0 +1,49 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestPreflight(t *testing.T) {
+	t.Run("unknown totals", func(t *testing.T) {
+		est := estimateSize(&flight.FlightInfo{TotalRecords: -1, TotalBytes: -1})
+		require.Nil(t, est)
+		refused, notices := preflight(est, &models.DatasourceInfo{MaxResultRows: 1})
+		require.Nil(t, refused)
+		require.Empty(t, notices)
+	})
+
+	t.Run("within limits", func(t *testing.T) {
+		est := estimateSize(&flight.FlightInfo{TotalRecords: 10, TotalBytes: -1})
+		refused, notices := preflight(est, &models.DatasourceInfo{MaxResultRows: 10, MaxResultBytes: 100})
+		require.Nil(t, refused)
+		require.Empty(t, notices)
+	})
+
+	t.Run("warns when a limit is exceeded", func(t *testing.T) {
+		est := estimateSize(&flight.FlightInfo{TotalRecords: 10, TotalBytes: 2048})
+		refused, notices := preflight(est, &models.DatasourceInfo{MaxResultBytes: 1024})
+		require.Nil(t, refused)
+		require.Equal(t, []data.Notice{{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "Large result: the query is estimated to return 2048 bytes, more than the limit of 1024",
+		}}, notices)
+	})
+
+	t.Run("refuses when configured to abort", func(t *testing.T) {
+		est := estimateSize(&flight.FlightInfo{TotalRecords: 11, TotalBytes: -1})
+		refused, notices := preflight(est, &models.DatasourceInfo{MaxResultRows: 10, AbortOversizedQueries: true})
+		require.Empty(t, notices)
+		require.NotNil(t, refused)
+		require.Equal(t, backend.StatusBadRequest, refused.Status)
+		require.EqualError(t, refused.Error, "query refused: the query is estimated to return 11 rows, more than the limit of 10")
+		require.Equal(t, map[string]any{"estimate": est}, refused.Frames[0].Meta.Custom)
+	})
+}
@@ -0,0 +1,88 @@
+package fsql
+
+import (
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+)
+
+// projectColumns wraps the reader so that only the selected columns are
+// converted. When selectColumns is empty every column is kept except those
+// in excludeColumns. This drops heavy columns (blobs, long JSON) before they
+// are copied into frames, even when the SQL is `SELECT *`.
+func projectColumns(reader recordReader, selectColumns, excludeColumns []string) recordReader {
+	if len(selectColumns) == 0 && len(excludeColumns) == 0 {
+		return reader
+	}
+
+	selected := toSet(selectColumns)
+	excluded := toSet(excludeColumns)
+
+	schema := reader.Schema()
+	var (
+		fields  []arrow.Field
+		indices []int
+	)
+	for i, f := range schema.Fields() {
+		if len(selected) > 0 && !selected[f.Name] {
+			continue
+		}
+		if excluded[f.Name] {
+			continue
+		}
+		fields = append(fields, f)
+		indices = append(indices, i)
+	}
+	if len(indices) == len(schema.Fields()) {
+		return reader
+	}
+
+	md := schema.Metadata()
+	return &projectedReader{
+		recordReader: reader,
+		schema:       arrow.NewSchema(fields, &md),
+		indices:      indices,
+	}
+}
+
+// projectedReader is a [recordReader] exposing a subset of the columns of
+// the underlying reader.
+type projectedReader struct {
+	recordReader
+	schema  *arrow.Schema
+	indices []int
+	current arrow.Record
+}
+
+func (r *projectedReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+func (r *projectedReader) Next() bool {
+	if r.current != nil {
+		r.current.Release()
+		r.current = nil
+	}
+	if !r.recordReader.Next() {
+		return false
+	}
+
+	record := r.recordReader.Record()
+	cols := make([]arrow.Array, len(r.indices))
+	for i, idx := range r.indices {
+		cols[i] = record.Column(idx)
+	}
+	r.current = array.NewRecord(r.schema, cols, record.NumRows())
+	return true
+}
+
+func (r *projectedReader) Record() arrow.Record {
+	return r.current
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,335 @@
+package fsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// pushPathPrefix is the prefix of the paths of the Live channels on which
+// the results of push queries are published.
+const pushPathPrefix = "push/"
+
+// maxPushQueries bounds the number of push queries registered per
+// connection.
+const maxPushQueries = 100
+
+// statementQueryTypeURL is the type of the FlightSQL command running a SQL
+// statement.
+const statementQueryTypeURL = "type.googleapis.com/arrow.flight.protocol.sql.CommandStatementQuery"
+
+// errPushUnsupported is returned when the server doesn't implement
+// DoExchange.
+var errPushUnsupported = errors.New("the server doesn't support pushing query results (DoExchange)")
+
+// IsPushPath reports whether the Live channel path is the one of a push
+// query.
+func IsPushPath(path string) bool {
+	return strings.HasPrefix(path, pushPathPrefix)
+}
+
+// pushQuery is a query whose results are pushed by the server over a
+// DoExchange stream and published on a Live channel.
+type pushQuery struct {
+	qm *queryModel
+	// md is the call metadata of the query, selecting its database.
+	md metadata.MD
+	// last is the last frame published, sent to new subscribers.
+	last *data.FrameJSONCache
+}
+
+// pushRegistry holds the push queries, or the streamed queries, of a
+// connection by channel path.
+type pushRegistry struct {
+	mu      sync.Mutex
+	queries map[string]*pushQuery
+	// unsupported is set once the server has refused a DoExchange call.
+	unsupported bool
+}
+
+// register registers the query and returns the path of its channel, under
+// prefix. The same query over the same database is given the same channel,
+// so panels showing it share the stream; the channel then runs the query
+// last registered, over its latest time range.
+func (r *pushRegistry) register(prefix string, qm *queryModel, md metadata.MD) string {
+	path := pushPath(prefix, qm, md)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if q, ok := r.queries[path]; ok {
+		q.qm = qm
+		return path
+	}
+	if len(r.queries) >= maxPushQueries {
+		// Start over rather than tracking which channels are subscribed;
+		// running streams keep their query.
+		r.queries = nil
+	}
+	if r.queries == nil {
+		r.queries = make(map[string]*pushQuery)
+	}
+	r.queries[path] = &pushQuery{qm: qm, md: md}
+	return path
+}
+
+func (r *pushRegistry) get(path string) (*pushQuery, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q, ok := r.queries[path]
+	return q, ok
+}
+
+// supported reports whether the server may support DoExchange, which is
+// assumed until it refuses a call.
+func (r *pushRegistry) supported() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.unsupported
+}
+
+func (r *pushRegistry) setUnsupported() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unsupported = true
+}
+
+// query returns the query model last registered for the channel of q.
+func (r *pushRegistry) query(q *pushQuery) *queryModel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return q.qm
+}
+
+func (r *pushRegistry) lastFrame(q *pushQuery) *data.FrameJSONCache {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return q.last
+}
+
+func (r *pushRegistry) setLastFrame(q *pushQuery, frame *data.FrameJSONCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q.last = frame
+}
+
+// pushPath returns the channel path, under prefix, of the query over the
+// database selected by md. Channels are keyed on the query before the
+// interpolation of its macros, so the registry isn't filled by the
+// refreshes of panels with relative time ranges.
+func pushPath(prefix string, qm *queryModel, md metadata.MD) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", qm.Format, qm.channelKey)
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "\n%s=%s", k, strings.Join(md.Get(k), ","))
+	}
+	return prefix + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// channelResponse registers the query in reg, under prefix, for its results
+// to be published on a Live channel and returns an empty frame pointing to
+// the channel.
+func (r *runner) channelResponse(ctx context.Context, pCtx backend.PluginContext, qm *queryModel, reg *pushRegistry, prefix string) backend.DataResponse {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "live queries need a datasource")
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	path := reg.register(prefix, qm, md.Copy())
+
+	frame := data.NewFrame("")
+	frame.RefID = qm.RefID
+	frame.Meta = &data.FrameMeta{
+		Channel: live.Channel{
+			Scope:     live.ScopeDatasource,
+			Namespace: pCtx.DataSourceInstanceSettings.UID,
+			Path:      path,
+		}.String(),
+		ExecutedQueryString: qm.RawSQL,
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// SubscribePush subscribes to the channel of a push query, sending the last
+// frame published on it to the new subscriber.
+func SubscribePush(dsInfo *models.DatasourceInfo, path string) (*backend.SubscribeStreamResponse, error) {
+	conn, ok := dsInfo.FlightSQL.(*Connection)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return conn.pushes.subscribe(path)
+}
+
+// subscribe subscribes to the channel of a query of the registry, sending
+// the last frame published on it to the new subscriber.
+func (r *pushRegistry) subscribe(path string) (*backend.SubscribeStreamResponse, error) {
+	q, ok := r.get(path)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	resp := &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}
+	if last := r.lastFrame(q); last != nil {
+		initial, err := backend.NewInitialData(last.Bytes(data.IncludeAll))
+		if err != nil {
+			return nil, err
+		}
+		resp.InitialData = initial
+	}
+	return resp, nil
+}
+
+// RunPush runs the push query of the channel: it opens a DoExchange stream
+// sending the query to the server, and publishes the records the server
+// pushes over it until ctx is canceled, the server ends the stream or the
+// connection is closed.
+func RunPush(ctx context.Context, dsInfo *models.DatasourceInfo, path string, sender *backend.StreamSender) error {
+	conn, ok := dsInfo.FlightSQL.(*Connection)
+	if !ok {
+		return fmt.Errorf("push queries need the FlightSQL connection of the datasource")
+	}
+	q, ok := conn.pushes.get(path)
+	if !ok {
+		return fmt.Errorf("unknown push channel %q", path)
+	}
+
+	r, err := runnerFromDataSource(dsInfo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			glog.Warn("Failed to close fsql client", "err", err)
+		}
+	}()
+
+	ctx, cancel := conn.bindStream(ctx)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, q.md)
+
+	err = r.exchange(ctx, conn.pushes.query(q).RawSQL, func(record arrow.Record) error {
+		return conn.pushes.publish(q, sender, record)
+	})
+	if status.Code(err) == codes.Unimplemented {
+		conn.pushes.setUnsupported()
+		return errPushUnsupported
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// publish converts the records into a frame and sends it to the subscribers
+// of the channel of the query, unless the records have no rows.
+func (r *pushRegistry) publish(q *pushQuery, sender *backend.StreamSender, records ...arrow.Record) error {
+	frame, err := resultFrame(r.query(q), records...)
+	if err != nil || frame == nil {
+		return err
+	}
+	next, err := data.FrameToJSONCache(frame)
+	if err != nil {
+		return err
+	}
+	// Subscribers already know the schema of the frames published after the
+	// first one.
+	include := data.IncludeAll
+	if prev := r.lastFrame(q); prev != nil && next.SameSchema(prev) {
+		include = data.IncludeDataOnly
+	}
+	r.setLastFrame(q, &next)
+	return sender.SendBytes(next.Bytes(include))
+}
+
+// exchange sends the query to the server over a DoExchange stream and calls
+// fn with each record the server pushes back. The stream is kept open until
+// the server ends it or ctx is canceled.
+func (r *runner) exchange(ctx context.Context, query string, fn func(arrow.Record) error) error {
+	desc, err := statementDescriptor(query)
+	if err != nil {
+		return err
+	}
+
+	stream, err := r.client.FlightClient().DoExchange(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&flight.FlightData{FlightDescriptor: desc}); err != nil {
+		return err
+	}
+
+	reader, err := flight.NewRecordReader(stream)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	for reader.Next() {
+		if err := validateRecord(reader.Record()); err != nil {
+			return err
+		}
+		if err := fn(reader.Record()); err != nil {
+			return err
+		}
+	}
+	return reader.Err()
+}
+
+// statementDescriptor returns the descriptor of the FlightSQL command
+// running the SQL query, as sent to GetFlightInfo. The flightsql package
+// doesn't export its command messages, so the command is encoded here.
+func statementDescriptor(query string) (*flight.FlightDescriptor, error) {
+	cmd := protowire.AppendTag(nil, 1, protowire.BytesType)
+	cmd = protowire.AppendString(cmd, query)
+	b, err := proto.Marshal(&anypb.Any{TypeUrl: statementQueryTypeURL, Value: cmd})
+	if err != nil {
+		return nil, err
+	}
+	return &flight.FlightDescriptor{Type: flight.DescriptorCMD, Cmd: b}, nil
+}
+
+// resultFrame converts records of the results of a query, sharing their
+// schema, into the frame published for them, or nil when they have no rows.
+func resultFrame(qm *queryModel, records ...arrow.Record) (*data.Frame, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	reader, err := array.NewRecordReader(records[0].Schema(), records)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+
+	resp := newQueryDataResponse(reader, qm, metadata.MD{})
+	transformResponse(&resp, qm)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if len(resp.Frames) == 0 {
+		return nil, nil
+	}
+	return resp.Frames[0], nil
+}
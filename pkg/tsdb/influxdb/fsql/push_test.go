@@ -0,0 +1,157 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// pushServer is a Flight server pushing its records to the DoExchange
+// streams sending it a statement, which are then kept open.
+type pushServer struct {
+	flight.BaseFlightServer
+	records []arrow.Record
+	queries chan string
+}
+
+func (s *pushServer) DoExchange(stream flight.FlightService_DoExchangeServer) error {
+	msg, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	query, err := statementQuery(msg.GetFlightDescriptor())
+	if err != nil {
+		return err
+	}
+	s.queries <- query
+
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(s.records[0].Schema()))
+	defer func() { _ = w.Close() }()
+	for _, record := range s.records {
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// statementQuery returns the SQL of the statement of a FlightSQL descriptor.
+func statementQuery(desc *flight.FlightDescriptor) (string, error) {
+	var cmd anypb.Any
+	if err := proto.Unmarshal(desc.GetCmd(), &cmd); err != nil {
+		return "", err
+	}
+	_, _, n := protowire.ConsumeTag(cmd.Value)
+	query, _ := protowire.ConsumeString(cmd.Value[n:])
+	return query, nil
+}
+
+type packetSender chan *backend.StreamPacket
+
+func (s packetSender) Send(packet *backend.StreamPacket) error {
+	s <- packet
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "value", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	first := newTestRecordReader(t, schema, `[1, 2]`)
+	second := newTestRecordReader(t, schema, `[3]`)
+	require.True(t, first.Next())
+	require.True(t, second.Next())
+
+	srv := &pushServer{records: []arrow.Record{first.Record(), second.Record()}, queries: make(chan string, 1)}
+	server := flight.NewServerWithMiddleware(nil)
+	server.RegisterFlightService(srv)
+	require.NoError(t, server.Init("localhost:0"))
+	go func() { _ = server.Serve() }()
+	t.Cleanup(server.Shutdown)
+
+	dsInfo := &models.DatasourceInfo{URL: "http://" + server.Addr().String(), DbName: "influxdb"}
+	conn, err := NewConnection(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	dsInfo.FlightSQL = conn
+
+	b, err := json.Marshal(queryRequest{RefID: "A", RawQuery: "select value from events", Format: "table", Push: true})
+	require.NoError(t, err)
+	resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "influx"}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: b}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Len(t, resp.Responses["A"].Frames, 1)
+	ch, err := live.ParseChannel(resp.Responses["A"].Frames[0].Meta.Channel)
+	require.NoError(t, err)
+	assert.Equal(t, live.ScopeDatasource, ch.Scope)
+	assert.Equal(t, "influx", ch.Namespace)
+	assert.True(t, IsPushPath(ch.Path))
+
+	sub, err := SubscribePush(dsInfo, ch.Path)
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusOK, sub.Status)
+	assert.Nil(t, sub.InitialData)
+
+	sub, err = SubscribePush(dsInfo, pushPathPrefix+"unknown")
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, sub.Status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(packetSender, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- RunPush(ctx, dsInfo, ch.Path, backend.NewStreamSender(packets))
+	}()
+
+	assert.Equal(t, "select value from events", <-srv.queries)
+	// The schema is only sent with the first frame.
+	var frame, next map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal((<-packets).Data, &frame))
+	assert.Contains(t, frame, "schema")
+	require.NoError(t, json.Unmarshal((<-packets).Data, &next))
+	assert.NotContains(t, next, "schema")
+	assert.JSONEq(t, `{"values": [[3]]}`, string(next["data"]))
+
+	sub, err = SubscribePush(dsInfo, ch.Path)
+	require.NoError(t, err)
+	assert.NotNil(t, sub.InitialData)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("push stream still running")
+	}
+}
+
+func TestStatementDescriptor(t *testing.T) {
+	desc, err := statementDescriptor("select 1")
+	require.NoError(t, err)
+	assert.Equal(t, flight.DescriptorCMD, desc.Type)
+
+	var cmd anypb.Any
+	require.NoError(t, proto.Unmarshal(desc.Cmd, &cmd))
+	assert.Equal(t, statementQueryTypeURL, cmd.TypeUrl)
+	num, typ, n := protowire.ConsumeTag(cmd.Value)
+	assert.Equal(t, protowire.Number(1), num)
+	assert.Equal(t, protowire.BytesType, typ)
+	query, _ := protowire.ConsumeString(cmd.Value[n:])
+	assert.Equal(t, "select 1", query)
+}
@@ -7,34 +7,167 @@ import (
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
 )
 
 type queryModel struct {
 	*sqlutil.Query
+
+	// QueryType is the type of the query; SQL is run unless it is
+	// [queryTypeSchema].
+	QueryType string
+	// Table is the table described by a schema query.
+	Table string
+	// NullHandling selects how nulls of numeric fields are filled; see
+	// [fillNulls].
+	NullHandling string
+	// SelectColumns and ExcludeColumns restrict the columns converted into
+	// frames; see [projectColumns].
+	SelectColumns  []string
+	ExcludeColumns []string
+	// TimeColumn is the name of the time column of time series results
+	// chosen by the query, for results with several timestamp columns. It
+	// overrides TimeColumns; the other timestamp columns are kept as
+	// regular fields.
+	TimeColumn string
+	// TimeColumns are the names of the time column, in order of preference,
+	// configured on the datasource.
+	TimeColumns []string
+	// ValueColumns are the names of the preferred value columns configured
+	// on the datasource.
+	ValueColumns []string
+	// NumberFormat enables parsing string columns holding numbers; see
+	// [parseNumericStrings].
+	NumberFormat *numberFormat
+	// Instant reduces each series of time series results to a single value
+	// with Reducer; see [reduceFrame].
+	Instant bool
+	Reducer string
+	// Database is the database queried, overriding the one of the
+	// datasource.
+	Database string
+	// Variables are the values of the dashboard variables referenced by the
+	// metadata of the datasource and the database of the query.
+	Variables map[string]string
+	// HideTime and HideColumns drop the time column and the named columns
+	// from table results once converted; see [hideColumns].
+	HideTime    bool
+	HideColumns []string
+	// InferUnits sets the units of fields from the suffixes of their names;
+	// see [inferUnits].
+	InferUnits bool
+	// Chunks are the SQL of the query run over consecutive parts of its
+	// time range when it is split; see [(*runner).splitResponse]. Only
+	// queries scanning rows are split; see [checkSplittable].
+	Chunks []string
+	// Pivot turns the rows of time series results into one series per
+	// metric name; see [pivotFrame].
+	Pivot *pivotOptions
+	// Push has the server push the results of the query over a DoExchange
+	// stream, published on a Live channel; see [RunPush].
+	Push bool
+	// Stream re-executes the query over the time elapsed since its last
+	// execution, publishing the results received on a Live channel; see
+	// [RunStream].
+	Stream bool
+	// MaxStringLength is the number of characters string values are
+	// truncated to; see [truncateStrings].
+	MaxStringLength int
+	// TimeShift is the offset of the $__timeShift macro of the query, which
+	// the time fields of the results are shifted forward by; see
+	// [shiftTimeFields].
+	TimeShift *timeShift
+	// Limits bound the execution of the query; see [requestLimits].
+	Limits queryLimits
+	// Params are bound to the placeholders of the SQL, which then runs as a
+	// prepared statement; see [queryParam].
+	Params []boundParam
+
+	// channelKey identifies push and streamed queries in the paths of their
+	// Live channels whatever their time range, so refreshing a panel with a
+	// relative time range keeps its channel; see [pushPath].
+	channelKey string
+	// timeRange is the time range of a streamed query before its time
+	// shift, and interpolateRange interpolates its macros over another time
+	// range; see [RunStream].
+	timeRange        backend.TimeRange
+	interpolateRange func(backend.TimeRange) (string, error)
+}
+
+// defaultTimeColumn is the time column of time series results when none is
+// configured on the datasource.
+const defaultTimeColumn = "time"
+
+// timeColumns returns the candidate names of the time column of time series
+// results, in order of preference.
+func (qm *queryModel) timeColumns() []string {
+	if qm.TimeColumn != "" {
+		return []string{qm.TimeColumn}
+	}
+	if len(qm.TimeColumns) == 0 {
+		return []string{defaultTimeColumn}
+	}
+	return qm.TimeColumns
 }
 
 // queryRequest is an inbound query request as part of a batch of queries sent
 // to [(*FlightSQLDatasource).QueryData].
 type queryRequest struct {
-	RefID                string `json:"refId"`
-	RawQuery             string `json:"rawSql"`
-	IntervalMilliseconds int    `json:"intervalMs"`
-	MaxDataPoints        int64  `json:"maxDataPoints"`
-	Format               string `json:"format"`
+	RefID                string            `json:"refId"`
+	RawQuery             string            `json:"rawSql"`
+	IntervalMilliseconds int               `json:"intervalMs"`
+	MaxDataPoints        int64             `json:"maxDataPoints"`
+	Format               string            `json:"format"`
+	Table                string            `json:"table"`
+	OrderByTime          string            `json:"orderByTime"`
+	NullHandling         string            `json:"nullHandling"`
+	SelectColumns        []string          `json:"selectColumns"`
+	ExcludeColumns       []string          `json:"excludeColumns"`
+	ParseNumbers         *numberFormat     `json:"parseNumbers"`
+	Timezone             string            `json:"timezone"`
+	TimeColumn           string            `json:"timeColumn"`
+	AdhocFilters         []adhocFilter     `json:"adhocFilters"`
+	Pivot                *pivotOptions     `json:"pivot"`
+	Database             string            `json:"database"`
+	Variables            map[string]string `json:"variables"`
+	Instant              bool              `json:"instant"`
+	Reducer              string            `json:"reducer"`
+	HideTime             bool              `json:"hideTime"`
+	HideColumns          []string          `json:"hideColumns"`
+	InferUnits           *bool             `json:"inferUnits"`
+	SplitRanges          int               `json:"splitRanges"`
+	Push                 bool              `json:"push"`
+	Stream               bool              `json:"stream"`
+	MaxStringLength      int               `json:"maxStringLength"`
+	Params               []queryParam      `json:"params"`
 }
 
-func getQueryModel(dataQuery backend.DataQuery) (*queryModel, error) {
+// orderByTimeSQL makes time series queries sort on the server by wrapping
+// the SQL in an ORDER BY time clause. Otherwise unsorted results are sorted
+// after conversion.
+const orderByTimeSQL = "sql"
+
+func getQueryModel(dataQuery backend.DataQuery, dsInfo *models.DatasourceInfo) (*queryModel, error) {
 	var q queryRequest
 	if err := json.Unmarshal(dataQuery.JSON, &q); err != nil {
 		return nil, fmt.Errorf("unmarshal json: %w", err)
 	}
 
+	// Queries not choosing a format use the default of the datasource.
+	if q.Format == "" {
+		q.Format = dsInfo.DefaultFormat
+	}
 	var format sqlutil.FormatQueryOption
 	switch q.Format {
 	case "time_series":
 		format = sqlutil.FormatOptionTimeSeries
 	case "table":
 		format = sqlutil.FormatOptionTable
+	case "logs":
+		format = sqlutil.FormatOptionLogs
+	case "trace":
+		format = formatOptionTrace
 	default:
 		format = sqlutil.FormatOptionTimeSeries
 	}
@@ -48,12 +181,131 @@ func getQueryModel(dataQuery backend.DataQuery) (*queryModel, error) {
 		Format:        format,
 	}
 
-	// Process macros and execute the query.
-	sql, err := sqlutil.Interpolate(query, macros)
+	loc := time.UTC
+	if q.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(q.Timezone); err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+	}
+
+	// The time shift applies to the time range seen by the other macros.
+	shift, err := applyTimeShift(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rawSQL := query.RawSQL
+	sql, err := interpolate(query, newMacros(loc), q.AdhocFilters)
 	if err != nil {
-		return nil, fmt.Errorf("macro interpolation: %w", err)
+		return nil, err
 	}
 	query.RawSQL = sql
 
-	return &queryModel{query}, nil
+	qm := &queryModel{
+		Query:          query,
+		QueryType:      dataQuery.QueryType,
+		Table:          q.Table,
+		NullHandling:   q.NullHandling,
+		SelectColumns:  q.SelectColumns,
+		ExcludeColumns: q.ExcludeColumns,
+		TimeColumn:     q.TimeColumn,
+		TimeColumns:    dsInfo.TimeColumns,
+		ValueColumns:   dsInfo.ValueColumns,
+		NumberFormat:   q.ParseNumbers,
+		Pivot:          q.Pivot,
+		Database:       q.Database,
+		Variables:      q.Variables,
+		Instant:        q.Instant,
+		HideTime:       q.HideTime,
+		HideColumns:    q.HideColumns,
+		InferUnits:     dsInfo.InferUnits,
+		Push:           q.Push,
+		Stream:         q.Stream,
+		TimeShift:      shift,
+	}
+	if q.InferUnits != nil {
+		qm.InferUnits = *q.InferUnits
+	}
+	if q.Push || q.Stream {
+		filters, err := json.Marshal(q.AdhocFilters)
+		if err != nil {
+			return nil, err
+		}
+		qm.channelKey = fmt.Sprintf("%s\n%s\n%s\n%s", q.RawQuery, q.Timezone, dataQuery.TimeRange.Duration().Round(time.Second), filters)
+	}
+	if q.Stream {
+		qm.timeRange = dataQuery.TimeRange
+		qm.interpolateRange = func(tr backend.TimeRange) (string, error) {
+			next := *query
+			next.RawSQL = rawSQL
+			next.TimeRange = tr
+			if shift != nil {
+				next.TimeRange = backend.TimeRange{From: shift.add(tr.From, -1), To: shift.add(tr.To, -1)}
+			}
+			return interpolate(&next, newMacros(loc), q.AdhocFilters)
+		}
+	}
+	// Queries may lower or raise the string length of the datasource.
+	qm.MaxStringLength = dsInfo.MaxStringLength
+	if q.MaxStringLength > 0 {
+		qm.MaxStringLength = q.MaxStringLength
+	}
+
+	if len(q.Params) > 0 {
+		if q.Push || q.Stream || q.SplitRanges > 1 {
+			return nil, fmt.Errorf("params: not supported by push, streamed or split queries")
+		}
+		if qm.Params, err = bindParams(q.Params); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Instant {
+		if qm.Reducer, err = validReducer(q.Reducer); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.SplitRanges > maxSplitRanges {
+		return nil, fmt.Errorf("split ranges: at most %d ranges are supported", maxSplitRanges)
+	}
+	if q.SplitRanges > 1 {
+		if err := checkSplittable(sql); err != nil {
+			return nil, err
+		}
+		ranges := splitTimeRange(query.TimeRange, q.SplitRanges)
+		for i, tr := range ranges {
+			chunk := *query
+			chunk.RawSQL = rawSQL
+			chunk.TimeRange = tr
+			sql, err := interpolate(&chunk, chunkMacros(loc, i == len(ranges)-1), q.AdhocFilters)
+			if err != nil {
+				return nil, err
+			}
+			qm.Chunks = append(qm.Chunks, sql)
+		}
+	}
+
+	if q.OrderByTime == orderByTimeSQL && format == sqlutil.FormatOptionTimeSeries {
+		orderBy := func(sql string) string {
+			return fmt.Sprintf("SELECT * FROM %s ORDER BY %s", subquery(sql), quoteIdentifier(qm.timeColumns()[0]))
+		}
+		query.RawSQL = orderBy(sql)
+		for i, sql := range qm.Chunks {
+			qm.Chunks[i] = orderBy(sql)
+		}
+	}
+
+	return qm, nil
+}
+
+// interpolate expands the macros of the query and applies the ad hoc
+// filters to the resulting SQL.
+func interpolate(query *sqlutil.Query, macros sqlutil.Macros, filters []adhocFilter) (string, error) {
+	sql, err := interpolateMacros(query, macros)
+	if err != nil {
+		return "", fmt.Errorf("macro interpolation: %w", err)
+	}
+	return applyAdhocFilters(sql, filters)
 }
@@ -0,0 +1,110 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestGetQueryModel_OrderByTime(t *testing.T) {
+	t.Run("wraps time series queries", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu;", "format": "time_series", "orderByTime": "sql"}`),
+		}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.Equal(t, "SELECT * FROM (select * from cpu\n) ORDER BY \"time\"", qm.RawSQL)
+	})
+
+	t.Run("quotes the time column", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu", "format": "time_series", "orderByTime": "sql"}`),
+		}, &models.DatasourceInfo{TimeColumns: []string{"Event Time"}})
+		require.NoError(t, err)
+		require.Equal(t, "SELECT * FROM (select * from cpu\n) ORDER BY \"Event Time\"", qm.RawSQL)
+	})
+
+	t.Run("ends the subquery on a new line", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu -- all hosts", "format": "time_series", "orderByTime": "sql"}`),
+		}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.Equal(t, "SELECT * FROM (select * from cpu -- all hosts\n) ORDER BY \"time\"", qm.RawSQL)
+	})
+
+	t.Run("leaves table queries untouched", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu", "format": "table", "orderByTime": "sql"}`),
+		}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.Equal(t, "select * from cpu", qm.RawSQL)
+	})
+
+	t.Run("sorts after conversion by default", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu", "format": "time_series"}`),
+		}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.Equal(t, "select * from cpu", qm.RawSQL)
+	})
+}
+
+func TestGetQueryModel_Instant(t *testing.T) {
+	t.Run("defaults to the last value", func(t *testing.T) {
+		qm, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu", "instant": true}`),
+		}, &models.DatasourceInfo{})
+		require.NoError(t, err)
+		require.True(t, qm.Instant)
+		require.Equal(t, reduceLast, qm.Reducer)
+	})
+
+	t.Run("unsupported reducer", func(t *testing.T) {
+		_, err := getQueryModel(backend.DataQuery{
+			JSON: []byte(`{"rawSql": "select * from cpu", "instant": true, "reducer": "p99"}`),
+		}, &models.DatasourceInfo{})
+		require.EqualError(t, err, `unsupported reducer "p99", expected "last" or "mean"`)
+	})
+}
+
+func TestGetQueryModel_InferUnits(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		json       string
+		datasource bool
+		want       bool
+	}{
+		{name: "disabled by default", json: `{"rawSql": "select 1"}`},
+		{name: "enabled on the datasource", json: `{"rawSql": "select 1"}`, datasource: true, want: true},
+		{name: "disabled by the query", json: `{"rawSql": "select 1", "inferUnits": false}`, datasource: true},
+		{name: "enabled by the query", json: `{"rawSql": "select 1", "inferUnits": true}`, want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			qm, err := getQueryModel(backend.DataQuery{JSON: []byte(tc.json)}, &models.DatasourceInfo{InferUnits: tc.datasource})
+			require.NoError(t, err)
+			require.Equal(t, tc.want, qm.InferUnits)
+		})
+	}
+}
+
+func TestGetQueryModel_DefaultFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		json       string
+		datasource string
+		want       sqlutil.FormatQueryOption
+	}{
+		{name: "time series by default", json: `{"rawSql": "select 1"}`, want: sqlutil.FormatOptionTimeSeries},
+		{name: "default of the datasource", json: `{"rawSql": "select 1"}`, datasource: "table", want: sqlutil.FormatOptionTable},
+		{name: "format of the query", json: `{"rawSql": "select 1", "format": "logs"}`, datasource: "table", want: sqlutil.FormatOptionLogs},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			qm, err := getQueryModel(backend.DataQuery{JSON: []byte(tc.json)}, &models.DatasourceInfo{DefaultFormat: tc.datasource})
+			require.NoError(t, err)
+			require.Equal(t, tc.want, qm.Format)
+		})
+	}
+}
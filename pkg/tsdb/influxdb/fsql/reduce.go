@@ -0,0 +1,91 @@
+package fsql
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// reduceLast reduces each series to its last non-null value.
+	reduceLast = "last"
+	// reduceMean reduces each series to the mean of its non-null values.
+	reduceMean = "mean"
+)
+
+// validReducer returns the reducer of an instant query, defaulting to
+// [reduceLast].
+func validReducer(reducer string) (string, error) {
+	switch reducer {
+	case "":
+		return reduceLast, nil
+	case reduceLast, reduceMean:
+		return reducer, nil
+	default:
+		return "", fmt.Errorf("unsupported reducer %q, expected %q or %q", reducer, reduceLast, reduceMean)
+	}
+}
+
+// reduceFrame reduces the series of a time series frame to a single row, so
+// alert rules get one value per series without a reduce expression. The row
+// is timestamped with the last time of the frame and numeric values become
+// nullable float64s. Frames without a time field are left as is.
+func reduceFrame(frame *data.Frame, reducer string) {
+	timeIdx := -1
+	for i, f := range frame.Fields {
+		if f.Type().Time() {
+			timeIdx = i
+			break
+		}
+	}
+	if timeIdx == -1 {
+		return
+	}
+
+	n := frame.Fields[timeIdx].Len()
+	fields := make([]*data.Field, len(frame.Fields))
+	for i, f := range frame.Fields {
+		var out *data.Field
+		switch {
+		case i == timeIdx || !f.Type().Numeric():
+			out = data.NewFieldFromFieldType(f.Type(), 0)
+			if n > 0 {
+				out.Append(f.CopyAt(n - 1))
+			}
+		default:
+			out = data.NewField(f.Name, f.Labels, []*float64{reduceField(f, reducer)})
+		}
+		out.Name = f.Name
+		out.Labels = f.Labels
+		out.Config = f.Config
+		fields[i] = out
+	}
+	frame.Fields = fields
+}
+
+// reduceField reduces the non-null values of a numeric field, or returns nil
+// when it has none.
+func reduceField(f *data.Field, reducer string) *float64 {
+	var (
+		last  float64
+		sum   float64
+		count int
+	)
+	for i := 0; i < f.Len(); i++ {
+		v, err := f.NullableFloatAt(i)
+		if err != nil || v == nil {
+			continue
+		}
+		last = *v
+		sum += *v
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	if reducer == reduceMean {
+		mean := sum / float64(count)
+		return &mean
+	}
+	return &last
+}
@@ -0,0 +1,101 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// Servers running long analytical jobs may refuse to answer a query until
+// its results are ready, with a status carrying a google.rpc RetryInfo
+// asking the client to come back later. The query is then executed again
+// after the delay, a bounded number of times: this isn't the PollFlightInfo
+// RPC of Arrow Flight 14, which this client lacks, so servers restarting the
+// job on each execution won't make progress, and nothing is known of the
+// progress of the job.
+const (
+	minRetryDelay = 100 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+	// defaultMaxRetries bounds the executions of queries whose limits don't.
+	defaultMaxRetries = 5
+)
+
+// executeWithRetry executes the query, executing it again while the server
+// asks to retry later; see [retryExecute].
+func (r *runner) executeWithRetry(ctx context.Context, sql string, maxRetries int) (*flight.FlightInfo, int, error) {
+	return retryExecute(ctx, maxRetries, func(ctx context.Context) (*flight.FlightInfo, error) {
+		return r.client.Execute(ctx, sql)
+	})
+}
+
+// retryExecute calls execute while the server asks to retry later, at most
+// maxRetries times, or defaultMaxRetries times when it isn't positive. It
+// returns the number of retries along with the results.
+func retryExecute(ctx context.Context, maxRetries int, execute func(context.Context) (*flight.FlightInfo, error)) (*flight.FlightInfo, int, error) {
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	retries := 0
+	for {
+		info, err := execute(ctx)
+		if err == nil {
+			return info, retries, nil
+		}
+
+		delay, ok := retryDelay(err)
+		if !ok {
+			return nil, retries, err
+		}
+		if retries >= maxRetries {
+			return nil, retries, fmt.Errorf("query not ready after %d retries: %w", retries, err)
+		}
+		retries++
+		glog.FromContext(ctx).Debug("FlightSQL query not ready, retrying", "retries", retries, "delay", delay)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, retries, fmt.Errorf("query not ready after %d retries: %w", retries, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// retryNotice tells users the query was executed again because the server
+// wasn't ready to answer it.
+func retryNotice(retries int) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("The server asked to retry the query later, it was executed %d more times", retries),
+	}
+}
+
+// retryDelay returns how long to wait before retrying when err asks the
+// client to retry later.
+func retryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.RetryInfo)
+		if !ok {
+			continue
+		}
+		delay := info.GetRetryDelay().AsDuration()
+		if delay < minRetryDelay {
+			delay = minRetryDelay
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,85 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryDelay(t *testing.T) {
+	withRetryInfo := func(d time.Duration) error {
+		st, err := status.New(codes.Unavailable, "query running").WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(d),
+		})
+		require.NoError(t, err)
+		return st.Err()
+	}
+
+	cs := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		ok    bool
+	}{
+		{name: "plain error", err: errors.New("boom")},
+		{name: "status without retry info", err: status.Error(codes.Unavailable, "down")},
+		{name: "retry info", err: withRetryInfo(2 * time.Second), delay: 2 * time.Second, ok: true},
+		{name: "clamped to minimum", err: withRetryInfo(0), delay: minRetryDelay, ok: true},
+		{name: "clamped to maximum", err: withRetryInfo(time.Hour), delay: maxRetryDelay, ok: true},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			delay, ok := retryDelay(c.err)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.delay, delay)
+		})
+	}
+}
+
+func TestRetryExecute(t *testing.T) {
+	notReady, err := status.New(codes.Unavailable, "query running").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(0),
+	})
+	require.NoError(t, err)
+	executeAfter := func(n int, calls *int) func(context.Context) (*flight.FlightInfo, error) {
+		return func(context.Context) (*flight.FlightInfo, error) {
+			*calls++
+			if *calls <= n {
+				return nil, notReady.Err()
+			}
+			return &flight.FlightInfo{}, nil
+		}
+	}
+
+	t.Run("retries until ready", func(t *testing.T) {
+		calls := 0
+		info, retries, err := retryExecute(context.Background(), 3, executeAfter(2, &calls))
+		require.NoError(t, err)
+		require.NotNil(t, info)
+		require.Equal(t, 2, retries)
+		require.Equal(t, "The server asked to retry the query later, it was executed 2 more times", retryNotice(retries).Text)
+	})
+
+	t.Run("bounded retries", func(t *testing.T) {
+		calls := 0
+		_, retries, err := retryExecute(context.Background(), 2, executeAfter(10, &calls))
+		require.ErrorContains(t, err, "query not ready after 2 retries")
+		require.Equal(t, 2, retries)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("bounded by default", func(t *testing.T) {
+		calls := 0
+		_, retries, err := retryExecute(context.Background(), 0, executeAfter(100, &calls))
+		require.Error(t, err)
+		require.Equal(t, defaultMaxRetries, retries)
+	})
+}
@@ -0,0 +1,122 @@
+package fsql
+
+import (
+	"context"
+	"sync"
+)
+
+// fromAlertHeader is the header set by Grafana on the queries of alert
+// rules.
+const fromAlertHeader = "FromAlert"
+
+// Priorities of the query requests waiting for a slot of a [scheduler].
+const (
+	priorityDashboard = iota
+	priorityAlert
+	numPriorities
+)
+
+// requestPriority returns the priority of the query request with the given
+// headers: alert evaluations come before dashboard refreshes.
+func requestPriority(headers map[string]string) int {
+	if headers[fromAlertHeader] == "true" {
+		return priorityAlert
+	}
+	return priorityDashboard
+}
+
+// scheduler limits the number of query requests running at once on a
+// connection. When all its slots are taken, the next free slot goes to the
+// waiting request of highest priority, in order of arrival, so refresh
+// storms don't make alert evaluations miss their deadline.
+type scheduler struct {
+	// limit is the number of slots; zero means unlimited.
+	limit int
+
+	mu      sync.Mutex
+	running int
+	// waiting holds the channels closed to hand a slot over to the waiting
+	// requests, by priority.
+	waiting [numPriorities][]chan struct{}
+}
+
+func newScheduler(limit int) *scheduler {
+	return &scheduler{limit: limit}
+}
+
+// acquire waits for a slot for a request of the given priority. It fails
+// when ctx is done first. Each successful acquire must be followed by a
+// release.
+func (s *scheduler) acquire(ctx context.Context, priority int) error {
+	if s.limit <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	if s.running < s.limit && !s.hasWaiting() {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	s.waiting[priority] = append(s.waiting[priority], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ready:
+			// The slot was handed over meanwhile: pass it on.
+			s.releaseLocked()
+		default:
+			s.removeWaiting(priority, ready)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a request, handing it over to the next waiting
+// request if any.
+func (s *scheduler) release() {
+	if s.limit <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *scheduler) releaseLocked() {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(s.waiting[p]) > 0 {
+			ready := s.waiting[p][0]
+			s.waiting[p] = s.waiting[p][1:]
+			close(ready)
+			return
+		}
+	}
+	s.running--
+}
+
+func (s *scheduler) hasWaiting() bool {
+	for _, w := range s.waiting {
+		if len(w) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scheduler) removeWaiting(priority int, ready chan struct{}) {
+	w := s.waiting[priority]
+	for i, c := range w {
+		if c == ready {
+			s.waiting[priority] = append(w[:i:i], w[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package fsql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestPriority(t *testing.T) {
+	assert.Equal(t, priorityAlert, requestPriority(map[string]string{"FromAlert": "true"}))
+	assert.Equal(t, priorityDashboard, requestPriority(map[string]string{}))
+	assert.Equal(t, priorityDashboard, requestPriority(nil))
+}
+
+func TestScheduler(t *testing.T) {
+	// waitFor acquires a slot in the background and returns a channel
+	// receiving the outcome.
+	waitFor := func(s *scheduler, ctx context.Context, priority int) chan error {
+		done := make(chan error, 1)
+		go func() { done <- s.acquire(ctx, priority) }()
+		return done
+	}
+	// waiting returns the number of waiting requests.
+	waiting := func(s *scheduler) int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.waiting[priorityDashboard]) + len(s.waiting[priorityAlert])
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		s := newScheduler(0)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, s.acquire(context.Background(), priorityDashboard))
+		}
+		s.release()
+	})
+
+	t.Run("alerts go first", func(t *testing.T) {
+		s := newScheduler(1)
+		require.NoError(t, s.acquire(context.Background(), priorityDashboard))
+
+		dashboard := waitFor(s, context.Background(), priorityDashboard)
+		require.Eventually(t, func() bool { return waiting(s) == 1 }, time.Second, time.Millisecond)
+		alert := waitFor(s, context.Background(), priorityAlert)
+		require.Eventually(t, func() bool { return waiting(s) == 2 }, time.Second, time.Millisecond)
+
+		s.release()
+		require.NoError(t, <-alert)
+		assert.Len(t, dashboard, 0)
+
+		s.release()
+		require.NoError(t, <-dashboard)
+		s.release()
+		assert.Equal(t, 0, s.running)
+	})
+
+	t.Run("waiting requests give up with their context", func(t *testing.T) {
+		s := newScheduler(1)
+		require.NoError(t, s.acquire(context.Background(), priorityAlert))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		canceled := waitFor(s, ctx, priorityDashboard)
+		require.Eventually(t, func() bool { return waiting(s) == 1 }, time.Second, time.Millisecond)
+		cancel()
+		require.ErrorIs(t, <-canceled, context.Canceled)
+		assert.Equal(t, 0, waiting(s))
+
+		s.release()
+		assert.Equal(t, 0, s.running)
+		require.NoError(t, s.acquire(context.Background(), priorityDashboard))
+	})
+}
@@ -0,0 +1,164 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeSchema is the query type returning the structure of a table
+// instead of running SQL.
+const queryTypeSchema = "schema"
+
+// tableSchemaResponse describes the columns of the given table using
+// GetTables with the table schema included. The frame contains one row per
+// column with its name, Arrow type and nullability.
+func (r *runner) tableSchemaResponse(ctx context.Context, table string) backend.DataResponse {
+	if table == "" {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "schema query: missing table")
+	}
+
+	tables, err := r.findTables(ctx, "", table)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, errorMessage(err))
+	}
+	if len(tables) == 0 {
+		return backend.ErrDataResponse(backend.StatusNotFound, fmt.Sprintf("schema query: table %q not found", table))
+	}
+
+	frame := data.NewFrame(table,
+		data.NewField("table", nil, []string{}),
+		data.NewField("column", nil, []string{}),
+		data.NewField("type", nil, []string{}),
+		data.NewField("nullable", nil, []bool{}),
+	)
+	for _, t := range tables {
+		if t.schema == nil {
+			return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("schema query: server did not return the schema of table %q", t.name))
+		}
+		for _, f := range t.schema.Fields() {
+			frame.AppendRow(t.name, f.Name, f.Type.String(), f.Nullable)
+		}
+	}
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeTable,
+	}
+
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// tableInfo is a table returned by GetTables.
+type tableInfo struct {
+	catalog   string
+	dbSchema  string
+	name      string
+	tableType string
+	// schema is only set when the tables were requested with IncludeSchema.
+	schema *arrow.Schema
+}
+
+// findTables returns the tables named table, with their schema, in the
+// database schema named dbSchema unless empty. The filters of GetTables are
+// LIKE patterns, in which underscores match any character, so the names of
+// the tables returned are compared exactly.
+func (r *runner) findTables(ctx context.Context, dbSchema, table string) ([]tableInfo, error) {
+	opts := &flightsql.GetTablesOpts{
+		TableNameFilterPattern: &table,
+		IncludeSchema:          true,
+	}
+	if dbSchema != "" {
+		opts.DbSchemaFilterPattern = &dbSchema
+	}
+	tables, err := r.getTables(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := tables[:0]
+	for _, t := range tables {
+		if t.name == table && (dbSchema == "" || t.dbSchema == dbSchema) {
+			found = append(found, t)
+		}
+	}
+	return found, nil
+}
+
+// getTables lists the tables matching opts.
+func (r *runner) getTables(ctx context.Context, opts *flightsql.GetTablesOpts) ([]tableInfo, error) {
+	info, err := r.client.GetTables(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []tableInfo
+	err = r.readEndpoints(ctx, info, func(record arrow.Record) error {
+		schema := record.Schema()
+		names, ok := stringColumn(record, "table_name")
+		if !ok {
+			return fmt.Errorf("get tables: missing table_name column")
+		}
+
+		catalogs, _ := stringColumn(record, "catalog_name")
+		dbSchemas, _ := stringColumn(record, "db_schema_name")
+		types, _ := stringColumn(record, "table_type")
+		var schemas *array.Binary
+		if idx := schema.FieldIndices("table_schema"); len(idx) > 0 {
+			schemas, _ = record.Column(idx[0]).(*array.Binary)
+		}
+
+		for i := 0; i < names.Len(); i++ {
+			t := tableInfo{
+				catalog:   stringValue(catalogs, i),
+				dbSchema:  stringValue(dbSchemas, i),
+				name:      names.Value(i),
+				tableType: stringValue(types, i),
+			}
+			if schemas != nil && schemas.IsValid(i) {
+				s, err := deserializeSchema(schemas.Value(i), r.client.Alloc)
+				if err != nil {
+					return fmt.Errorf("table %q: %w", t.name, err)
+				}
+				t.schema = s
+			}
+			tables = append(tables, t)
+		}
+		return nil
+	})
+	return tables, err
+}
+
+// readEndpoints fetches every endpoint of info and calls fn with each record.
+// Records are released once fn returns.
+func (r *runner) readEndpoints(ctx context.Context, info *flight.FlightInfo, fn func(arrow.Record) error) error {
+	for _, endpoint := range info.Endpoint {
+		if err := r.fetchEndpoint(ctx, endpoint, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringColumn returns the string column with the given name.
+func stringColumn(record arrow.Record, name string) (*array.String, bool) {
+	idx := record.Schema().FieldIndices(name)
+	if len(idx) == 0 {
+		return nil, false
+	}
+	col, ok := record.Column(idx[0]).(*array.String)
+	return col, ok
+}
+
+// stringValue returns the value of a string column at i, or the empty string
+// when the column is missing or the value null.
+func stringValue(col *array.String, i int) string {
+	if col == nil || col.IsNull(i) {
+		return ""
+	}
+	return col.Value(i)
+}
@@ -0,0 +1,180 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// maxSplitRanges is the maximum number of parts the time range of a query
+// can be split into.
+const maxSplitRanges = 32
+
+// unsplittablePattern matches the SQL combining rows, whose results over the
+// chunks of a split time range can't be concatenated: aggregates, grouping
+// and time bins would return a partial result per chunk, and limits a limit
+// per chunk.
+var unsplittablePattern = regexp.MustCompile(`(?i)\bgroup\s+by\b|\blimit\b|\bdistinct\b|\bover\s*\(|\bdate_bin(_gapfill)?\s*\(|` +
+	`\b(count|sum|avg|mean|min|max|median|stddev\w*|var\w*|first_value|last_value|array_agg|string_agg|bool_and|bool_or|approx_\w+|selector_\w+)\s*\(`)
+
+// checkSplittable fails for SQL whose time range can't be split. Only the
+// queries scanning rows can be split: the rows of the chunks are merged in
+// the order of the chunks.
+func checkSplittable(sql string) error {
+	if unsplittablePattern.MatchString(sql) {
+		return fmt.Errorf("split ranges: only queries scanning rows can be split, not queries with aggregates, GROUP BY, DISTINCT, LIMIT or time bins")
+	}
+	return nil
+}
+
+// splitTimeRange splits tr into n consecutive ranges of equal duration in
+// whole seconds, as the time macros format times to the second. Each range
+// ends where the next one starts; see [chunkMacros]. Ranges shorter than
+// n seconds aren't split.
+func splitTimeRange(tr backend.TimeRange, n int) []backend.TimeRange {
+	step := (tr.To.Sub(tr.From) / time.Duration(n)).Truncate(time.Second)
+	if step <= 0 {
+		return []backend.TimeRange{tr}
+	}
+
+	ranges := make([]backend.TimeRange, 0, n)
+	from := tr.From
+	for i := 0; i < n; i++ {
+		to := from.Add(step)
+		if i == n-1 {
+			to = tr.To
+		}
+		ranges = append(ranges, backend.TimeRange{From: from, To: to})
+		from = to
+	}
+	return ranges
+}
+
+// chunkMacros returns the macros of a chunk of a split query. $__timeFilter
+// includes both ends of its range, so in all but the last chunk it excludes
+// the end of the range, which is the start of the next chunk, and rows are
+// read once.
+func chunkMacros(loc *time.Location, last bool) sqlutil.Macros {
+	macros := newMacros(loc)
+	if !last {
+		macros["timeFilter"] = macroTimeFilterBefore
+	}
+	return macros
+}
+
+// macroTimeFilterBefore is $__timeFilter excluding the end of the range.
+func macroTimeFilterBefore(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return fmt.Sprintf("%s >= '%s' AND %s < '%s'", args[0], query.TimeRange.From.UTC().Format(time.RFC3339), args[0], query.TimeRange.To.UTC().Format(time.RFC3339)), nil
+}
+
+// splitResponse runs the chunks of a query split over its time range
+// concurrently and merges their records, in the order of the chunks, into a
+// single response. Long scans of partitioned storage are faster when the
+// server reads the parts of the range in parallel.
+func (r *runner) splitResponse(ctx context.Context, dsInfo *models.DatasourceInfo, qm *queryModel) backend.DataResponse {
+	var (
+		records = make([][]arrow.Record, len(qm.Chunks))
+		schemas = make([]*arrow.Schema, len(qm.Chunks))
+		retries = make([]int, len(qm.Chunks))
+	)
+	defer func() {
+		for _, chunk := range records {
+			for _, record := range chunk {
+				record.Release()
+			}
+		}
+	}()
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, sql := range qm.Chunks {
+		i, sql := i, sql
+		g.Go(func() error {
+			glog.Debug("InfluxDB executing SQL chunk", "chunk", i, "sql", sql)
+			info, n, err := r.executeWithRetry(gctx, sql, qm.Limits.maxRetries)
+			retries[i] = n
+			if err != nil {
+				return err
+			}
+
+			// Some servers don't announce the schema of the results.
+			if len(info.Schema) > 0 {
+				if schemas[i], err = deserializeSchema(info.Schema, memory.DefaultAllocator); err != nil {
+					return fmt.Errorf("chunk schema: %w", err)
+				}
+			}
+			return r.readEndpoints(gctx, info, func(record arrow.Record) error {
+				record.Retain()
+				records[i] = append(records[i], record)
+				return nil
+			})
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, errorMessage(err))
+	}
+
+	reader, err := mergeRecords(schemas, records)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, err.Error())
+	}
+	defer reader.Release()
+
+	resp := newQueryDataResponse(chunkRecords(projectColumns(validateRecords(reader), qm.SelectColumns, qm.ExcludeColumns), dsInfo.BatchSize), qm, metadata.MD{})
+	transformResponse(&resp, qm)
+	r.markUnchanged(ctx, &resp, qm)
+	total := 0
+	for _, n := range retries {
+		total += n
+	}
+	for _, frame := range resp.Frames {
+		setCustomMeta(frame, "chunks", len(qm.Chunks))
+		if total > 0 {
+			frame.AppendNotices(retryNotice(total))
+		}
+	}
+	return resp
+}
+
+// mergeRecords returns a reader of the records of all the chunks, whose
+// schema is the one of the records streamed or, without any record, the one
+// announced for the chunks, if any. The chunks must have the same schema.
+func mergeRecords(schemas []*arrow.Schema, chunks [][]arrow.Record) (array.RecordReader, error) {
+	var (
+		schema *arrow.Schema
+		merged []arrow.Record
+	)
+	for i, chunk := range chunks {
+		for _, record := range chunk {
+			if schema == nil {
+				schema = record.Schema()
+			}
+			if !record.Schema().Equal(schema) {
+				return nil, fmt.Errorf("chunk %d: schema differs from the first chunk", i)
+			}
+			merged = append(merged, record)
+		}
+	}
+	for _, s := range schemas {
+		if schema == nil {
+			schema = s
+		}
+	}
+	if schema == nil {
+		schema = arrow.NewSchema(nil, nil)
+	}
+	return array.NewRecordReader(schema, merged)
+}
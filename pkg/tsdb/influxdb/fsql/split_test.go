@@ -0,0 +1,78 @@
+package fsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestSplitTimeRange(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("equal ranges", func(t *testing.T) {
+		ranges := splitTimeRange(backend.TimeRange{From: t0, To: t0.Add(10 * time.Second)}, 3)
+		require.Equal(t, []backend.TimeRange{
+			{From: t0, To: t0.Add(3 * time.Second)},
+			{From: t0.Add(3 * time.Second), To: t0.Add(6 * time.Second)},
+			{From: t0.Add(6 * time.Second), To: t0.Add(10 * time.Second)},
+		}, ranges)
+	})
+
+	t.Run("short range", func(t *testing.T) {
+		tr := backend.TimeRange{From: t0, To: t0.Add(2 * time.Second)}
+		require.Equal(t, []backend.TimeRange{tr}, splitTimeRange(tr, 3))
+	})
+}
+
+func TestGetQueryModel_SplitRanges(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := func(json string) (*queryModel, error) {
+		return getQueryModel(backend.DataQuery{
+			JSON:      []byte(json),
+			TimeRange: backend.TimeRange{From: t0, To: t0.Add(2 * time.Hour)},
+		}, &models.DatasourceInfo{})
+	}
+
+	t.Run("chunks", func(t *testing.T) {
+		qm, err := query(`{"rawSql": "select * from cpu where $__timeFilter(time) and $__timeFrom <= time", "splitRanges": 2}`)
+		require.NoError(t, err)
+		require.Equal(t, "select * from cpu where time >= '2023-01-01T00:00:00Z' AND time <= '2023-01-01T02:00:00Z' and cast('2023-01-01T00:00:00Z' as timestamp) <= time", qm.RawSQL)
+		require.Equal(t, []string{
+			"select * from cpu where time >= '2023-01-01T00:00:00Z' AND time < '2023-01-01T01:00:00Z' and cast('2023-01-01T00:00:00Z' as timestamp) <= time",
+			"select * from cpu where time >= '2023-01-01T01:00:00Z' AND time <= '2023-01-01T02:00:00Z' and cast('2023-01-01T01:00:00Z' as timestamp) <= time",
+		}, qm.Chunks)
+	})
+
+	t.Run("not split", func(t *testing.T) {
+		qm, err := query(`{"rawSql": "select 1", "splitRanges": 1}`)
+		require.NoError(t, err)
+		require.Empty(t, qm.Chunks)
+	})
+
+	t.Run("binned aggregate", func(t *testing.T) {
+		// The bins of the aggregate would cross the chunk edge at 01:00.
+		_, err := query(`{"rawSql": "select $__dateBin(time), avg(usage) from cpu where $__timeFilter(time) group by 1", "intervalMs": 5400000, "splitRanges": 2}`)
+		require.ErrorContains(t, err, "split ranges: only queries scanning rows can be split")
+	})
+
+	for _, sql := range []string{
+		"select host, max(usage) from cpu where $__timeFilter(time) group by host",
+		"select count(*) from cpu where $__timeFilter(time)",
+		"select * from cpu where $__timeFilter(time) order by usage desc limit 10",
+		"select distinct host from cpu where $__timeFilter(time)",
+	} {
+		t.Run(sql, func(t *testing.T) {
+			_, err := query(`{"rawSql": "` + sql + `", "splitRanges": 2}`)
+			require.ErrorContains(t, err, "split ranges: only queries scanning rows can be split")
+		})
+	}
+
+	t.Run("too many ranges", func(t *testing.T) {
+		_, err := query(`{"rawSql": "select 1", "splitRanges": 33}`)
+		require.EqualError(t, err, "split ranges: at most 32 ranges are supported")
+	})
+}
@@ -0,0 +1,97 @@
+package fsql
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/stats"
+)
+
+var (
+	connectionEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana",
+		Name:      "influxdb_flightsql_connection_events_total",
+		Help:      "Number of FlightSQL connections established and closed, by event",
+	}, []string{"event"})
+
+	streamsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana",
+		Name:      "influxdb_flightsql_streams_in_flight",
+		Help:      "Number of FlightSQL streaming calls currently in progress",
+	}, []string{"method"})
+
+	rpcWireBytesHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grafana",
+		Name:      "influxdb_flightsql_rpc_wire_bytes",
+		Help:      "Bytes sent and received on the wire by FlightSQL calls, including gRPC framing",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 12),
+	}, []string{"method", "direction"})
+)
+
+const (
+	connectionEventConnect    = "connect"
+	connectionEventDisconnect = "disconnect"
+
+	directionSent     = "sent"
+	directionReceived = "received"
+)
+
+// statsHandler is a gRPC [stats.Handler] recording the lifecycle of the
+// FlightSQL connections and the wire bytes of each call as Prometheus
+// metrics.
+type statsHandler struct{}
+
+type rpcStatsKey struct{}
+
+// rpcStats accumulates the statistics of a call.
+type rpcStats struct {
+	method   string
+	stream   atomic.Bool
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (statsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{method: path.Base(info.FullMethodName)})
+}
+
+func (statsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	rs, ok := ctx.Value(rpcStatsKey{}).(*rpcStats)
+	if !ok {
+		return
+	}
+
+	switch s := s.(type) {
+	case *stats.Begin:
+		if s.IsClientStream || s.IsServerStream {
+			rs.stream.Store(true)
+			streamsInFlight.WithLabelValues(rs.method).Inc()
+		}
+	case *stats.OutPayload:
+		rs.sent.Add(int64(s.WireLength))
+	case *stats.InPayload:
+		rs.received.Add(int64(s.WireLength))
+	case *stats.End:
+		if rs.stream.Load() {
+			streamsInFlight.WithLabelValues(rs.method).Dec()
+		}
+		rpcWireBytesHistogram.WithLabelValues(rs.method, directionSent).Observe(float64(rs.sent.Load()))
+		rpcWireBytesHistogram.WithLabelValues(rs.method, directionReceived).Observe(float64(rs.received.Load()))
+	}
+}
+
+func (statsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (statsHandler) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		connectionEventsCounter.WithLabelValues(connectionEventConnect).Inc()
+	case *stats.ConnEnd:
+		connectionEventsCounter.WithLabelValues(connectionEventDisconnect).Inc()
+	}
+}
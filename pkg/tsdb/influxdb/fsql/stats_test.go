@@ -0,0 +1,58 @@
+package fsql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/stats"
+)
+
+func TestStatsHandler(t *testing.T) {
+	h := statsHandler{}
+
+	t.Run("connections", func(t *testing.T) {
+		connects := testutil.ToFloat64(connectionEventsCounter.WithLabelValues(connectionEventConnect))
+		disconnects := testutil.ToFloat64(connectionEventsCounter.WithLabelValues(connectionEventDisconnect))
+
+		ctx := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+		h.HandleConn(ctx, &stats.ConnBegin{Client: true})
+		h.HandleConn(ctx, &stats.ConnEnd{Client: true})
+
+		require.Equal(t, connects+1, testutil.ToFloat64(connectionEventsCounter.WithLabelValues(connectionEventConnect)))
+		require.Equal(t, disconnects+1, testutil.ToFloat64(connectionEventsCounter.WithLabelValues(connectionEventDisconnect)))
+	})
+
+	t.Run("streams and wire bytes", func(t *testing.T) {
+		const method = "/arrow.flight.protocol.FlightService/DoGet"
+		inFlight := streamsInFlight.WithLabelValues("DoGet")
+		before := testutil.ToFloat64(inFlight)
+
+		ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: method})
+		h.HandleRPC(ctx, &stats.Begin{Client: true, IsServerStream: true})
+		require.Equal(t, before+1, testutil.ToFloat64(inFlight))
+
+		h.HandleRPC(ctx, &stats.OutPayload{Client: true, WireLength: 10})
+		h.HandleRPC(ctx, &stats.InPayload{Client: true, WireLength: 1000})
+		h.HandleRPC(ctx, &stats.InPayload{Client: true, WireLength: 24})
+		h.HandleRPC(ctx, &stats.End{Client: true})
+		require.Equal(t, before, testutil.ToFloat64(inFlight))
+
+		rs := ctx.Value(rpcStatsKey{}).(*rpcStats)
+		require.Equal(t, "DoGet", rs.method)
+		require.Equal(t, int64(10), rs.sent.Load())
+		require.Equal(t, int64(1024), rs.received.Load())
+	})
+
+	t.Run("unary calls are not streams", func(t *testing.T) {
+		inFlight := streamsInFlight.WithLabelValues("GetFlightInfo")
+		before := testutil.ToFloat64(inFlight)
+
+		ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/arrow.flight.protocol.FlightService/GetFlightInfo"})
+		h.HandleRPC(ctx, &stats.Begin{Client: true})
+		require.Equal(t, before, testutil.ToFloat64(inFlight))
+		h.HandleRPC(ctx, &stats.End{Client: true})
+		require.Equal(t, before, testutil.ToFloat64(inFlight))
+	})
+}
@@ -0,0 +1,189 @@
+package fsql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// streamPathPrefix is the prefix of the paths of the Live channels on which
+// the results of streamed queries are published.
+const streamPathPrefix = "stream/"
+
+// Defaults of the flushing of the results of streamed queries.
+const (
+	defaultStreamFlushInterval   = time.Second
+	defaultStreamMaxBufferedRows = 10_000
+)
+
+// IsStreamPath reports whether the Live channel path is the one of a
+// streamed query.
+func IsStreamPath(path string) bool {
+	return strings.HasPrefix(path, streamPathPrefix)
+}
+
+// SubscribeStream subscribes to the channel of a streamed query, sending
+// the last frame published on it to the new subscriber.
+func SubscribeStream(dsInfo *models.DatasourceInfo, path string) (*backend.SubscribeStreamResponse, error) {
+	conn, ok := dsInfo.FlightSQL.(*Connection)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return conn.streams.subscribe(path)
+}
+
+// RunStream runs the streamed query of the channel: it executes the query
+// over its time range, then again every refresh interval over the time
+// elapsed since the previous execution, so the channel keeps receiving the
+// rows newly written. The records received are published as frames every
+// flush interval, or sooner once the maximum number of rows is buffered.
+// The DoGet streams of servers which keep them open are read until they
+// end. It returns once ctx is canceled or the connection is closed.
+func RunStream(ctx context.Context, dsInfo *models.DatasourceInfo, path string, sender *backend.StreamSender) error {
+	conn, ok := dsInfo.FlightSQL.(*Connection)
+	if !ok {
+		return fmt.Errorf("streamed queries need the FlightSQL connection of the datasource")
+	}
+	q, ok := conn.streams.get(path)
+	if !ok {
+		return fmt.Errorf("unknown stream channel %q", path)
+	}
+
+	r, err := runnerFromDataSource(dsInfo)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			glog.Warn("Failed to close fsql client", "err", err)
+		}
+	}()
+
+	ctx, cancel := conn.bindStream(ctx)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, q.md)
+
+	flushInterval := defaultStreamFlushInterval
+	if dsInfo.StreamFlushInterval > 0 {
+		flushInterval = time.Duration(dsInfo.StreamFlushInterval) * time.Millisecond
+	}
+	maxRows := int64(defaultStreamMaxBufferedRows)
+	if dsInfo.StreamMaxBufferedRows > 0 {
+		maxRows = int64(dsInfo.StreamMaxBufferedRows)
+	}
+
+	records := make(chan arrow.Record)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(records)
+		qm := conn.streams.query(q)
+		// The query is refreshed at the interval of its panel, but not more
+		// often than its results are flushed.
+		refreshInterval := max(qm.Interval, flushInterval)
+		from := qm.timeRange.From
+		for {
+			to := time.Now()
+			sql, err := qm.interpolateRange(backend.TimeRange{From: from, To: to})
+			if err != nil {
+				return err
+			}
+			info, _, err := r.executeWithRetry(gctx, sql, qm.Limits.maxRetries)
+			if err != nil {
+				return err
+			}
+			err = r.readEndpoints(gctx, info, func(record arrow.Record) error {
+				if err := validateRecord(record); err != nil {
+					return err
+				}
+				record.Retain()
+				select {
+				case records <- record:
+					return nil
+				case <-gctx.Done():
+					record.Release()
+					return gctx.Err()
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			from = to
+			select {
+			case <-time.After(refreshInterval):
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+	})
+	g.Go(func() error {
+		b := &streamBuffer{}
+		defer b.release()
+		flush := func() error {
+			defer b.release()
+			return conn.streams.publish(q, sender, b.records...)
+		}
+
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case record, ok := <-records:
+				if !ok {
+					return flush()
+				}
+				// Records of another schema can't share a frame.
+				if len(b.records) > 0 && !b.records[0].Schema().Equal(record.Schema()) {
+					if err := flush(); err != nil {
+						record.Release()
+						return err
+					}
+				}
+				b.add(record)
+				if b.rows >= maxRows {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			case <-ticker.C:
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	err = g.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// streamBuffer holds the records of a streamed query received since the
+// last flush.
+type streamBuffer struct {
+	records []arrow.Record
+	rows    int64
+}
+
+func (b *streamBuffer) add(record arrow.Record) {
+	b.records = append(b.records, record)
+	b.rows += record.NumRows()
+}
+
+// release releases the records of the buffer and empties it.
+func (b *streamBuffer) release() {
+	for _, record := range b.records {
+		record.Release()
+	}
+	b.records = nil
+	b.rows = 0
+}
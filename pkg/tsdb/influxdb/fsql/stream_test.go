@@ -0,0 +1,174 @@
+package fsql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/ipc"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// streamServer is a Flight server streaming the records sent to it over the
+// DoGet stream of any query, until records is closed. The SQL of the queries
+// is sent to queries.
+type streamServer struct {
+	flight.BaseFlightServer
+	schema  *arrow.Schema
+	records chan arrow.Record
+	queries chan string
+}
+
+func (s *streamServer) GetFlightInfo(_ context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	query, err := statementQuery(desc)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case s.queries <- query:
+	default:
+	}
+	return &flight.FlightInfo{
+		Schema:   flight.SerializeSchema(s.schema, memory.DefaultAllocator),
+		Endpoint: []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: []byte("results")}}},
+	}, nil
+}
+
+func (s *streamServer) DoGet(_ *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(s.schema))
+	defer func() { _ = w.Close() }()
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				return nil
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "value", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	record := func(values string) arrow.Record {
+		reader := newTestRecordReader(t, schema, values)
+		require.True(t, reader.Next())
+		return reader.Record()
+	}
+
+	srv := &streamServer{schema: schema, records: make(chan arrow.Record), queries: make(chan string, 10)}
+	server := flight.NewServerWithMiddleware(nil)
+	server.RegisterFlightService(srv)
+	require.NoError(t, server.Init("localhost:0"))
+	go func() { _ = server.Serve() }()
+	t.Cleanup(server.Shutdown)
+
+	dsInfo := &models.DatasourceInfo{
+		URL:                   "http://" + server.Addr().String(),
+		DbName:                "influxdb",
+		StreamFlushInterval:   250,
+		StreamMaxBufferedRows: 2,
+	}
+	conn, err := NewConnection(dsInfo)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	dsInfo.FlightSQL = conn
+
+	b, err := json.Marshal(queryRequest{RefID: "A", RawQuery: "select value from events where $__timeFilter(time)", Format: "table", Stream: true})
+	require.NoError(t, err)
+	channel := func(from time.Time, d time.Duration) live.Channel {
+		resp, err := Query(context.Background(), dsInfo, backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "influx"}},
+			Queries:       []backend.DataQuery{{RefID: "A", JSON: b, TimeRange: backend.TimeRange{From: from, To: from.Add(d)}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		ch, err := live.ParseChannel(resp.Responses["A"].Frames[0].Meta.Channel)
+		require.NoError(t, err)
+		return ch
+	}
+	// Refreshes of relative time ranges keep the channel of the query.
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, channel(from.Add(-time.Minute), 10*time.Minute), channel(from.Add(-time.Hour), 10*time.Minute))
+	assert.NotEqual(t, channel(from, time.Hour), channel(from, 10*time.Minute))
+	ch := channel(from, 10*time.Minute)
+	assert.True(t, IsStreamPath(ch.Path))
+
+	sub, err := SubscribeStream(dsInfo, streamPathPrefix+"unknown")
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, sub.Status)
+
+	packets := make(packetSender, 10)
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		done <- RunStream(ctx, dsInfo, ch.Path, backend.NewStreamSender(packets))
+	}()
+	values := func() string {
+		var frame map[string]json.RawMessage
+		select {
+		case packet := <-packets:
+			require.NoError(t, json.Unmarshal(packet.Data, &frame))
+		case <-time.After(5 * time.Second):
+			t.Fatal("no frame published")
+		}
+		return string(frame["data"])
+	}
+
+	// Rows are published as soon as the buffer is full...
+	srv.records <- record(`[1, 2]`)
+	assert.JSONEq(t, `{"values": [[1, 2]]}`, values())
+	srv.records <- record(`[3]`)
+	srv.records <- record(`[4, 5]`)
+	assert.JSONEq(t, `{"values": [[3, 4, 5]]}`, values())
+	// ...or once the flush interval is over.
+	srv.records <- record(`[6]`)
+	assert.JSONEq(t, `{"values": [[6]]}`, values())
+
+	sub, err = SubscribeStream(dsInfo, ch.Path)
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusOK, sub.Status)
+	assert.NotNil(t, sub.InitialData)
+
+	// The query is executed over its time range, then again over the time
+	// elapsed since, once the server ends its streams.
+	query := func() string {
+		for {
+			select {
+			case query := <-srv.queries:
+				// Skip the queries of the warm-up of the connection.
+				if strings.HasPrefix(query, "select value") {
+					return query
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("query not executed")
+			}
+		}
+	}
+	assert.Contains(t, query(), "'2023-01-01T00:00:00Z'")
+	close(srv.records)
+	assert.NotContains(t, query(), "'2023-01-01T00:00:00Z'")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still running")
+	}
+}
@@ -0,0 +1,41 @@
+package fsql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// clientTLSConfig returns the TLS configuration of the FlightSQL connection
+// of the datasource, or nil when it isn't secure. Servers are verified with
+// the CA bundle of the datasource, if any, or the system certificate pool,
+// and client certificates are presented for mutual TLS.
+func clientTLSConfig(dsInfo *models.DatasourceInfo) (*tls.Config, error) {
+	if !dsInfo.SecureGrpc {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if opts := dsInfo.TLS; opts != nil {
+		if (opts.ClientCertificate == "") != (opts.ClientKey == "") {
+			return nil, errors.New("tls: client certificate and key must be set together")
+		}
+		var err error
+		if cfg, err = httpclient.GetTLSConfig(httpclient.Options{TLS: opts}); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+	if cfg.RootCAs == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("x509: %s", err)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
@@ -0,0 +1,192 @@
+package fsql
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/flight"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql"
+	"github.com/apache/arrow/go/v13/arrow/flight/flightsql/example"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// testCert is a certificate with its key, PEM-encoded.
+type testCert struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pem    string
+	keyPEM string
+}
+
+// newTestCert issues a certificate from template, signed by parent or
+// self-signed when parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:   cert,
+		key:    key,
+		pem:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "influx.internal"},
+		DNSNames:    []string{"influx.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "grafana"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	serverPair, err := tls.X509KeyPair([]byte(serverCert.pem), []byte(serverCert.keyPEM))
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	db, err := example.CreateDB()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	sqliteServer, err := example.NewSQLiteFlightSQLServer(db)
+	require.NoError(t, err)
+	server := flight.NewServerWithMiddleware(nil, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))
+	server.RegisterFlightService(flightsql.NewFlightServer(sqliteServer))
+	require.NoError(t, server.Init("localhost:0"))
+	go func() { _ = server.Serve() }()
+	t.Cleanup(server.Shutdown)
+	_, port, err := net.SplitHostPort(server.Addr().String())
+	require.NoError(t, err)
+
+	query := func(opts *httpclient.TLSOptions) error {
+		resp, err := Query(context.Background(), &models.DatasourceInfo{
+			URL:        "https://127.0.0.1:" + port,
+			DbName:     "influxdb",
+			SecureGrpc: true,
+			TLS:        opts,
+		}, backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+		})
+		if err != nil {
+			return err
+		}
+		return resp.Responses["A"].Error
+	}
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		assert.NoError(t, query(&httpclient.TLSOptions{
+			CACertificate:     ca.pem,
+			ClientCertificate: clientCert.pem,
+			ClientKey:         clientCert.keyPEM,
+			ServerName:        "influx.internal",
+		}))
+	})
+
+	t.Run("skip verify", func(t *testing.T) {
+		assert.NoError(t, query(&httpclient.TLSOptions{
+			ClientCertificate:  clientCert.pem,
+			ClientKey:          clientCert.keyPEM,
+			InsecureSkipVerify: true,
+		}))
+	})
+
+	t.Run("wrong server name", func(t *testing.T) {
+		assert.Error(t, query(&httpclient.TLSOptions{
+			CACertificate:     ca.pem,
+			ClientCertificate: clientCert.pem,
+			ClientKey:         clientCert.keyPEM,
+		}))
+	})
+
+	t.Run("missing client certificate", func(t *testing.T) {
+		assert.Error(t, query(&httpclient.TLSOptions{CACertificate: ca.pem, ServerName: "influx.internal"}))
+	})
+
+	t.Run("untrusted server", func(t *testing.T) {
+		assert.Error(t, query(nil))
+	})
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	t.Run("insecure", func(t *testing.T) {
+		cfg, err := clientTLSConfig(&models.DatasourceInfo{})
+		require.NoError(t, err)
+		assert.Nil(t, cfg)
+	})
+
+	t.Run("system pool", func(t *testing.T) {
+		cfg, err := clientTLSConfig(&models.DatasourceInfo{SecureGrpc: true})
+		require.NoError(t, err)
+		assert.NotNil(t, cfg.RootCAs)
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		_, err := clientTLSConfig(&models.DatasourceInfo{SecureGrpc: true, TLS: &httpclient.TLSOptions{CACertificate: "not a pem"}})
+		assert.ErrorContains(t, err, "failed to parse TLS CA PEM certificate")
+	})
+
+	t.Run("client certificate without key", func(t *testing.T) {
+		_, err := clientTLSConfig(&models.DatasourceInfo{SecureGrpc: true, TLS: &httpclient.TLSOptions{ClientCertificate: "cert"}})
+		assert.EqualError(t, err, "tls: client certificate and key must be set together")
+	})
+}
+
+func TestClientFromDataSource_Headers(t *testing.T) {
+	c, err := clientFromDataSource(&models.DatasourceInfo{
+		URL:      "http://localhost:12345",
+		Token:    "secret",
+		Metadata: []map[string]string{{"database": "db", "x-proxy": "metadata"}},
+		Headers:  map[string]string{"X-Proxy": "header", "Authorization": "Basic abc"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+
+	assert.Equal(t, []string{"db"}, c.md.Get("database"))
+	assert.Equal(t, []string{"header"}, c.md.Get("x-proxy"))
+	assert.Equal(t, []string{"Bearer secret"}, c.md.Get("authorization"))
+}
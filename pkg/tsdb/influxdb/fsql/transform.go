@@ -0,0 +1,190 @@
+package fsql
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// transformResponse applies the per-query options of qm to the frames of a
+// converted response.
+func transformResponse(resp *backend.DataResponse, qm *queryModel) {
+	for _, frame := range resp.Frames {
+		shiftTimeFields(frame, qm.TimeShift)
+		fillNulls(frame, qm.NullHandling)
+		if qm.Instant && qm.Format == sqlutil.FormatOptionTimeSeries {
+			reduceFrame(frame, qm.Reducer)
+		}
+		if qm.Format == sqlutil.FormatOptionTable {
+			hideColumns(frame, qm)
+		}
+		if qm.InferUnits {
+			inferUnits(frame)
+		}
+	}
+}
+
+// shiftTimeFields shifts the values of the time fields of the frame forward
+// by the offset of the time shift, back into the time range of the panel.
+func shiftTimeFields(frame *data.Frame, shift *timeShift) {
+	if shift == nil {
+		return
+	}
+	for _, f := range frame.Fields {
+		if f.Type().NonNullableType() != data.FieldTypeTime {
+			continue
+		}
+		for i := 0; i < f.Len(); i++ {
+			switch v := f.At(i).(type) {
+			case time.Time:
+				f.Set(i, shift.add(v, 1))
+			case *time.Time:
+				if v != nil {
+					shifted := shift.add(*v, 1)
+					f.Set(i, &shifted)
+				}
+			}
+		}
+	}
+}
+
+const (
+	// nullAsZero replaces nulls in numeric fields with zero.
+	nullAsZero = "zero"
+	// nullAsPrevious replaces nulls in numeric fields with the previous
+	// non-null value of the field. Leading nulls are kept.
+	nullAsPrevious = "previous"
+)
+
+// fillNulls replaces the nulls of the nullable numeric fields of the frame
+// according to mode, so alert expressions don't break on sparse series.
+func fillNulls(frame *data.Frame, mode string) {
+	if mode != nullAsZero && mode != nullAsPrevious {
+		return
+	}
+
+	for _, f := range frame.Fields {
+		if !f.Nullable() || !f.Type().Numeric() {
+			continue
+		}
+
+		var fill any
+		if mode == nullAsZero {
+			fill = data.NewFieldFromFieldType(f.Type().NonNullableType(), 1).At(0)
+		}
+		for i := 0; i < f.Len(); i++ {
+			v, ok := f.ConcreteAt(i)
+			if ok {
+				if mode == nullAsPrevious {
+					fill = v
+				}
+				continue
+			}
+			if fill != nil {
+				f.SetConcrete(i, fill)
+			}
+		}
+	}
+}
+
+// hideColumns drops the columns hidden by qm from a table frame: the time
+// column when HideTime is set and the columns named in HideColumns. Hidden
+// columns can still be used by the SQL to filter and sort rows while being
+// left out of table panels.
+func hideColumns(frame *data.Frame, qm *queryModel) {
+	if !qm.HideTime && len(qm.HideColumns) == 0 {
+		return
+	}
+
+	timeIdx := -1
+	if qm.HideTime {
+		timeIdx = findTimeField(frame, qm.timeColumns())
+	}
+
+	hidden := toSet(qm.HideColumns)
+	fields := frame.Fields[:0]
+	for i, f := range frame.Fields {
+		if i == timeIdx || hidden[f.Name] {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	frame.Fields = fields
+}
+
+// sortByTime sorts the rows of the frame by ascending values of the time
+// field at index timeIdx, unless they already are. Null times sort last.
+// It reports whether the frame had to be sorted.
+func sortByTime(frame *data.Frame, timeIdx int) bool {
+	timeField := frame.Fields[timeIdx]
+	n := timeField.Len()
+
+	timeAt := func(i int) (time.Time, bool) {
+		v, ok := timeField.ConcreteAt(i)
+		if !ok {
+			return time.Time{}, false
+		}
+		t, ok := v.(time.Time)
+		return t, ok
+	}
+	less := func(i, j int) bool {
+		ti, okI := timeAt(i)
+		tj, okJ := timeAt(j)
+		if !okI || !okJ {
+			return okI && !okJ
+		}
+		return ti.Before(tj)
+	}
+
+	sorted := true
+	for i := 1; i < n; i++ {
+		if less(i, i-1) {
+			sorted = false
+			break
+		}
+	}
+	if sorted {
+		return false
+	}
+
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.SliceStable(perm, func(a, b int) bool {
+		return less(perm[a], perm[b])
+	})
+
+	for i, f := range frame.Fields {
+		frame.Fields[i] = permuteField(f, perm)
+	}
+	return true
+}
+
+// permuteField returns a copy of the field whose row i is row perm[i] of f.
+func permuteField(f *data.Field, perm []int) *data.Field {
+	out := data.NewFieldFromFieldType(f.Type(), len(perm))
+	out.Name = f.Name
+	out.Labels = f.Labels
+	out.Config = f.Config
+	for i, j := range perm {
+		out.Set(i, f.CopyAt(j))
+	}
+	return out
+}
+
+// setCustomMeta sets key in the custom meta of the frame.
+func setCustomMeta(frame *data.Frame, key string, value any) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	custom, ok := frame.Meta.Custom.(map[string]any)
+	if !ok {
+		custom = map[string]any{}
+		frame.Meta.Custom = custom
+	}
+	custom[key] = value
+}
@@ -0,0 +1,155 @@
+package fsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFillNulls(t *testing.T) {
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("value", nil, []*float64{nil, ptr(1.5), nil, ptr(2.5), nil}),
+			data.NewField("host", nil, []*string{nil, ptr("a"), nil, ptr("b"), nil}),
+		)
+	}
+
+	t.Run("zero", func(t *testing.T) {
+		frame := newFrame()
+		fillNulls(frame, nullAsZero)
+		require.Equal(t, []*float64{ptr(0.0), ptr(1.5), ptr(0.0), ptr(2.5), ptr(0.0)}, fieldValues[*float64](frame.Fields[0]))
+		require.Equal(t, []*string{nil, ptr("a"), nil, ptr("b"), nil}, fieldValues[*string](frame.Fields[1]))
+	})
+
+	t.Run("previous", func(t *testing.T) {
+		frame := newFrame()
+		fillNulls(frame, nullAsPrevious)
+		require.Equal(t, []*float64{nil, ptr(1.5), ptr(1.5), ptr(2.5), ptr(2.5)}, fieldValues[*float64](frame.Fields[0]))
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		frame := newFrame()
+		fillNulls(frame, "")
+		require.Equal(t, []*float64{nil, ptr(1.5), nil, ptr(2.5), nil}, fieldValues[*float64](frame.Fields[0]))
+	})
+}
+
+func TestShiftTimeFields(t *testing.T) {
+	t0 := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	query := &sqlutil.Query{
+		RawSQL:    "select * from cpu where $__timeFilter(time) $__timeShift(1d)",
+		TimeRange: backend.TimeRange{From: t0, To: t0.Add(time.Hour)},
+	}
+	shift, err := applyTimeShift(query)
+	require.NoError(t, err)
+	require.Equal(t, t0.AddDate(0, 0, -1), query.TimeRange.From)
+
+	// The results of the shifted range are shifted back into the range of
+	// the panel.
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{t0.AddDate(0, 0, -1), t0.AddDate(0, 0, -1).Add(time.Minute)}),
+		data.NewField("created", nil, []*time.Time{nil, ptr(t0.AddDate(0, 0, -1))}),
+		data.NewField("value", nil, []float64{1, 2}),
+	)
+	shiftTimeFields(frame, shift)
+	require.Equal(t, []time.Time{t0, t0.Add(time.Minute)}, fieldValues[time.Time](frame.Fields[0]))
+	require.Equal(t, []*time.Time{nil, ptr(t0)}, fieldValues[*time.Time](frame.Fields[1]))
+	require.Equal(t, []float64{1, 2}, fieldValues[float64](frame.Fields[2]))
+}
+
+func TestReduceFrame(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("time", nil, []time.Time{t0, t0.Add(time.Minute), t0.Add(2 * time.Minute)}),
+			data.NewField("usage", data.Labels{"host": "a"}, []*float64{ptr(1.0), ptr(5.0), nil}),
+			data.NewField("count", data.Labels{"host": "a"}, []int64{1, 2, 3}),
+			data.NewField("state", nil, []string{"ok", "ok", "alerting"}),
+		)
+	}
+
+	t.Run("last", func(t *testing.T) {
+		frame := newFrame()
+		reduceFrame(frame, reduceLast)
+		require.Equal(t, 1, frame.Rows())
+		require.Equal(t, []time.Time{t0.Add(2 * time.Minute)}, fieldValues[time.Time](frame.Fields[0]))
+		require.Equal(t, []*float64{ptr(5.0)}, fieldValues[*float64](frame.Fields[1]))
+		require.Equal(t, data.Labels{"host": "a"}, frame.Fields[1].Labels)
+		require.Equal(t, []*float64{ptr(3.0)}, fieldValues[*float64](frame.Fields[2]))
+		require.Equal(t, []string{"alerting"}, fieldValues[string](frame.Fields[3]))
+	})
+
+	t.Run("mean", func(t *testing.T) {
+		frame := newFrame()
+		reduceFrame(frame, reduceMean)
+		require.Equal(t, []*float64{ptr(3.0)}, fieldValues[*float64](frame.Fields[1]))
+		require.Equal(t, []*float64{ptr(2.0)}, fieldValues[*float64](frame.Fields[2]))
+	})
+
+	t.Run("only nulls", func(t *testing.T) {
+		frame := data.NewFrame("",
+			data.NewField("time", nil, []time.Time{t0}),
+			data.NewField("usage", nil, []*float64{nil}),
+		)
+		reduceFrame(frame, reduceLast)
+		require.Equal(t, []*float64{nil}, fieldValues[*float64](frame.Fields[1]))
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func fieldValues[T any](f *data.Field) []T {
+	values := make([]T, f.Len())
+	for i := range values {
+		values[i] = f.At(i).(T)
+	}
+	return values
+}
+
+func TestHideColumns(t *testing.T) {
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("Time", nil, []time.Time{time.Unix(0, 0)}),
+			data.NewField("host", nil, []string{"a"}),
+			data.NewField("region", nil, []string{"eu"}),
+			data.NewField("value", nil, []float64{1.5}),
+		)
+	}
+	names := func(frame *data.Frame) []string {
+		var out []string
+		for _, f := range frame.Fields {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+
+	t.Run("time", func(t *testing.T) {
+		frame := newFrame()
+		hideColumns(frame, &queryModel{HideTime: true})
+		require.Equal(t, []string{"host", "region", "value"}, names(frame))
+	})
+
+	t.Run("configured time column", func(t *testing.T) {
+		frame := newFrame()
+		hideColumns(frame, &queryModel{HideTime: true, TimeColumns: []string{"region"}})
+		require.Equal(t, []string{"Time", "host", "value"}, names(frame))
+	})
+
+	t.Run("columns", func(t *testing.T) {
+		frame := newFrame()
+		hideColumns(frame, &queryModel{HideTime: true, HideColumns: []string{"region", "missing"}})
+		require.Equal(t, []string{"host", "value"}, names(frame))
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		frame := newFrame()
+		hideColumns(frame, &queryModel{})
+		require.Equal(t, []string{"Time", "host", "region", "value"}, names(frame))
+	})
+}
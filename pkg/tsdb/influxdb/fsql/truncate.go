@@ -0,0 +1,81 @@
+package fsql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ellipsis marks the end of truncated strings.
+const ellipsis = "…"
+
+// truncateStrings cuts the values of the string fields of the frame longer
+// than maxLen characters, appending an ellipsis, and reports the fields
+// truncated in a notice. Huge values, such as JSON documents stored in a
+// column, would otherwise bloat the response and freeze the browser
+// rendering it. Labels are kept whole. A maxLen of zero or less keeps the
+// values whole.
+func truncateStrings(frame *data.Frame, maxLen int) {
+	if maxLen <= 0 {
+		return
+	}
+
+	var truncated []string
+	for _, f := range frame.Fields {
+		if f.Type().NonNullableType() != data.FieldTypeString {
+			continue
+		}
+
+		n := 0
+		for i := 0; i < f.Len(); i++ {
+			v, ok := f.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			s, ok := truncateString(v.(string), maxLen)
+			if !ok {
+				continue
+			}
+			if f.Nullable() {
+				f.Set(i, &s)
+			} else {
+				f.Set(i, s)
+			}
+			n++
+		}
+		if n > 0 {
+			name := f.Name
+			if len(f.Labels) > 0 {
+				name += " " + f.Labels.String()
+			}
+			truncated = append(truncated, fmt.Sprintf("%s (%d)", name, n))
+		}
+	}
+
+	if len(truncated) > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Values longer than %d characters have been truncated in fields: %s",
+				maxLen, strings.Join(truncated, ", ")),
+		})
+	}
+}
+
+// truncateString returns s cut to maxLen characters followed by an ellipsis,
+// and whether s was longer. The result doesn't share memory with s, so the
+// whole value can be freed.
+func truncateString(s string, maxLen int) (string, bool) {
+	if len(s) <= maxLen || utf8.RuneCountInString(s) <= maxLen {
+		return s, false
+	}
+	end, chars := 0, 0
+	for end = range s {
+		if chars == maxLen {
+			break
+		}
+		chars++
+	}
+	return s[:end] + ellipsis, true
+}
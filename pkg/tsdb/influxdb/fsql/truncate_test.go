@@ -0,0 +1,95 @@
+package fsql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTruncateString(t *testing.T) {
+	for _, tc := range []struct {
+		s, want   string
+		truncated bool
+	}{
+		{s: "", want: ""},
+		{s: "abcd", want: "abcd"},
+		{s: "abcde", want: "abcd…", truncated: true},
+		{s: "héllo wörld", want: "héll…", truncated: true},
+		{s: "ééé", want: "ééé"},
+	} {
+		got, truncated := truncateString(tc.s, 4)
+		assert.Equal(t, tc.want, got, tc.s)
+		assert.Equal(t, tc.truncated, truncated, tc.s)
+	}
+}
+
+func TestTruncateStrings(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	frame := data.NewFrame("",
+		data.NewField("short", nil, []*string{ptr("a"), nil}),
+		data.NewField("long", nil, []*string{ptr(long), nil, ptr("b")}),
+		data.NewField("plain", nil, []string{long}),
+		data.NewField("number", nil, []*int64{ptr[int64](1)}),
+	)
+
+	truncateStrings(frame, 10)
+	assert.Equal(t, []*string{ptr("a"), nil}, fieldValues[*string](frame.Fields[0]))
+	assert.Equal(t, []*string{ptr("xxxxxxxxxx…"), nil, ptr("b")}, fieldValues[*string](frame.Fields[1]))
+	assert.Equal(t, []string{"xxxxxxxxxx…"}, fieldValues[string](frame.Fields[2]))
+	require.Len(t, frame.Meta.Notices, 1)
+	assert.Equal(t, "Values longer than 10 characters have been truncated in fields: long (1), plain (1)", frame.Meta.Notices[0].Text)
+
+	t.Run("unlimited", func(t *testing.T) {
+		frame := data.NewFrame("", data.NewField("long", nil, []string{long}))
+		truncateStrings(frame, 0)
+		assert.Equal(t, []string{long}, fieldValues[string](frame.Fields[0]))
+		assert.Nil(t, frame.Meta)
+	})
+}
+
+func TestNewQueryDataResponse_TruncateStrings(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+			{Name: "host", Type: arrow.BinaryTypes.String},
+			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	response := func(format sqlutil.FormatQueryOption) backend.DataResponse {
+		reader := newTestRecordReader(t, schema,
+			`["2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z"]`,
+			`["`+long+`", "b"]`,
+			`[1, 2]`,
+		)
+		query := sqlutil.Query{Format: format}
+		resp := newQueryDataResponse(errReader{RecordReader: reader}, &queryModel{Query: &query, MaxStringLength: 10}, metadata.MD{})
+		require.NoError(t, resp.Error)
+		return resp
+	}
+
+	t.Run("labels are kept whole", func(t *testing.T) {
+		frame := response(sqlutil.FormatOptionTimeSeries).Frames[0]
+		var hosts []string
+		for _, f := range frame.Fields[1:] {
+			hosts = append(hosts, f.Labels["host"])
+		}
+		assert.ElementsMatch(t, []string{long, "b"}, hosts)
+		assert.Empty(t, frame.Meta.Notices)
+	})
+
+	t.Run("values are truncated", func(t *testing.T) {
+		frame := response(sqlutil.FormatOptionTable).Frames[0]
+		assert.Equal(t, []*string{ptr("xxxxxxxxxx…"), ptr("b")}, fieldValues[*string](frame.Fields[1]))
+		require.Len(t, frame.Meta.Notices, 1)
+		assert.Equal(t, "Values longer than 10 characters have been truncated in fields: host (1)", frame.Meta.Notices[0].Text)
+	})
+}
@@ -0,0 +1,40 @@
+package fsql
+
+import (
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// unitSuffixes maps the suffixes of column names to the units of their
+// values, as known to Grafana.
+var unitSuffixes = []struct {
+	suffix string
+	unit   string
+}{
+	{suffix: "_bytes", unit: "bytes"},
+	{suffix: "_ms", unit: "ms"},
+	{suffix: "_pct", unit: "percent"},
+	{suffix: "_count", unit: "short"},
+}
+
+// inferUnits sets the unit of the numeric fields of the frame whose names end
+// with one of unitSuffixes, ignoring case. Units already set are kept.
+func inferUnits(frame *data.Frame) {
+	for _, f := range frame.Fields {
+		if !f.Type().Numeric() || (f.Config != nil && f.Config.Unit != "") {
+			continue
+		}
+		name := strings.ToLower(f.Name)
+		for _, s := range unitSuffixes {
+			if !strings.HasSuffix(name, s.suffix) {
+				continue
+			}
+			if f.Config == nil {
+				f.Config = &data.FieldConfig{}
+			}
+			f.Config.Unit = s.unit
+			break
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferUnits(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("rx_bytes", nil, []float64{1}),
+		data.NewField("Latency_MS", nil, []int64{1}),
+		data.NewField("cpu_pct", nil, []*float64{nil}),
+		data.NewField("request_count", nil, []uint64{1}),
+		data.NewField("heap_bytes", nil, []float64{1}).SetConfig(&data.FieldConfig{Unit: "decbytes"}),
+		data.NewField("host_count", nil, []string{"a"}),
+		data.NewField("value", nil, []float64{1}),
+	)
+
+	inferUnits(frame)
+
+	units := make([]string, len(frame.Fields))
+	for i, f := range frame.Fields {
+		if f.Config != nil {
+			units[i] = f.Config.Unit
+		}
+	}
+	require.Equal(t, []string{"bytes", "ms", "percent", "short", "decbytes", "", ""}, units)
+}
@@ -0,0 +1,322 @@
+package fsql
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+)
+
+// maxNestingDepth is the maximum depth of the nested types of the columns
+// of the results, beyond which records are refused rather than walked.
+const maxNestingDepth = 32
+
+// validateRecords returns a reader validating each record of reader before
+// it is read. The Arrow IPC reader doesn't check the buffers it decodes
+// against the lengths and offsets of the arrays, so malformed records sent by
+// a misbehaving server must be refused before the conversion reads out of
+// range.
+func validateRecords(reader recordReader) recordReader {
+	return &validatingReader{recordReader: reader}
+}
+
+// validatingReader is a [recordReader] stopping at the first invalid record.
+type validatingReader struct {
+	recordReader
+	err error
+}
+
+func (r *validatingReader) Next() bool {
+	if r.err != nil || !r.recordReader.Next() {
+		return false
+	}
+	if err := validateRecord(r.recordReader.Record()); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+func (r *validatingReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.recordReader.Err()
+}
+
+// validateRecord checks that the columns of the record match its schema and
+// that their buffers hold the values they claim to.
+func validateRecord(record arrow.Record) error {
+	schema := record.Schema()
+	if int(record.NumCols()) != len(schema.Fields()) {
+		return fmt.Errorf("invalid record: %d columns for %d fields", record.NumCols(), len(schema.Fields()))
+	}
+	for i, col := range record.Columns() {
+		field := schema.Field(i)
+		if !arrow.TypeEqual(col.DataType(), field.Type) {
+			return fmt.Errorf("invalid record: column %q is %s, expected %s", field.Name, col.DataType(), field.Type)
+		}
+		if int64(col.Len()) != record.NumRows() {
+			return fmt.Errorf("invalid record: column %q has %d rows, expected %d", field.Name, col.Len(), record.NumRows())
+		}
+		if err := validateData(col.Data(), 0); err != nil {
+			return fmt.Errorf("invalid record: column %q: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateData checks the buffers and children of the array data, at the
+// given nesting depth.
+func validateData(data arrow.ArrayData, depth int) error {
+	if depth > maxNestingDepth {
+		return fmt.Errorf("nested deeper than %d levels", maxNestingDepth)
+	}
+
+	dt := data.DataType()
+	offset, length := data.Offset(), data.Len()
+	if offset < 0 || length < 0 {
+		return fmt.Errorf("negative offset %d or length %d", offset, length)
+	}
+	end := offset + length
+	if end < offset {
+		return errors.New("offset and length overflow")
+	}
+
+	buffers := data.Buffers()
+	specs := dt.Layout().Buffers
+	// Unions have no validity bitmap in their layout but have a nil one in
+	// their buffers.
+	first := 0
+	if dt.ID() == arrow.SPARSE_UNION || dt.ID() == arrow.DENSE_UNION {
+		first = 1
+	}
+	if len(buffers) < first+len(specs) {
+		return fmt.Errorf("%d buffers, expected %d", len(buffers), first+len(specs))
+	}
+	for i, spec := range specs {
+		buf := buffers[first+i]
+		if length == 0 || (buf == nil && (spec.Kind == arrow.KindBitmap || spec.Kind == arrow.KindAlwaysNull)) {
+			continue
+		}
+		var need int
+		switch spec.Kind {
+		case arrow.KindBitmap:
+			need = (end + 7) / 8
+		case arrow.KindFixedWidth:
+			need = spec.ByteWidth * end
+			if isOffsetsBuffer(dt, i) {
+				need = spec.ByteWidth * (end + 1)
+			}
+		default:
+			continue
+		}
+		if bufLen(buf) < need {
+			return fmt.Errorf("buffer %d of %s holds %d bytes, expected at least %d", first+i, dt, bufLen(buf), need)
+		}
+	}
+	if data.NullN() > length {
+		return fmt.Errorf("%d nulls for %d values", data.NullN(), length)
+	}
+	children := data.Children()
+	if length == 0 {
+		// The arrays of the children are made even when there are no values
+		// referencing them.
+		for _, child := range children {
+			if err := validateData(child, depth+1); err != nil {
+				return err
+			}
+		}
+		if dict := dictionary(data); dict != nil {
+			return validateData(dict, depth+1)
+		}
+		return nil
+	}
+
+	switch dt := dt.(type) {
+	case *arrow.StringType, *arrow.BinaryType:
+		return checkOffsets(arrow.Int32Traits.CastFromBytes(buffers[1].Bytes()), offset, length, int64(bufLen(buffers[2])))
+	case *arrow.LargeStringType, *arrow.LargeBinaryType:
+		return checkOffsets(arrow.Int64Traits.CastFromBytes(buffers[1].Bytes()), offset, length, int64(bufLen(buffers[2])))
+	case *arrow.ListType, *arrow.MapType:
+		if len(children) != 1 {
+			return fmt.Errorf("%d children, expected 1", len(children))
+		}
+		if err := checkOffsets(arrow.Int32Traits.CastFromBytes(buffers[1].Bytes()), offset, length, int64(children[0].Len())); err != nil {
+			return err
+		}
+		return validateData(children[0], depth+1)
+	case *arrow.LargeListType:
+		if len(children) != 1 {
+			return fmt.Errorf("%d children, expected 1", len(children))
+		}
+		if err := checkOffsets(arrow.Int64Traits.CastFromBytes(buffers[1].Bytes()), offset, length, int64(children[0].Len())); err != nil {
+			return err
+		}
+		return validateData(children[0], depth+1)
+	case *arrow.FixedSizeListType:
+		if len(children) != 1 {
+			return fmt.Errorf("%d children, expected 1", len(children))
+		}
+		if children[0].Len() < end*int(dt.Len()) {
+			return fmt.Errorf("%d child values, expected at least %d", children[0].Len(), end*int(dt.Len()))
+		}
+		return validateData(children[0], depth+1)
+	case *arrow.StructType:
+		if len(children) != len(dt.Fields()) {
+			return fmt.Errorf("%d children, expected %d", len(children), len(dt.Fields()))
+		}
+		for _, child := range children {
+			if child.Len() < end {
+				return fmt.Errorf("%d child values, expected at least %d", child.Len(), end)
+			}
+			if err := validateData(child, depth+1); err != nil {
+				return err
+			}
+		}
+	case arrow.UnionType:
+		return validateUnion(dt, buffers, children, offset, length, depth)
+	case *arrow.DictionaryType:
+		dict := dictionary(data)
+		if dict == nil {
+			return errors.New("missing dictionary")
+		}
+		if err := validateData(dict, depth+1); err != nil {
+			return err
+		}
+		return checkIndices(dt, data, int64(dict.Len()))
+	}
+	return nil
+}
+
+// isOffsetsBuffer reports whether the buffer of the layout of dt at index i
+// holds offsets, which have one more value than the array.
+func isOffsetsBuffer(dt arrow.DataType, i int) bool {
+	switch dt.ID() {
+	case arrow.STRING, arrow.BINARY, arrow.LARGE_STRING, arrow.LARGE_BINARY, arrow.LIST, arrow.LARGE_LIST, arrow.MAP:
+		return i == 1
+	}
+	return false
+}
+
+// checkOffsets checks that the offsets of the values of an array are
+// increasing and within the size of the values.
+func checkOffsets[T int32 | int64](offsets []T, offset, length int, size int64) error {
+	if len(offsets) < offset+length+1 {
+		return fmt.Errorf("%d offsets, expected at least %d", len(offsets), offset+length+1)
+	}
+	prev := int64(offsets[offset])
+	if prev < 0 {
+		return fmt.Errorf("negative offset %d", prev)
+	}
+	for _, o := range offsets[offset+1 : offset+length+1] {
+		if int64(o) < prev {
+			return fmt.Errorf("decreasing offsets %d and %d", prev, o)
+		}
+		prev = int64(o)
+	}
+	if prev > size {
+		return fmt.Errorf("offset %d beyond the %d values", prev, size)
+	}
+	return nil
+}
+
+// validateUnion checks the type codes, and offsets of dense unions, of the
+// values of a union array.
+func validateUnion(dt arrow.UnionType, buffers []*memory.Buffer, children []arrow.ArrayData, offset, length, depth int) error {
+	if len(children) != len(dt.Fields()) {
+		return fmt.Errorf("%d children, expected %d", len(children), len(dt.Fields()))
+	}
+	childIDs := dt.ChildIDs()
+	codes := arrow.Int8Traits.CastFromBytes(buffers[1].Bytes())[offset : offset+length]
+	var offsets []int32
+	if dt.Mode() == arrow.DenseMode {
+		offsets = arrow.Int32Traits.CastFromBytes(buffers[2].Bytes())[offset : offset+length]
+	}
+	for i, code := range codes {
+		if code < 0 || int(code) >= len(childIDs) || childIDs[code] == arrow.InvalidUnionChildID {
+			return fmt.Errorf("invalid union type code %d", code)
+		}
+		child := children[childIDs[code]]
+		if offsets == nil {
+			if child.Len() < offset+length {
+				return fmt.Errorf("%d child values, expected at least %d", child.Len(), offset+length)
+			}
+			continue
+		}
+		if offsets[i] < 0 || int(offsets[i]) >= child.Len() {
+			return fmt.Errorf("union offset %d beyond the %d child values", offsets[i], child.Len())
+		}
+	}
+	for _, child := range children {
+		if err := validateData(child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIndices checks that the indices of a dictionary array are within the
+// size of the dictionary.
+func checkIndices(dt *arrow.DictionaryType, data arrow.ArrayData, size int64) error {
+	buf := data.Buffers()[1].Bytes()
+	offset, length := data.Offset(), data.Len()
+	var index func(i int) int64
+	switch dt.IndexType.ID() {
+	case arrow.INT8:
+		v := arrow.Int8Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.UINT8:
+		v := arrow.Uint8Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.INT16:
+		v := arrow.Int16Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.UINT16:
+		v := arrow.Uint16Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.INT32:
+		v := arrow.Int32Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.UINT32:
+		v := arrow.Uint32Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return int64(v[i]) }
+	case arrow.INT64:
+		v := arrow.Int64Traits.CastFromBytes(buf)
+		index = func(i int) int64 { return v[i] }
+	default:
+		return fmt.Errorf("unsupported dictionary index type %s", dt.IndexType)
+	}
+
+	var validity []byte
+	if b := data.Buffers()[0]; b != nil {
+		validity = b.Bytes()
+	}
+	for i := offset; i < offset+length; i++ {
+		if validity != nil && validity[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if idx := index(i); idx < 0 || idx >= size {
+			return fmt.Errorf("dictionary index %d beyond the %d values", idx, size)
+		}
+	}
+	return nil
+}
+
+// dictionary returns the dictionary of the array data, or nil. The data
+// without a dictionary returns a nil *array.Data rather than a nil interface.
+func dictionary(data arrow.ArrayData) arrow.ArrayData {
+	if d, ok := data.Dictionary().(*array.Data); ok && d == nil {
+		return nil
+	}
+	return data.Dictionary()
+}
+
+func bufLen(buf *memory.Buffer) int {
+	if buf == nil {
+		return 0
+	}
+	return buf.Len()
+}
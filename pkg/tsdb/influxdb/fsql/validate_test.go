@@ -0,0 +1,209 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/apache/arrow/go/v13/arrow"
+	"github.com/apache/arrow/go/v13/arrow/array"
+	"github.com/apache/arrow/go/v13/arrow/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRecords(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+		{Name: "host", Type: &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int8, ValueType: arrow.BinaryTypes.String}},
+	}, nil)
+
+	t.Run("valid records", func(t *testing.T) {
+		reader := validateRecords(newTestRecordReader(t, schema, `["a", null, "c"]`, `[["x"], [], null]`, `["h1", "h2", "h1"]`))
+		require.True(t, reader.Next())
+		assert.EqualValues(t, 3, reader.Record().NumRows())
+		assert.False(t, reader.Next())
+		assert.NoError(t, reader.Err())
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		schema := arrow.NewSchema([]arrow.Field{{Name: "name", Type: arrow.BinaryTypes.String}}, nil)
+		name := array.NewStringData(array.NewData(arrow.BinaryTypes.String, 2, []*memory.Buffer{
+			nil,
+			memory.NewBufferBytes(arrow.Int32Traits.CastToBytes([]int32{0, 2, 1})),
+			memory.NewBufferBytes([]byte("ab")),
+		}, nil, 0, 0))
+		record := array.NewRecord(schema, []arrow.Array{name}, -1)
+		reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+		require.NoError(t, err)
+
+		validating := validateRecords(reader)
+		assert.False(t, validating.Next())
+		assert.EqualError(t, validating.Err(), `invalid record: column "name": decreasing offsets 2 and 1`)
+		assert.False(t, validating.Next())
+	})
+}
+
+func TestValidateData(t *testing.T) {
+	offsets := func(v ...int32) *memory.Buffer {
+		return memory.NewBufferBytes(arrow.Int32Traits.CastToBytes(v))
+	}
+	ints := func(v ...int32) arrow.ArrayData {
+		return array.NewData(arrow.PrimitiveTypes.Int32, len(v), []*memory.Buffer{nil, offsets(v...)}, nil, 0, 0)
+	}
+
+	cs := []struct {
+		name string
+		data arrow.ArrayData
+		err  string
+	}{
+		{
+			name: "valid string",
+			data: array.NewData(arrow.BinaryTypes.String, 2, []*memory.Buffer{nil, offsets(0, 1, 3), memory.NewBufferBytes([]byte("abc"))}, nil, 0, 0),
+		},
+		{
+			name: "sliced string",
+			data: array.NewData(arrow.BinaryTypes.String, 1, []*memory.Buffer{nil, offsets(0, 1, 3), memory.NewBufferBytes([]byte("abc"))}, nil, 0, 1),
+		},
+		{
+			name: "string offsets beyond the values",
+			data: array.NewData(arrow.BinaryTypes.String, 2, []*memory.Buffer{nil, offsets(0, 1, 4), memory.NewBufferBytes([]byte("abc"))}, nil, 0, 0),
+			err:  "offset 4 beyond the 3 values",
+		},
+		{
+			name: "negative string offset",
+			data: array.NewData(arrow.BinaryTypes.String, 1, []*memory.Buffer{nil, offsets(-1, 1), memory.NewBufferBytes([]byte("abc"))}, nil, 0, 0),
+			err:  "negative offset -1",
+		},
+		{
+			name: "missing string offsets",
+			data: array.NewData(arrow.BinaryTypes.String, 3, []*memory.Buffer{nil, offsets(0, 1, 3), memory.NewBufferBytes([]byte("abc"))}, nil, 0, 0),
+			err:  "buffer 1 of utf8 holds 12 bytes, expected at least 16",
+		},
+		{
+			name: "short values",
+			data: array.NewData(arrow.PrimitiveTypes.Int64, 2, []*memory.Buffer{nil, memory.NewBufferBytes(make([]byte, 12))}, nil, 0, 0),
+			err:  "buffer 1 of int64 holds 12 bytes, expected at least 16",
+		},
+		{
+			name: "short validity bitmap",
+			data: array.NewData(arrow.PrimitiveTypes.Int8, 9, []*memory.Buffer{memory.NewBufferBytes([]byte{0xff}), memory.NewBufferBytes(make([]byte, 9))}, nil, 1, 0),
+			err:  "buffer 0 of int8 holds 1 bytes, expected at least 2",
+		},
+		{
+			name: "too many nulls",
+			data: array.NewData(arrow.PrimitiveTypes.Int8, 1, []*memory.Buffer{memory.NewBufferBytes([]byte{0}), memory.NewBufferBytes(make([]byte, 1))}, nil, 2, 0),
+			err:  "2 nulls for 1 values",
+		},
+		{
+			name: "negative length",
+			data: array.NewData(arrow.PrimitiveTypes.Int8, -1, []*memory.Buffer{nil, nil}, nil, 0, 0),
+			err:  "negative offset 0 or length -1",
+		},
+		{
+			name: "missing buffers",
+			data: array.NewData(arrow.PrimitiveTypes.Int8, 1, []*memory.Buffer{nil}, nil, 0, 0),
+			err:  "1 buffers, expected 2",
+		},
+		{
+			name: "list offsets beyond the child values",
+			data: array.NewData(arrow.ListOf(arrow.PrimitiveTypes.Int32), 2, []*memory.Buffer{nil, offsets(0, 1, 3)}, []arrow.ArrayData{ints(1, 2)}, 0, 0),
+			err:  "offset 3 beyond the 2 values",
+		},
+		{
+			name: "short struct child",
+			data: array.NewData(arrow.StructOf(arrow.Field{Name: "a", Type: arrow.PrimitiveTypes.Int32}), 2, []*memory.Buffer{nil}, []arrow.ArrayData{ints(1)}, 0, 0),
+			err:  "1 child values, expected at least 2",
+		},
+		{
+			name: "dictionary index beyond the dictionary",
+			data: array.NewDataWithDictionary(&arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.PrimitiveTypes.Int32}, 2, []*memory.Buffer{nil, offsets(0, 2)}, 0, 0, ints(7, 8).(*array.Data)),
+			err:  "dictionary index 2 beyond the 2 values",
+		},
+		{
+			name: "null dictionary index",
+			data: array.NewDataWithDictionary(&arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.PrimitiveTypes.Int32}, 2, []*memory.Buffer{memory.NewBufferBytes([]byte{0b01}), offsets(0, 2)}, 1, 0, ints(7, 8).(*array.Data)),
+		},
+		{
+			name: "invalid union type code",
+			data: array.NewData(arrow.SparseUnionOf([]arrow.Field{{Name: "a", Type: arrow.PrimitiveTypes.Int32}}, []arrow.UnionTypeCode{0}), 1, []*memory.Buffer{nil, memory.NewBufferBytes([]byte{3})}, []arrow.ArrayData{ints(1)}, 0, 0),
+			err:  "invalid union type code 3",
+		},
+		{
+			name: "dense union offset beyond the child values",
+			data: array.NewData(arrow.DenseUnionOf([]arrow.Field{{Name: "a", Type: arrow.PrimitiveTypes.Int32}}, []arrow.UnionTypeCode{0}), 1, []*memory.Buffer{nil, memory.NewBufferBytes([]byte{0}), offsets(1)}, []arrow.ArrayData{ints(1)}, 0, 0),
+			err:  "union offset 1 beyond the 1 child values",
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateData(c.data, 0)
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, c.err)
+		})
+	}
+
+	t.Run("nested deeper than the cap", func(t *testing.T) {
+		data := ints(1)
+		for i := 0; i <= maxNestingDepth; i++ {
+			data = array.NewData(arrow.ListOf(data.DataType()), 1, []*memory.Buffer{nil, offsets(0, 1)}, []arrow.ArrayData{data}, 0, 0)
+		}
+		assert.EqualError(t, validateData(data, 0), "nested deeper than 32 levels")
+	})
+}
+
+// FuzzValidateString checks that the string arrays passing validation can be
+// read without panicking.
+func FuzzValidateString(f *testing.F) {
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 1, 3}), []byte("abc"), []byte{0xff}, 2, 0)
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 3, 1}), []byte("abc"), []byte{}, 2, 0)
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 1, 3}), []byte("abc"), []byte{0x02}, 1, 1)
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{-4, 1}), []byte("abc"), []byte{}, 1, 0)
+
+	f.Fuzz(func(t *testing.T, offsets, values, validity []byte, length, offset int) {
+		var bitmap *memory.Buffer
+		if len(validity) > 0 {
+			bitmap = memory.NewBufferBytes(validity)
+		}
+		data := array.NewData(arrow.BinaryTypes.String, length, []*memory.Buffer{
+			bitmap, memory.NewBufferBytes(offsets), memory.NewBufferBytes(values),
+		}, nil, array.UnknownNullCount, offset)
+		if validateData(data, 0) != nil {
+			return
+		}
+
+		arr := array.NewStringData(data)
+		for i := 0; i < arr.Len(); i++ {
+			if arr.IsValid(i) {
+				_ = arr.Value(i)
+			}
+		}
+	})
+}
+
+// FuzzValidateList checks that the list arrays passing validation can be read
+// without panicking.
+func FuzzValidateList(f *testing.F) {
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 1, 3}), arrow.Int32Traits.CastToBytes([]int32{1, 2, 3}), 2, 3)
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 4}), arrow.Int32Traits.CastToBytes([]int32{1, 2, 3}), 1, 3)
+	f.Add(arrow.Int32Traits.CastToBytes([]int32{0, 1}), arrow.Int32Traits.CastToBytes([]int32{1}), 1, 2)
+
+	f.Fuzz(func(t *testing.T, offsets, values []byte, length, childLength int) {
+		child := array.NewData(arrow.PrimitiveTypes.Int32, childLength, []*memory.Buffer{nil, memory.NewBufferBytes(values)}, nil, 0, 0)
+		data := array.NewData(arrow.ListOf(arrow.PrimitiveTypes.Int32), length, []*memory.Buffer{nil, memory.NewBufferBytes(offsets)}, []arrow.ArrayData{child}, 0, 0)
+		if validateData(data, 0) != nil {
+			return
+		}
+
+		arr := array.NewListData(data)
+		elems := arr.ListValues().(*array.Int32)
+		for i := 0; i < arr.Len(); i++ {
+			start, end := arr.ValueOffsets(i)
+			for j := start; j < end; j++ {
+				_ = elems.Value(int(j))
+			}
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package fsql
+
+import (
+	"regexp"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// variableRegexp matches the references to dashboard variables in the
+// $name, ${name}, ${name:format} and [[name]] syntaxes.
+var variableRegexp = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::[^}]*)?\}|\[\[(\w+)\]\]`)
+
+// interpolateVariables replaces the references to the dashboard variables in
+// s with their values. References to unknown variables are left as is.
+func interpolateVariables(s string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return s
+	}
+	return variableRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		m := variableRegexp.FindStringSubmatch(ref)
+		for _, name := range m[1:] {
+			if name == "" {
+				continue
+			}
+			if v, ok := vars[name]; ok {
+				return v
+			}
+		}
+		return ref
+	})
+}
+
+// interpolateMetadata replaces the references to the dashboard variables in
+// the values of the call metadata selecting the database or bucket queried,
+// so one datasource can query the database chosen by a dashboard. Other
+// metadata, such as authorization headers, are sent as configured: a
+// dashboard variable must not be able to alter credentials.
+func interpolateMetadata(md metadata.MD, vars map[string]string) {
+	if len(vars) == 0 {
+		return
+	}
+	for _, k := range databaseMetadataKeys {
+		values := md.Get(k)
+		for i, v := range values {
+			values[i] = interpolateVariables(v, vars)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package fsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolateVariables(t *testing.T) {
+	vars := map[string]string{"env": "prod", "region": "eu"}
+	cs := []struct {
+		in       string
+		expected string
+	}{
+		{in: "$env", expected: "prod"},
+		{in: "${env}_metrics", expected: "prod_metrics"},
+		{in: "${env:raw}_metrics", expected: "prod_metrics"},
+		{in: "[[env]]-[[region]]", expected: "prod-eu"},
+		{in: "$env_$region", expected: "$env_eu"},
+		{in: "${unknown}_metrics", expected: "${unknown}_metrics"},
+		{in: "metrics", expected: "metrics"},
+	}
+	for _, c := range cs {
+		t.Run(c.in, func(t *testing.T) {
+			require.Equal(t, c.expected, interpolateVariables(c.in, vars))
+		})
+	}
+}
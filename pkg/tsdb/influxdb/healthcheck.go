@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -33,16 +34,31 @@ func (s *Service) CheckHealth(ctx context.Context, req *backend.CheckHealthReque
 		return getHealthCheckMessage(logger, "", errors.New("invalid datasource info received"))
 	}
 
+	if err := dsInfo.CheckLanguage(); err != nil {
+		return getHealthCheckMessage(logger, "", err)
+	}
+
+	if err := dsInfo.CheckAuthMode(); err != nil {
+		return getHealthCheckMessage(logger, "", err)
+	}
+
+	var res *backend.CheckHealthResult
 	switch dsInfo.Version {
 	case influxVersionFlux:
-		return CheckFluxHealth(ctx, dsInfo, req)
+		res, err = CheckFluxHealth(ctx, dsInfo, req)
 	case influxVersionInfluxQL:
-		return CheckInfluxQLHealth(ctx, dsInfo, s.features)
+		res, err = CheckInfluxQLHealth(ctx, dsInfo, s.features)
 	case influxVersionSQL:
-		return CheckSQLHealth(ctx, dsInfo, req)
+		res, err = CheckSQLHealth(ctx, dsInfo, req)
 	default:
 		return getHealthCheckMessage(logger, "", errors.New("unknown influx version"))
 	}
+	if err == nil && res.Status == backend.HealthStatusOk {
+		if product := dsInfo.ProductDescription(); product != "" {
+			res.Message = fmt.Sprintf("%s. %s", strings.TrimRight(res.Message, ". "), product)
+		}
+	}
+	return res, err
 }
 
 func CheckFluxHealth(ctx context.Context, dsInfo *models.DatasourceInfo,
@@ -142,9 +158,16 @@ func CheckSQLHealth(ctx context.Context, dsInfo *models.DatasourceInfo, req *bac
 		}, nil
 	}
 
+	message := "OK"
+	if conn, ok := dsInfo.FlightSQL.(*fsql.Connection); ok {
+		if st := conn.Status(); !st.ConnectedSince.IsZero() {
+			message = fmt.Sprintf("OK. Connected since %s", st.ConnectedSince.Format(time.RFC3339))
+		}
+	}
+
 	return &backend.CheckHealthResult{
 		Status:  backend.HealthStatusOk,
-		Message: "OK",
+		Message: message,
 	}, nil
 }
 
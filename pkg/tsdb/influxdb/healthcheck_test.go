@@ -6,6 +6,8 @@ import (
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
 )
 
 func Test_healthcheck(t *testing.T) {
@@ -47,4 +49,39 @@ func Test_healthcheck(t *testing.T) {
 		})
 		assert.Equal(t, backend.HealthStatusError, res.Status)
 	})
+	t.Run("should fail when the product doesn't support the query language", func(t *testing.T) {
+		s := GetMockService(influxVersionFlux, RoundTripper{})
+		s.im.(*fakeInstance).product = models.ProductCore3
+		res, err := s.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, backend.HealthStatusError, res.Status)
+		assert.Contains(t, res.Message, "InfluxDB 3 Core does not support Flux queries, supported query languages: SQL, InfluxQL")
+	})
+	t.Run("should fail when the product doesn't support the authentication mode", func(t *testing.T) {
+		s := GetMockService(influxVersionInfluxQL, RoundTripper{})
+		s.im.(*fakeInstance).product = models.ProductOSS1
+		s.im.(*fakeInstance).authMode = models.AuthModeToken
+		res, err := s.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, backend.HealthStatusError, res.Status)
+		assert.Contains(t, res.Message, "InfluxDB OSS 1.x does not support token authentication, supported authentication modes: basic, none")
+	})
+	t.Run("should describe the product", func(t *testing.T) {
+		s := GetMockService(influxVersionInfluxQL, RoundTripper{
+			Body: `{"results": [{"series": [{"columns": ["name"],"name": "measurements","values": [["cpu"]]}],"statement_id": 0}]}`,
+		})
+		s.im.(*fakeInstance).product = models.ProductCore3
+		s.im.(*fakeInstance).productVersion = "3.0.1"
+		s.im.(*fakeInstance).authMode = models.AuthModeToken
+		res, err := s.CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, backend.HealthStatusOk, res.Status)
+		assert.Equal(t, "datasource is working. 1 measurements found. InfluxDB 3 Core 3.0.1 (limits: 5 databases, 2000 tables, 500 columns per table)", res.Message)
+	})
 }
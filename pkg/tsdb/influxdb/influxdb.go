@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
@@ -25,13 +27,17 @@ var logger log.Logger = log.New("tsdb.influxdb")
 type Service struct {
 	im       instancemgmt.InstanceManager
 	features featuremgmt.FeatureToggles
+
+	resourceHandler backend.CallResourceHandler
 }
 
 func ProvideService(httpClient httpclient.Provider, features featuremgmt.FeatureToggles) *Service {
-	return &Service{
+	s := &Service{
 		im:       datasource.NewInstanceManager(newInstanceSettings(httpClient)),
 		features: features,
 	}
+	s.resourceHandler = httpadapter.New(s.newResourceMux())
+	return s
 }
 
 func newInstanceSettings(httpClientProvider httpclient.Provider) datasource.InstanceFactoryFunc {
@@ -73,29 +79,80 @@ func newInstanceSettings(httpClientProvider httpclient.Provider) datasource.Inst
 		}
 
 		model := &models.DatasourceInfo{
-			HTTPClient:    client,
-			URL:           settings.URL,
-			DbName:        database,
-			Version:       version,
-			HTTPMode:      httpMode,
-			TimeInterval:  jsonData.TimeInterval,
-			DefaultBucket: jsonData.DefaultBucket,
-			Organization:  jsonData.Organization,
-			Metadata:      jsonData.Metadata,
-			MaxSeries:     maxSeries,
-			SecureGrpc:    true,
-			Token:         settings.DecryptedSecureJSONData["token"],
+			HTTPClient:            client,
+			URL:                   settings.URL,
+			DbName:                database,
+			Version:               version,
+			Product:               jsonData.Product,
+			ProductVersion:        jsonData.ProductVersion,
+			HTTPMode:              httpMode,
+			TimeInterval:          jsonData.TimeInterval,
+			DefaultBucket:         jsonData.DefaultBucket,
+			Organization:          jsonData.Organization,
+			Metadata:              jsonData.Metadata,
+			MaxSeries:             maxSeries,
+			SecureGrpc:            true,
+			TLS:                   opts.TLS,
+			Headers:               opts.Headers,
+			AuthScheme:            jsonData.AuthScheme,
+			GrpcServiceConfig:     jsonData.GrpcServiceConfig,
+			TimeColumns:           jsonData.TimeColumns,
+			ValueColumns:          jsonData.ValueColumns,
+			MaxResultRows:         jsonData.MaxResultRows,
+			MaxResultBytes:        jsonData.MaxResultBytes,
+			AbortOversizedQueries: jsonData.AbortOversizedQueries,
+			BatchSize:             jsonData.BatchSize,
+			DataVersionSQL:        jsonData.DataVersionSQL,
+			InferUnits:            jsonData.InferUnits,
+			MaxConcurrentQueries:  jsonData.MaxConcurrentQueries,
+			AlertQueryTimeout:     jsonData.AlertQueryTimeout,
+			AlertMaxRows:          jsonData.AlertMaxRows,
+			AlertMaxRetries:       jsonData.AlertMaxRetries,
+			DefaultFormat:         jsonData.DefaultFormat,
+			MaxStringLength:       jsonData.MaxStringLength,
+			StreamFlushInterval:   jsonData.StreamFlushInterval,
+			StreamMaxBufferedRows: jsonData.StreamMaxBufferedRows,
+			Token:                 settings.DecryptedSecureJSONData["token"],
+		}
+		model.AuthMode = authMode(settings, model)
+
+		if version == influxVersionSQL {
+			conn, err := fsql.NewConnection(model)
+			if err != nil {
+				// Leave the connection unset; queries will dial on their own
+				// and surface the error to the user.
+				logger.Warn("Failed to create FlightSQL connection", "err", err)
+			} else {
+				model.FlightSQL = conn
+			}
 		}
+
 		return model, nil
 	}
 }
 
+// authMode returns the authentication mode of the datasource. Tokens are set
+// either as the token of the datasource, as an Authorization header or by a
+// credentials provider.
+func authMode(settings backend.DataSourceInstanceSettings, dsInfo *models.DatasourceInfo) string {
+	switch {
+	case settings.BasicAuthEnabled:
+		return models.AuthModeBasic
+	case dsInfo.Token != "" || dsInfo.AuthScheme != "":
+		return models.AuthModeToken
+	}
+	for name := range dsInfo.Headers {
+		if strings.EqualFold(name, "Authorization") {
+			return models.AuthModeToken
+		}
+	}
+	return models.AuthModeNone
+}
+
 func (s *Service) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	logger := logger.FromContext(ctx)
 	logger.Debug("Received a query request", "numQueries", len(req.Queries))
 
-	tracer := tracing.DefaultTracer()
-
 	dsInfo, err := s.getDSInfo(ctx, req.PluginContext)
 	if err != nil {
 		return nil, err
@@ -103,11 +160,67 @@ func (s *Service) QueryData(ctx context.Context, req *backend.QueryDataRequest)
 
 	logger.Debug(fmt.Sprintf("Making a %s type query", dsInfo.Version))
 
+	routed := backend.NewQueryDataResponse()
+	queries := map[string][]backend.DataQuery{}
+	for _, q := range req.Queries {
+		language, err := queryLanguage(q, dsInfo.Version)
+		if err == nil {
+			q, err = routeQuery(q, dsInfo.Version, language)
+		}
+		if err != nil {
+			routed.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("bad request: %s", err))
+			continue
+		}
+		queries[language] = append(queries[language], q)
+	}
+
+	// Queries all in the language of the datasource run together as usual.
+	if len(routed.Responses) == 0 && len(queries) <= 1 && (len(queries) == 0 || queries[dsInfo.Version] != nil) {
+		if err := dsInfo.CheckLanguage(); err != nil {
+			return nil, err
+		}
+		return s.query(ctx, dsInfo, req)
+	}
+
+	// Otherwise the queries of each language are run by its executor, so
+	// dashboards mixing languages keep working after the datasource is
+	// switched to another language.
+	for _, language := range []string{influxVersionFlux, influxVersionInfluxQL, influxVersionSQL} {
+		qs, ok := queries[language]
+		if !ok {
+			continue
+		}
+		logger.Debug("Routing queries", "language", language, "numQueries", len(qs))
+
+		err := dsInfo.CheckQueryLanguage(language)
+		if err == nil {
+			info := *dsInfo
+			info.Version = language
+			sub := *req
+			sub.Queries = qs
+			var resp *backend.QueryDataResponse
+			if resp, err = s.query(ctx, &info, &sub); err == nil {
+				for refID, r := range resp.Responses {
+					routed.Responses[refID] = r
+				}
+				continue
+			}
+		}
+		for _, q := range qs {
+			routed.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+	}
+	return routed, nil
+}
+
+// query runs the queries with the executor of the language of the
+// datasource.
+func (s *Service) query(ctx context.Context, dsInfo *models.DatasourceInfo, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	switch dsInfo.Version {
 	case influxVersionFlux:
 		return flux.Query(ctx, dsInfo, *req)
 	case influxVersionInfluxQL:
-		return influxql.Query(ctx, tracer, dsInfo, req, s.features)
+		return influxql.Query(ctx, tracing.DefaultTracer(), dsInfo, req, s.features)
 	case influxVersionSQL:
 		return fsql.Query(ctx, dsInfo, *req)
 	default:
@@ -115,6 +228,10 @@ func (s *Service) QueryData(ctx context.Context, req *backend.QueryDataRequest)
 	}
 }
 
+func (s *Service) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return s.resourceHandler.CallResource(ctx, req, sender)
+}
+
 func (s *Service) getDSInfo(ctx context.Context, pluginCtx backend.PluginContext) (*models.DatasourceInfo, error) {
 	i, err := s.im.Get(ctx, pluginCtx)
 	if err != nil {
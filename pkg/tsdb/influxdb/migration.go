@@ -0,0 +1,237 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// migrationReport is the result of scanning the queries of a dashboard
+// before switching the product or the language of the datasource.
+type migrationReport struct {
+	// Product is the name of the product of the datasource, if known.
+	Product string           `json:"product,omitempty"`
+	Queries []migrationQuery `json:"queries"`
+	// Dashboard is the dashboard with the rewritten queries, when asked.
+	Dashboard map[string]any `json:"dashboard,omitempty"`
+}
+
+// migrationQuery describes a query of the datasource in a dashboard.
+type migrationQuery struct {
+	PanelID    any    `json:"panelId,omitempty"`
+	PanelTitle string `json:"panelTitle,omitempty"`
+	RefID      string `json:"refId"`
+	Language   string `json:"language"`
+	// Supported is false when the product of the datasource is known not
+	// to support the language of the query.
+	Supported bool   `json:"supported"`
+	Error     string `json:"error,omitempty"`
+	// RewrittenQuery is the SQL equivalent of an InfluxQL query migrated to
+	// SQL, when it could be converted.
+	RewrittenQuery string `json:"rewrittenQuery,omitempty"`
+}
+
+// handleMigration scans the queries of the datasource in the dashboard
+// posted, reporting the language of each and whether the product of the
+// datasource supports it. Queries are migrated to the language parameter,
+// defaulting to the language of the datasource: InfluxQL queries migrated to
+// SQL are converted when they have a trivial equivalent. With the rewrite
+// parameter set to true, the converted queries are rewritten and the updated
+// dashboard is returned. The body is either a dashboard or the response of
+// the dashboard API, with the dashboard under "dashboard".
+func (s *Service) handleMigration(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeResourceError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	var dashboard map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&dashboard); err != nil {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("dashboard: %w", err))
+		return
+	}
+	if d, ok := dashboard["dashboard"].(map[string]any); ok {
+		dashboard = d
+	}
+
+	pluginCtx := httpadapter.PluginConfigFromContext(req.Context())
+	dsInfo, err := s.getDSInfo(req.Context(), pluginCtx)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	params := req.URL.Query()
+	language := dsInfo.Version
+	if v := params.Get("language"); v != "" {
+		language = v
+	}
+	rewrite := params.Get("rewrite") == "true"
+	report := scanDashboard(dashboard, dsInfo, pluginCtx.DataSourceInstanceSettings, language, rewrite)
+	if rewrite {
+		report.Dashboard = dashboard
+	}
+	writeResourceJSON(rw, report)
+}
+
+// scanDashboard reports the queries of the datasource in the panels of the
+// dashboard, including the panels of collapsed rows, migrated to language.
+// Queries are rewritten in place when rewrite is set.
+func scanDashboard(dashboard map[string]any, dsInfo *models.DatasourceInfo, settings *backend.DataSourceInstanceSettings, language string, rewrite bool) migrationReport {
+	report := migrationReport{Queries: []migrationQuery{}}
+	if c, ok := dsInfo.Capabilities(); ok {
+		report.Product = c.Name
+	}
+
+	var scanPanels func(panels []any)
+	scanPanels = func(panels []any) {
+		for _, p := range panels {
+			panel, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			if nested, ok := panel["panels"].([]any); ok {
+				scanPanels(nested)
+			}
+			targets, _ := panel["targets"].([]any)
+			for _, t := range targets {
+				target, ok := t.(map[string]any)
+				if !ok || !usesDatasource(target, panel, settings) {
+					continue
+				}
+				q := scanQuery(target, dsInfo, language, rewrite)
+				q.PanelID = panel["id"]
+				q.PanelTitle, _ = panel["title"].(string)
+				report.Queries = append(report.Queries, q)
+			}
+		}
+	}
+	panels, _ := dashboard["panels"].([]any)
+	scanPanels(panels)
+	return report
+}
+
+// usesDatasource reports whether the target of the panel queries the
+// datasource. Targets without a datasource query the one of their panel.
+// Without settings, all targets are scanned.
+func usesDatasource(target, panel map[string]any, settings *backend.DataSourceInstanceSettings) bool {
+	if settings == nil {
+		return true
+	}
+	ref, ok := target["datasource"]
+	if !ok || ref == nil {
+		ref = panel["datasource"]
+	}
+	switch ref := ref.(type) {
+	case map[string]any:
+		uid, _ := ref["uid"].(string)
+		return uid == settings.UID
+	case string:
+		// Dashboards of older versions reference datasources by name.
+		return ref == settings.Name || ref == settings.UID
+	default:
+		return false
+	}
+}
+
+// scanQuery reports the language of the target and whether it is supported.
+// InfluxQL targets migrated to SQL are converted, and rewritten when rewrite
+// is set.
+func scanQuery(target map[string]any, dsInfo *models.DatasourceInfo, language string, rewrite bool) migrationQuery {
+	q := migrationQuery{}
+	q.RefID, _ = target["refId"].(string)
+
+	b, err := json.Marshal(target)
+	if err != nil {
+		q.Error = err.Error()
+		return q
+	}
+	q.Language, err = queryLanguage(backend.DataQuery{JSON: b}, dsInfo.Version)
+	if err != nil {
+		q.Error = err.Error()
+		return q
+	}
+	q.Supported = dsInfo.CheckQueryLanguage(q.Language) == nil
+	if q.Language != models.LanguageInfluxQL || !strings.EqualFold(language, models.LanguageSQL) || dsInfo.CheckQueryLanguage(models.LanguageSQL) != nil {
+		return q
+	}
+
+	raw, _ := target["query"].(string)
+	if rawQuery, _ := target["rawQuery"].(bool); !rawQuery && dsInfo.Version == models.LanguageInfluxQL {
+		// Queries built by the query editor aren't converted.
+		return q
+	}
+	sql, ok := influxQLToSQL(raw)
+	if !ok {
+		return q
+	}
+	q.RewrittenQuery = sql
+	if rewrite {
+		target["rawSql"] = sql
+		target["language"] = models.LanguageSQL
+		if format, _ := target["resultFormat"].(string); format == "table" {
+			target["format"] = "table"
+		} else {
+			target["format"] = "time_series"
+		}
+	}
+	return q
+}
+
+// influxQLSelectPattern matches the InfluxQL queries with a SQL equivalent:
+// raw fields selected from a single measurement, optionally filtered on the
+// dashboard time range and on tag values, and limited.
+var influxQLSelectPattern = regexp.MustCompile(`(?is)^\s*SELECT\s+([^()]+?)\s+FROM\s+("[^"]+"|\w+)(?:\s+WHERE\s+(.+?))?(\s+LIMIT\s+\d+)?\s*;?\s*$`)
+
+// influxQLAndPattern separates the conditions of an InfluxQL query.
+var influxQLAndPattern = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+// influxQLConditionPattern matches the conditions with a SQL equivalent.
+var influxQLConditionPattern = regexp.MustCompile(`(?i)^(\$timeFilter|("[^"]+"|\w+)\s*(=|!=|<>)\s*'[^']*')$`)
+
+// influxQLToSQL converts the InfluxQL query to SQL. It reports false when the
+// query has no trivial SQL equivalent, such as queries using functions,
+// GROUP BY, regular expressions or relative times.
+func influxQLToSQL(query string) (string, bool) {
+	m := influxQLSelectPattern.FindStringSubmatch(query)
+	if m == nil {
+		return "", false
+	}
+	columns, measurement, where, limit := strings.TrimSpace(m[1]), m[2], m[3], m[4]
+	if strings.Contains(columns, "::") {
+		return "", false
+	}
+
+	var conditions []string
+	if where != "" {
+		for _, c := range influxQLAndPattern.Split(where, -1) {
+			c = strings.TrimSpace(c)
+			if !influxQLConditionPattern.MatchString(c) {
+				return "", false
+			}
+			if strings.EqualFold(c, "$timeFilter") {
+				c = "$__timeFilter(time)"
+			}
+			conditions = append(conditions, c)
+		}
+	}
+
+	// InfluxQL always returns the time column, SQL only when selected.
+	first := strings.Trim(strings.TrimSpace(strings.Split(columns, ",")[0]), `"`)
+	if columns != "*" && !strings.EqualFold(first, "time") {
+		columns = "time, " + columns
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", columns, measurement)
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sql += " ORDER BY time"
+	return sql + limit, true
+}
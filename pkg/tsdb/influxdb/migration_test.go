@@ -0,0 +1,117 @@
+package influxdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestInfluxQLToSQL(t *testing.T) {
+	cs := []struct {
+		query    string
+		expected string
+	}{
+		{query: `SELECT "usage" FROM "cpu" WHERE $timeFilter`, expected: `SELECT time, "usage" FROM "cpu" WHERE $__timeFilter(time) ORDER BY time`},
+		{query: `SELECT time, usage, idle FROM cpu WHERE host = 'a' AND $timeFilter LIMIT 10;`, expected: `SELECT time, usage, idle FROM cpu WHERE host = 'a' AND $__timeFilter(time) ORDER BY time LIMIT 10`},
+		{query: `SELECT * FROM cpu`, expected: `SELECT * FROM cpu ORDER BY time`},
+		{query: `SELECT mean("usage") FROM "cpu" WHERE $timeFilter GROUP BY time($__interval)`},
+		{query: `SELECT usage FROM cpu WHERE host =~ /a.*/`},
+		{query: `SELECT usage FROM cpu WHERE time > now() - 1h`},
+		{query: `SELECT usage::field FROM cpu`},
+		{query: `SHOW MEASUREMENTS`},
+	}
+	for _, c := range cs {
+		t.Run(c.query, func(t *testing.T) {
+			sql, ok := influxQLToSQL(c.query)
+			require.Equal(t, c.expected != "", ok)
+			require.Equal(t, c.expected, sql)
+		})
+	}
+}
+
+func TestMigrationResource(t *testing.T) {
+	dashboard := `{"dashboard": {"panels": [
+		{"id": 1, "title": "CPU", "datasource": {"uid": "influx"}, "targets": [
+			{"refId": "A", "rawQuery": true, "query": "SELECT \"usage\" FROM \"cpu\" WHERE $timeFilter", "resultFormat": "time_series"},
+			{"refId": "B", "rawQuery": true, "query": "SELECT mean(\"usage\") FROM \"cpu\" WHERE $timeFilter GROUP BY time($__interval)"},
+			{"refId": "C", "datasource": {"uid": "other"}, "query": "up"}
+		]},
+		{"id": 2, "type": "row", "collapsed": true, "panels": [
+			{"id": 3, "title": "SQL", "datasource": {"uid": "influx"}, "targets": [
+				{"refId": "A", "language": "SQL", "rawSql": "select 1"}
+			]}
+		]}
+	]}}`
+
+	call := func(t *testing.T, s *Service, query string) migrationReport {
+		t.Helper()
+		sender := &fakeSender{}
+		err := s.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "influx"},
+			},
+			Method: http.MethodPost,
+			Path:   "migration",
+			URL:    "migration?" + query,
+			Body:   []byte(dashboard),
+		}, sender)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, sender.resp.Status, string(sender.resp.Body))
+
+		var report migrationReport
+		require.NoError(t, json.Unmarshal(sender.resp.Body, &report))
+		return report
+	}
+
+	s := GetMockService(influxVersionInfluxQL, RoundTripper{})
+	s.im.(*fakeInstance).product = models.ProductOSS1
+
+	t.Run("reports the languages of the queries", func(t *testing.T) {
+		report := call(t, s, "")
+		assert.Equal(t, "InfluxDB OSS 1.x", report.Product)
+		assert.Nil(t, report.Dashboard)
+		require.Len(t, report.Queries, 3)
+		assert.Equal(t, migrationQuery{PanelID: float64(1), PanelTitle: "CPU", RefID: "A", Language: models.LanguageInfluxQL, Supported: true}, report.Queries[0])
+		assert.Equal(t, "B", report.Queries[1].RefID)
+		assert.Equal(t, migrationQuery{PanelID: float64(3), PanelTitle: "SQL", RefID: "A", Language: models.LanguageSQL, Supported: false}, report.Queries[2])
+	})
+
+	t.Run("reports the queries converted to sql", func(t *testing.T) {
+		s := GetMockService(influxVersionInfluxQL, RoundTripper{})
+		s.im.(*fakeInstance).product = models.ProductCore3
+
+		report := call(t, s, "language=SQL")
+		require.Len(t, report.Queries, 3)
+		assert.Nil(t, report.Dashboard)
+		assert.Equal(t, `SELECT time, "usage" FROM "cpu" WHERE $__timeFilter(time) ORDER BY time`, report.Queries[0].RewrittenQuery)
+	})
+
+	t.Run("rewrites the queries converted to sql", func(t *testing.T) {
+		s := GetMockService(influxVersionInfluxQL, RoundTripper{})
+		s.im.(*fakeInstance).product = models.ProductCore3
+
+		report := call(t, s, "language=SQL&rewrite=true")
+		require.Len(t, report.Queries, 3)
+		assert.True(t, report.Queries[2].Supported)
+		assert.Equal(t, `SELECT time, "usage" FROM "cpu" WHERE $__timeFilter(time) ORDER BY time`, report.Queries[0].RewrittenQuery)
+		assert.Empty(t, report.Queries[1].RewrittenQuery)
+
+		panels := report.Dashboard["panels"].([]any)
+		target := panels[0].(map[string]any)["targets"].([]any)[0].(map[string]any)
+		assert.Equal(t, report.Queries[0].RewrittenQuery, target["rawSql"])
+		assert.Equal(t, models.LanguageSQL, target["language"])
+		assert.Equal(t, "time_series", target["format"])
+	})
+
+	t.Run("only accepts posts", func(t *testing.T) {
+		resp := callResource(t, s, "migration", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.Status)
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	sdkhttpclient "github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 
 	"github.com/grafana/grafana/pkg/infra/httpclient"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
@@ -46,6 +47,9 @@ func (p *fakeHttpClientProvider) RoundTrip(req *http.Request) (*http.Response, e
 
 type fakeInstance struct {
 	version          string
+	product          string
+	productVersion   string
+	authMode         string
 	fakeRoundTripper RoundTripper
 }
 
@@ -67,16 +71,19 @@ func (f *fakeInstance) Get(_ context.Context, _ backend.PluginContext) (instance
 	}
 
 	return &models.DatasourceInfo{
-		HTTPClient:    client,
-		Token:         "sometoken",
-		URL:           "https://awesome-influx.com",
-		DbName:        "testdb",
-		Version:       f.version,
-		HTTPMode:      "GET",
-		TimeInterval:  "10s",
-		DefaultBucket: "testbucket",
-		Organization:  "testorg",
-		MaxSeries:     2,
+		HTTPClient:     client,
+		Token:          "sometoken",
+		URL:            "https://awesome-influx.com",
+		DbName:         "testdb",
+		Version:        f.version,
+		Product:        f.product,
+		ProductVersion: f.productVersion,
+		AuthMode:       f.authMode,
+		HTTPMode:       "GET",
+		TimeInterval:   "10s",
+		DefaultBucket:  "testbucket",
+		Organization:   "testorg",
+		MaxSeries:      2,
 	}, nil
 }
 
@@ -113,7 +120,7 @@ func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func GetMockService(version string, rt RoundTripper) *Service {
-	return &Service{
+	s := &Service{
 		im: &fakeInstance{
 			version:          version,
 			fakeRoundTripper: rt,
@@ -124,6 +131,8 @@ func GetMockService(version string, rt RoundTripper) *Service {
 			},
 		},
 	}
+	s.resourceHandler = httpadapter.New(s.newResourceMux())
+	return s
 }
 
 type fakeFeatureToggles struct {
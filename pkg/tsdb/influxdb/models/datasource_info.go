@@ -1,7 +1,10 @@
 package models
 
 import (
+	"io"
 	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 )
 
 type DatasourceInfo struct {
@@ -18,8 +21,90 @@ type DatasourceInfo struct {
 	Organization  string `json:"organization"`
 	MaxSeries     int    `json:"maxSeries"`
 
+	// Version above is the query language of the datasource (Flux,
+	// InfluxQL or SQL). Product is the InfluxDB product queried (OSS1, OSS2,
+	// Cloud, Clustered or Core3) and ProductVersion its version, if known.
+	Product        string `json:"product"`
+	ProductVersion string `json:"productVersion"`
+	// AuthMode is how the datasource authenticates: basic, token or none.
+	AuthMode string `json:"-"`
+
 	// Flight SQL metadata
 	Metadata []map[string]string `json:"metadata"`
 	// FlightSQL grpc connection
 	SecureGrpc bool `json:"secureGrpc"`
+	// TLS options of secure FlightSQL connections, from the TLS settings of
+	// the datasource: PEM-encoded CA bundle, client certificate and key,
+	// certificate verification and server name. The system certificate pool
+	// is used when unset.
+	TLS *httpclient.TLSOptions `json:"-"`
+	// Headers set on every FlightSQL call, from the custom HTTP headers of
+	// the datasource, whose values are secret. They override the metadata.
+	Headers map[string]string `json:"-"`
+	// Name of the FlightSQL credentials provider signing each call, as
+	// registered with fsql.RegisterCredentialsProvider
+	AuthScheme string `json:"authScheme"`
+	// FlightSQL gRPC service config (retry policy, method timeouts, load
+	// balancing policy) in the JSON format described by
+	// https://github.com/grpc/grpc/blob/master/doc/service_config.md
+	GrpcServiceConfig string `json:"grpcServiceConfig"`
+	// Preferred names of the time and value columns of FlightSQL time
+	// series results, matched ignoring case
+	TimeColumns  []string `json:"timeColumns"`
+	ValueColumns []string `json:"valueColumns"`
+	// Limits checked against the result size announced by the FlightSQL
+	// server before streaming results. Oversized queries are refused when
+	// AbortOversizedQueries is set, otherwise a warning is attached.
+	MaxResultRows         int64 `json:"maxResultRows"`
+	MaxResultBytes        int64 `json:"maxResultBytes"`
+	AbortOversizedQueries bool  `json:"abortOversizedQueries"`
+	// Maximum number of rows of FlightSQL records converted at once. Larger
+	// records sent by the server are converted in chunks of that many rows.
+	BatchSize int `json:"batchSize"`
+	// SQL returning the data version of the table named by $__table, such
+	// as its last modification time from a system table. When set, queries
+	// are not run again while the data versions of their tables and their
+	// time range are unchanged.
+	DataVersionSQL string `json:"dataVersionSql"`
+	// Infer the units of FlightSQL fields from the suffixes of their column
+	// names, such as _bytes or _ms, unless the query says otherwise
+	InferUnits bool `json:"inferUnits"`
+	// Maximum number of FlightSQL query requests running at once; zero
+	// means unlimited. Waiting alert queries are run before dashboard
+	// queries.
+	MaxConcurrentQueries int `json:"maxConcurrentQueries"`
+	// Limits of the FlightSQL queries of alert rules, which fail rather
+	// than return partial results: the timeout of each query in seconds,
+	// the maximum number of rows of its results, and the number of times a
+	// query the server asks to retry later is executed again. Zero means the
+	// default.
+	AlertQueryTimeout int   `json:"alertQueryTimeout"`
+	AlertMaxRows      int64 `json:"alertMaxRows"`
+	AlertMaxRetries   int   `json:"alertMaxRetries"`
+	// Format of the results of FlightSQL queries not choosing one: table,
+	// time_series or logs. Time series are returned when it is unset.
+	DefaultFormat string `json:"defaultFormat"`
+	// Maximum number of characters of the string values of FlightSQL
+	// results; longer values are truncated with a notice. Zero means
+	// unlimited.
+	MaxStringLength int `json:"maxStringLength"`
+	// Flushing of the results of streamed FlightSQL queries: the interval
+	// in milliseconds at which the rows received are published, and the
+	// number of rows published at once without waiting for it. Zero means
+	// the default.
+	StreamFlushInterval   int `json:"streamFlushInterval"`
+	StreamMaxBufferedRows int `json:"streamMaxBufferedRows"`
+	// FlightSQL is the FlightSQL connection shared by the queries of this
+	// instance. It is set by the fsql package when the datasource uses SQL.
+	FlightSQL io.Closer `json:"-"`
+}
+
+// Dispose implements instancemgmt.InstanceDisposer. It is called when the
+// datasource settings change or the datasource is deleted and releases the
+// FlightSQL connection, if any.
+func (d *DatasourceInfo) Dispose() {
+	if d.FlightSQL == nil {
+		return
+	}
+	_ = d.FlightSQL.Close()
 }
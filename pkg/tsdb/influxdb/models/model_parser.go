@@ -2,7 +2,9 @@ package models
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -29,7 +31,7 @@ func QueryParse(query backend.DataQuery) (*Query, error) {
 	measurement := model.Get("measurement").MustString("")
 	resultFormat := model.Get("resultFormat").MustString("")
 
-	tags, err := parseTags(model)
+	tags, err := parseTags(model, measurement)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +97,9 @@ func parseSelects(model *simplejson.Json) ([]*Select, error) {
 	return result, nil
 }
 
-func parseTags(model *simplejson.Json) ([]*Tag, error) {
+// parseTags parses the tags of the query, leaving out the tags scoped to
+// other measurements than the one queried.
+func parseTags(model *simplejson.Json, measurement string) ([]*Tag, error) {
 	tags := model.Get("tags").MustArray()
 	result := make([]*Tag, 0, len(tags))
 	for _, t := range tags {
@@ -108,11 +112,6 @@ func parseTags(model *simplejson.Json) ([]*Tag, error) {
 			return nil, err
 		}
 
-		tag.Value, err = tagJson.Get("value").String()
-		if err != nil {
-			return nil, err
-		}
-
 		operator, err := tagJson.Get("operator").String()
 		if err == nil {
 			tag.Operator = operator
@@ -123,12 +122,52 @@ func parseTags(model *simplejson.Json) ([]*Tag, error) {
 			tag.Condition = condition
 		}
 
+		values, err := tagJson.Get("values").StringArray()
+		if err == nil {
+			tag.Values = values
+		}
+
+		tag.Table = tagJson.Get("table").MustString()
+		if tag.Table != "" && !matchMeasurement(measurement, tag.Table) {
+			continue
+		}
+
+		switch strings.ToLower(tag.Operator) {
+		case "=|", "!=|":
+			// Multi-value operators take their values from values, or a
+			// single one from value.
+			tag.Value = tagJson.Get("value").MustString()
+			if len(tag.Values) == 0 && tag.Value == "" {
+				return nil, fmt.Errorf("tag %q: missing values", tag.Key)
+			}
+		case "between":
+			if len(tag.Values) != 2 {
+				return nil, fmt.Errorf("tag %q: between expects 2 values, received %d", tag.Key, len(tag.Values))
+			}
+		case "is null", "is not null":
+		default:
+			tag.Value, err = tagJson.Get("value").String()
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		result = append(result, tag)
 	}
 
 	return result, nil
 }
 
+// matchMeasurement reports whether the measurement queried, either a name or
+// a /regex/, matches the measurement named table.
+func matchMeasurement(measurement, table string) bool {
+	if regexpMeasurementPattern.MatchString(measurement) {
+		re, err := regexp.Compile(measurement[1 : len(measurement)-1])
+		return err == nil && re.MatchString(table)
+	}
+	return measurement == table
+}
+
 func parseQueryPart(model *simplejson.Json) (*QueryPart, error) {
 	typ, err := model.Get("type").String()
 	if err != nil {
@@ -198,4 +198,40 @@ func TestInfluxdbQueryParser_Parse(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, time.Millisecond*1, res.Interval)
 	})
+
+	t.Run("validates the values of tags", func(t *testing.T) {
+		parse := func(tags string) error {
+			_, err := QueryParse(backend.DataQuery{JSON: []byte(`{"measurement": "cpu", "tags": ` + tags + `}`)})
+			return err
+		}
+
+		require.NoError(t, parse(`[{"key": "host", "operator": "=", "value": "a"}]`))
+		require.NoError(t, parse(`[{"key": "host", "operator": "=|", "values": ["a", "b"]}]`))
+		require.NoError(t, parse(`[{"key": "host", "operator": "is null"}]`))
+		require.Error(t, parse(`[{"key": "host", "operator": "="}]`))
+		require.ErrorContains(t, parse(`[{"key": "host", "operator": "=|"}]`), `tag "host": missing values`)
+		require.ErrorContains(t, parse(`[{"key": "host", "operator": "!=|", "values": []}]`), `tag "host": missing values`)
+		require.ErrorContains(t, parse(`[{"key": "n", "operator": "between", "values": ["1"]}]`), "between expects 2 values")
+	})
+
+	t.Run("leaves out tags scoped to other measurements", func(t *testing.T) {
+		parse := func(measurement string) []string {
+			res, err := QueryParse(backend.DataQuery{JSON: []byte(`{"measurement": "` + measurement + `", "tags": [
+				{"key": "host", "operator": "=", "value": "a", "table": "cpu"},
+				{"key": "device", "operator": "=", "value": "sda", "table": "disk"},
+				{"key": "region", "operator": "=", "value": "eu"},
+				{"key": "a.b", "operator": "=", "value": "c"}
+			]}`)})
+			require.NoError(t, err)
+			var keys []string
+			for _, tag := range res.Tags {
+				keys = append(keys, tag.Key)
+			}
+			return keys
+		}
+
+		require.Equal(t, []string{"host", "region", "a.b"}, parse("cpu"))
+		require.Equal(t, []string{"device", "region", "a.b"}, parse("disk"))
+		require.Equal(t, []string{"host", "device", "region", "a.b"}, parse(`/^(cpu|disk)$/`))
+	})
 }
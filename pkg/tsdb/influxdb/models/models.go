@@ -25,6 +25,12 @@ type Tag struct {
 	Operator  string
 	Value     string
 	Condition string
+	// Values holds the operands of the multi-value operators (one of, not one
+	// of, between).
+	Values []string
+	// Table is the measurement the tag is scoped to, if any, such as for ad
+	// hoc filters meant for the panels of a dashboard querying it.
+	Table string
 }
 
 type Select []QueryPart
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Products of InfluxDB a datasource can connect to.
+const (
+	ProductOSS1      = "OSS1"
+	ProductOSS2      = "OSS2"
+	ProductCloud     = "Cloud"
+	ProductClustered = "Clustered"
+	ProductCore3     = "Core3"
+)
+
+// Query languages of a datasource, stored in its Version field.
+const (
+	LanguageFlux     = "Flux"
+	LanguageInfluxQL = "InfluxQL"
+	LanguageSQL      = "SQL"
+)
+
+// Authentication modes of a datasource.
+const (
+	AuthModeBasic = "basic"
+	AuthModeToken = "token"
+	AuthModeNone  = "none"
+)
+
+// Capabilities are what a product of InfluxDB supports.
+type Capabilities struct {
+	// Name is the name of the product shown to users.
+	Name      string   `json:"name"`
+	Languages []string `json:"languages"`
+	AuthModes []string `json:"authModes"`
+	Limits    Limits   `json:"limits"`
+}
+
+// Limits are the default limits of a product. Zero means unlimited or
+// unknown.
+type Limits struct {
+	MaxDatabases       int `json:"maxDatabases,omitempty"`
+	MaxTables          int `json:"maxTables,omitempty"`
+	MaxColumnsPerTable int `json:"maxColumnsPerTable,omitempty"`
+}
+
+// capabilityMatrix lists the capabilities of each product.
+var capabilityMatrix = map[string]Capabilities{
+	ProductOSS1: {
+		Name:      "InfluxDB OSS 1.x",
+		Languages: []string{LanguageInfluxQL, LanguageFlux},
+		AuthModes: []string{AuthModeBasic, AuthModeNone},
+	},
+	ProductOSS2: {
+		Name:      "InfluxDB OSS 2.x",
+		Languages: []string{LanguageFlux, LanguageInfluxQL},
+		AuthModes: []string{AuthModeToken, AuthModeBasic},
+	},
+	ProductCloud: {
+		Name:      "InfluxDB Cloud",
+		Languages: []string{LanguageSQL, LanguageInfluxQL, LanguageFlux},
+		AuthModes: []string{AuthModeToken},
+		Limits: Limits{
+			MaxTables:          500,
+			MaxColumnsPerTable: 200,
+		},
+	},
+	ProductClustered: {
+		Name:      "InfluxDB Clustered",
+		Languages: []string{LanguageSQL, LanguageInfluxQL},
+		AuthModes: []string{AuthModeToken},
+		Limits: Limits{
+			MaxTables:          500,
+			MaxColumnsPerTable: 250,
+		},
+	},
+	ProductCore3: {
+		Name:      "InfluxDB 3 Core",
+		Languages: []string{LanguageSQL, LanguageInfluxQL},
+		AuthModes: []string{AuthModeToken, AuthModeNone},
+		Limits: Limits{
+			MaxDatabases:       5,
+			MaxTables:          2000,
+			MaxColumnsPerTable: 500,
+		},
+	},
+}
+
+// Capabilities returns the capabilities of the product of the datasource.
+// It reports false when the product isn't set or isn't known, in which case
+// nothing is assumed about what the datasource supports.
+func (d *DatasourceInfo) Capabilities() (Capabilities, bool) {
+	c, ok := capabilityMatrix[d.Product]
+	return c, ok
+}
+
+// SupportsLanguage reports whether the query language is supported.
+func (c Capabilities) SupportsLanguage(language string) bool {
+	for _, l := range c.Languages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckLanguage returns an error when the product of the datasource is known
+// not to support its query language.
+func (d *DatasourceInfo) CheckLanguage() error {
+	return d.CheckQueryLanguage(d.Version)
+}
+
+// CheckQueryLanguage returns an error when the product of the datasource is
+// known not to support the query language.
+func (d *DatasourceInfo) CheckQueryLanguage(language string) error {
+	c, ok := d.Capabilities()
+	if !ok || c.SupportsLanguage(language) {
+		return nil
+	}
+	return fmt.Errorf("%s does not support %s queries, supported query languages: %s", c.Name, language, strings.Join(c.Languages, ", "))
+}
+
+// SupportsAuthMode reports whether the authentication mode is supported.
+func (c Capabilities) SupportsAuthMode(mode string) bool {
+	for _, m := range c.AuthModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAuthMode returns an error when the product of the datasource is known
+// not to support its authentication mode.
+func (d *DatasourceInfo) CheckAuthMode() error {
+	c, ok := d.Capabilities()
+	if !ok || d.AuthMode == "" || c.SupportsAuthMode(d.AuthMode) {
+		return nil
+	}
+	return fmt.Errorf("%s does not support %s authentication, supported authentication modes: %s", c.Name, d.AuthMode, strings.Join(c.AuthModes, ", "))
+}
+
+// String describes the limits set, such as "5 databases, 2000 tables".
+func (l Limits) String() string {
+	var limits []string
+	if l.MaxDatabases > 0 {
+		limits = append(limits, fmt.Sprintf("%d databases", l.MaxDatabases))
+	}
+	if l.MaxTables > 0 {
+		limits = append(limits, fmt.Sprintf("%d tables", l.MaxTables))
+	}
+	if l.MaxColumnsPerTable > 0 {
+		limits = append(limits, fmt.Sprintf("%d columns per table", l.MaxColumnsPerTable))
+	}
+	return strings.Join(limits, ", ")
+}
+
+// ProductDescription describes the product of the datasource with its
+// version and limits, such as "InfluxDB 3 Core 3.0.1 (limits: 5 databases)".
+// It is empty when the product isn't known.
+func (d *DatasourceInfo) ProductDescription() string {
+	c, ok := d.Capabilities()
+	if !ok {
+		return ""
+	}
+	desc := c.Name
+	if d.ProductVersion != "" {
+		desc += " " + d.ProductVersion
+	}
+	if limits := c.Limits.String(); limits != "" {
+		desc += " (limits: " + limits + ")"
+	}
+	return desc
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLanguage(t *testing.T) {
+	cs := []struct {
+		product  string
+		language string
+		err      string
+	}{
+		{product: "", language: LanguageFlux},
+		{product: "unknown", language: LanguageSQL},
+		{product: ProductOSS1, language: LanguageInfluxQL},
+		{product: ProductOSS1, language: LanguageSQL, err: "InfluxDB OSS 1.x does not support SQL queries, supported query languages: InfluxQL, Flux"},
+		{product: ProductOSS2, language: LanguageFlux},
+		{product: ProductCloud, language: LanguageSQL},
+		{product: ProductClustered, language: LanguageFlux, err: "InfluxDB Clustered does not support Flux queries, supported query languages: SQL, InfluxQL"},
+		{product: ProductCore3, language: LanguageSQL},
+	}
+	for _, c := range cs {
+		t.Run(c.product+" "+c.language, func(t *testing.T) {
+			err := (&DatasourceInfo{Product: c.product, Version: c.language}).CheckLanguage()
+			if c.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	c, ok := (&DatasourceInfo{Product: ProductCore3}).Capabilities()
+	require.True(t, ok)
+	require.Equal(t, 5, c.Limits.MaxDatabases)
+	require.Contains(t, c.AuthModes, AuthModeNone)
+
+	_, ok = (&DatasourceInfo{}).Capabilities()
+	require.False(t, ok)
+}
+
+func TestCheckAuthMode(t *testing.T) {
+	cs := []struct {
+		product  string
+		authMode string
+		err      string
+	}{
+		{product: "", authMode: AuthModeBasic},
+		{product: ProductCloud, authMode: ""},
+		{product: ProductOSS1, authMode: AuthModeBasic},
+		{product: ProductCloud, authMode: AuthModeBasic, err: "InfluxDB Cloud does not support basic authentication, supported authentication modes: token"},
+		{product: ProductCore3, authMode: AuthModeNone},
+	}
+	for _, c := range cs {
+		t.Run(c.product+" "+c.authMode, func(t *testing.T) {
+			err := (&DatasourceInfo{Product: c.product, AuthMode: c.authMode}).CheckAuthMode()
+			if c.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestProductDescription(t *testing.T) {
+	require.Equal(t, "", (&DatasourceInfo{}).ProductDescription())
+	require.Equal(t, "InfluxDB OSS 2.x", (&DatasourceInfo{Product: ProductOSS2}).ProductDescription())
+	require.Equal(t, "InfluxDB Cloud 2.7 (limits: 500 tables, 200 columns per table)", (&DatasourceInfo{Product: ProductCloud, ProductVersion: "2.7"}).ProductDescription())
+}
@@ -129,12 +129,67 @@ func (query *Query) renderTags() []string {
 			escapedKey = fmt.Sprintf(`"%s"::field`, strings.TrimSuffix(tag.Key, "::field"))
 		}
 
+		if expr, ok := renderMultiValueTag(tag, escapedKey); ok {
+			res = append(res, str+expr)
+			continue
+		}
+
 		res = append(res, fmt.Sprintf(`%s%s %s %s`, str, escapedKey, tag.Operator, textValue))
 	}
 
 	return res
 }
 
+// renderMultiValueTag renders the operators InfluxQL has no syntax for as
+// combinations of simple comparisons.
+func renderMultiValueTag(tag *Tag, escapedKey string) (string, bool) {
+	values := tag.Values
+	if len(values) == 0 && tag.Value != "" {
+		values = []string{tag.Value}
+	}
+	quote := func(v string) string {
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(v, `\`, `\\`))
+	}
+	join := func(op, conj string) string {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			parts = append(parts, fmt.Sprintf("%s %s %s", escapedKey, op, quote(v)))
+		}
+		return "(" + strings.Join(parts, " "+conj+" ") + ")"
+	}
+
+	switch strings.ToLower(tag.Operator) {
+	case "=|":
+		// No value is one of no values.
+		if len(values) == 0 {
+			return "false", true
+		}
+		return join("=", "OR"), true
+	case "!=|":
+		if len(values) == 0 {
+			return "true", true
+		}
+		return join("!=", "AND"), true
+	case "between":
+		if len(values) != 2 {
+			return "", false
+		}
+		bound := func(v string) string {
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return v
+			}
+			return quote(v)
+		}
+		return fmt.Sprintf("(%s >= %s AND %s <= %s)", escapedKey, bound(values[0]), escapedKey, bound(values[1])), true
+	case "is null":
+		// InfluxQL has no nulls; missing tags compare equal to the empty string.
+		return fmt.Sprintf("%s = ''", escapedKey), true
+	case "is not null":
+		return fmt.Sprintf("%s != ''", escapedKey), true
+	}
+	return "", false
+}
+
 func (query *Query) renderTimeFilter(queryContext *backend.QueryDataRequest) string {
 	from, to := epochMStoInfluxTime(&queryContext.Queries[0].TimeRange)
 	return fmt.Sprintf("time >= %s and time <= %s", from, to)
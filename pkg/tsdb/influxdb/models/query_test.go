@@ -233,6 +233,43 @@ func TestInfluxdbQueryBuilder(t *testing.T) {
 
 			require.Equal(t, strings.Join(query.renderTags(), ""), `"key" >= 10001`)
 		})
+		t.Run("can render one of tags", func(t *testing.T) {
+			query := &Query{Tags: []*Tag{{Operator: "=|", Values: []string{"a", "b"}, Key: "key"}}}
+
+			require.Equal(t, `("key" = 'a' OR "key" = 'b')`, strings.Join(query.renderTags(), ""))
+		})
+
+		t.Run("can render not one of tags", func(t *testing.T) {
+			query := &Query{Tags: []*Tag{{Operator: "!=|", Values: []string{"a", "b"}, Key: "key"}}}
+
+			require.Equal(t, `("key" != 'a' AND "key" != 'b')`, strings.Join(query.renderTags(), ""))
+		})
+
+		t.Run("can render one of tags without values", func(t *testing.T) {
+			query := &Query{Tags: []*Tag{{Operator: "=|", Key: "key"}}}
+			require.Equal(t, `false`, strings.Join(query.renderTags(), ""))
+
+			query = &Query{Tags: []*Tag{{Operator: "!=|", Key: "key"}}}
+			require.Equal(t, `true`, strings.Join(query.renderTags(), ""))
+		})
+
+		t.Run("can render between tags", func(t *testing.T) {
+			query := &Query{Tags: []*Tag{
+				{Operator: "=", Value: "a", Key: "host"},
+				{Operator: "between", Values: []string{"10", "20"}, Key: "key"},
+			}}
+
+			require.Equal(t, `"host" = 'a' AND ("key" >= 10 AND "key" <= 20)`, strings.Join(query.renderTags(), " "))
+		})
+
+		t.Run("can render null check tags", func(t *testing.T) {
+			query := &Query{Tags: []*Tag{{Operator: "is null", Key: "key"}}}
+			require.Equal(t, `"key" = ''`, strings.Join(query.renderTags(), ""))
+
+			query = &Query{Tags: []*Tag{{Operator: "is not null", Key: "key"}}}
+			require.Equal(t, `"key" != ''`, strings.Join(query.renderTags(), ""))
+		})
+
 		t.Run("can render number less than or equal to condition tags", func(t *testing.T) {
 			query := &Query{Tags: []*Tag{{Operator: "<=", Value: "10001", Key: "key"}}}
 
@@ -0,0 +1,223 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/fsql"
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func (s *Service) newResourceMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/macros", s.handleMacros)
+	mux.HandleFunc("/migration", s.handleMigration)
+	mux.HandleFunc("/estimate", s.handleEstimate)
+	mux.HandleFunc("/fsql/schemas", s.handleSchemas)
+	mux.HandleFunc("/fsql/tables", s.handleTables)
+	mux.HandleFunc("/fsql/columns", s.handleColumns)
+	return mux
+}
+
+// handleMacros lists the macros of SQL queries with example expansions for
+// the time range given by the from and to parameters (epoch milliseconds),
+// the intervalMs parameter and the timezone parameter of the dashboard.
+// Without a time range, the last hour is used.
+func (s *Service) handleMacros(rw http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+
+	timeRange, err := parseTimeRange(params)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	interval := time.Minute
+	if v := params.Get("intervalMs"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("intervalMs: %w", err))
+			return
+		}
+		interval = time.Duration(ms) * time.Millisecond
+	}
+
+	loc := time.UTC
+	if v := params.Get("timezone"); v != "" {
+		if loc, err = time.LoadLocation(v); err != nil {
+			writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("timezone: %w", err))
+			return
+		}
+	}
+
+	docs, err := fsql.MacroDocs(timeRange, interval, loc)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, docs)
+}
+
+// handleEstimate estimates the cost of the SQL query posted, in the format
+// of the queries of the datasource, for the time range given by the from
+// and to parameters (epoch milliseconds). Without a time range, the last
+// hour is used.
+func (s *Service) handleEstimate(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeResourceError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	timeRange, err := parseTimeRange(req.URL.Query())
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	dsInfo, err := s.getDSInfo(req.Context(), httpadapter.PluginConfigFromContext(req.Context()))
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	if dsInfo.Version != influxVersionSQL {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("cost estimation is only supported for SQL queries"))
+		return
+	}
+
+	est, err := fsql.EstimateCost(req.Context(), dsInfo, backend.DataQuery{JSON: body, TimeRange: timeRange})
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, est)
+}
+
+// handleSchemas lists the schemas of the database given by the database
+// parameter, or of the default database of the datasource.
+func (s *Service) handleSchemas(rw http.ResponseWriter, req *http.Request) {
+	dsInfo, ok := s.sqlDatasource(rw, req)
+	if !ok {
+		return
+	}
+	schemas, err := fsql.Schemas(req.Context(), dsInfo, req.URL.Query().Get("database"))
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, schemas)
+}
+
+// handleTables lists the tables of the database, in the schema given by the
+// schema parameter if any.
+func (s *Service) handleTables(rw http.ResponseWriter, req *http.Request) {
+	dsInfo, ok := s.sqlDatasource(rw, req)
+	if !ok {
+		return
+	}
+	params := req.URL.Query()
+	tables, err := fsql.Tables(req.Context(), dsInfo, params.Get("database"), params.Get("schema"))
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, tables)
+}
+
+// handleColumns lists the columns of the table given by the table parameter.
+func (s *Service) handleColumns(rw http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	table := params.Get("table")
+	if table == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing table parameter"))
+		return
+	}
+	dsInfo, ok := s.sqlDatasource(rw, req)
+	if !ok {
+		return
+	}
+	columns, err := fsql.Columns(req.Context(), dsInfo, params.Get("database"), params.Get("schema"), table)
+	switch {
+	case errors.Is(err, fsql.ErrTableNotFound):
+		writeResourceError(rw, http.StatusNotFound, err)
+	case err != nil:
+		writeResourceError(rw, http.StatusInternalServerError, err)
+	default:
+		writeResourceJSON(rw, columns)
+	}
+}
+
+// sqlDatasource returns the settings of the datasource of a metadata
+// request, writing an error response when it doesn't use SQL.
+func (s *Service) sqlDatasource(rw http.ResponseWriter, req *http.Request) (*models.DatasourceInfo, bool) {
+	dsInfo, err := s.getDSInfo(req.Context(), httpadapter.PluginConfigFromContext(req.Context()))
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if dsInfo.Version != influxVersionSQL {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("metadata is only available for SQL queries"))
+		return nil, false
+	}
+	return dsInfo, true
+}
+
+// parseTimeRange returns the time range given by the from and to parameters
+// (epoch milliseconds), or the last hour.
+func parseTimeRange(params url.Values) (backend.TimeRange, error) {
+	to := time.Now()
+	if !params.Has("from") && !params.Has("to") {
+		return backend.TimeRange{From: to.Add(-time.Hour), To: to}, nil
+	}
+	from, err := parseEpochMs(params.Get("from"))
+	if err != nil {
+		return backend.TimeRange{}, fmt.Errorf("from: %w", err)
+	}
+	to, err = parseEpochMs(params.Get("to"))
+	if err != nil {
+		return backend.TimeRange{}, fmt.Errorf("to: %w", err)
+	}
+	return backend.TimeRange{From: from, To: to}, nil
+}
+
+func parseEpochMs(v string) (time.Time, error) {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+func writeResourceJSON(rw http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if _, err := rw.Write(body); err != nil {
+		logger.Error("Failed to write resource response", "err", err)
+	}
+}
+
+func writeResourceError(rw http.ResponseWriter, code int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	if _, err := rw.Write(body); err != nil {
+		logger.Error("Failed to write resource response", "err", err)
+	}
+}
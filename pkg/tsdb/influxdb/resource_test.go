@@ -0,0 +1,101 @@
+package influxdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/fsql"
+)
+
+type fakeSender struct {
+	resp *backend.CallResourceResponse
+}
+
+func (sender *fakeSender) Send(resp *backend.CallResourceResponse) error {
+	sender.resp = resp
+	return nil
+}
+
+func callResource(t *testing.T, s *Service, path, query string) *backend.CallResourceResponse {
+	t.Helper()
+	sender := &fakeSender{}
+	err := s.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: http.MethodGet,
+		Path:   path,
+		URL:    path + "?" + query,
+	}, sender)
+	require.NoError(t, err)
+	require.NotNil(t, sender.resp)
+	return sender.resp
+}
+
+func TestMacrosResource(t *testing.T) {
+	s := GetMockService(influxVersionSQL, RoundTripper{})
+
+	t.Run("expands examples for the time range", func(t *testing.T) {
+		resp := callResource(t, s, "macros", "from=1672531200000&to=1672531800000&intervalMs=10000")
+		require.Equal(t, http.StatusOK, resp.Status)
+
+		var docs []fsql.MacroDoc
+		require.NoError(t, json.Unmarshal(resp.Body, &docs))
+		expansions := map[string]string{}
+		for _, d := range docs {
+			expansions[d.Name] = d.Expansion
+		}
+		assert.Equal(t, `cast('2023-01-01T00:00:00Z' as timestamp)`, expansions["timeFrom"])
+		assert.Equal(t, `interval '10 second'`, expansions["interval"])
+	})
+
+	t.Run("invalid timezone", func(t *testing.T) {
+		resp := callResource(t, s, "macros", "timezone=Nowhere/City")
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+	})
+}
+
+func TestEstimateResource(t *testing.T) {
+	call := func(s *Service, method string) *backend.CallResourceResponse {
+		sender := &fakeSender{}
+		err := s.CallResource(context.Background(), &backend.CallResourceRequest{
+			Method: method,
+			Path:   "estimate",
+			URL:    "estimate",
+			Body:   []byte(`{"rawSql": "select 1"}`),
+		}, sender)
+		require.NoError(t, err)
+		return sender.resp
+	}
+
+	t.Run("only accepts posts", func(t *testing.T) {
+		resp := call(GetMockService(influxVersionSQL, RoundTripper{}), http.MethodGet)
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.Status)
+	})
+
+	t.Run("only estimates sql queries", func(t *testing.T) {
+		resp := call(GetMockService(influxVersionFlux, RoundTripper{}), http.MethodPost)
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+		assert.JSONEq(t, `{"error": "cost estimation is only supported for SQL queries"}`, string(resp.Body))
+	})
+}
+
+func TestMetadataResources(t *testing.T) {
+	t.Run("only lists sql metadata", func(t *testing.T) {
+		s := GetMockService(influxVersionFlux, RoundTripper{})
+		for _, path := range []string{"fsql/schemas", "fsql/tables", "fsql/columns"} {
+			resp := callResource(t, s, path, "table=cpu")
+			assert.Equal(t, http.StatusBadRequest, resp.Status, path)
+			assert.JSONEq(t, `{"error": "metadata is only available for SQL queries"}`, string(resp.Body), path)
+		}
+	})
+
+	t.Run("columns need a table", func(t *testing.T) {
+		resp := callResource(t, GetMockService(influxVersionSQL, RoundTripper{}), "fsql/columns", "")
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+		assert.JSONEq(t, `{"error": "missing table parameter"}`, string(resp.Body))
+	})
+}
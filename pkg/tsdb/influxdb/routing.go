@@ -0,0 +1,125 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+// Patterns telling the language of a raw query. A query matching the
+// patterns of several languages, or of none, is of the language of the
+// datasource.
+var (
+	fluxPattern     = regexp.MustCompile(`\|>|^\s*import\s+"|^\s*from\s*\(\s*bucket`)
+	influxQLPattern = regexp.MustCompile(`(?i)group\s+by\s+time\s*\(|\$timeFilter\b|::(field|tag)\b|\bfill\s*\(`)
+	sqlPattern      = regexp.MustCompile(`(?i)\$__(timeFilter|timeFrom|timeTo|dateBin|timeGroup)\b|\bdate_bin\s*\(|\binformation_schema\b`)
+	// influxQLShowPattern matches SHOW statements, which InfluxDB 3 SQL has
+	// too: they only tell InfluxQL apart from Flux.
+	influxQLShowPattern = regexp.MustCompile(`(?i)^\s*show\s`)
+)
+
+// detectLanguage returns the language of the raw query of a datasource of
+// dsLanguage, or "" when it can't tell. Queries are only routed away from
+// dsLanguage on patterns that aren't valid in dsLanguage.
+func detectLanguage(query, dsLanguage string) string {
+	var languages []string
+	if fluxPattern.MatchString(query) {
+		languages = append(languages, models.LanguageFlux)
+	}
+	if influxQLPattern.MatchString(query) || (dsLanguage != models.LanguageSQL && influxQLShowPattern.MatchString(query)) {
+		languages = append(languages, models.LanguageInfluxQL)
+	}
+	if sqlPattern.MatchString(query) {
+		languages = append(languages, models.LanguageSQL)
+	}
+	if len(languages) != 1 {
+		return ""
+	}
+	return languages[0]
+}
+
+// routedQuery is the part of a query telling its language.
+type routedQuery struct {
+	// Language is the language of the query set by the user, overriding
+	// the detected one.
+	Language string `json:"language"`
+	// Query is the raw query of Flux and InfluxQL queries.
+	Query string `json:"query"`
+	// RawSQL is the raw query of SQL queries.
+	RawSQL string `json:"rawSql"`
+	// RawQuery tells whether InfluxQL queries use Query or are built by the
+	// query editor.
+	RawQuery bool `json:"rawQuery"`
+}
+
+// queryLanguage returns the language of the query: the language set on the
+// query, otherwise the language detected from its raw query, otherwise the
+// language of the datasource.
+func queryLanguage(q backend.DataQuery, dsLanguage string) (string, error) {
+	var rq routedQuery
+	if err := json.Unmarshal(q.JSON, &rq); err != nil {
+		return "", fmt.Errorf("unmarshal json: %w", err)
+	}
+
+	if rq.Language != "" {
+		for _, l := range []string{models.LanguageFlux, models.LanguageInfluxQL, models.LanguageSQL} {
+			if strings.EqualFold(rq.Language, l) {
+				return l, nil
+			}
+		}
+		return "", fmt.Errorf("unknown query language %q", rq.Language)
+	}
+
+	// Queries built by the InfluxQL query editor keep a stale raw query.
+	if dsLanguage == models.LanguageInfluxQL && !rq.RawQuery {
+		return dsLanguage, nil
+	}
+	raw := rq.Query
+	if dsLanguage == models.LanguageSQL {
+		raw = rq.RawSQL
+	}
+	if l := detectLanguage(raw, dsLanguage); l != "" {
+		return l, nil
+	}
+	return dsLanguage, nil
+}
+
+// routeQuery rewrites the query written for the executor of dsLanguage into
+// a query of the executor of language, which reads its raw query from
+// another field.
+func routeQuery(q backend.DataQuery, dsLanguage, language string) (backend.DataQuery, error) {
+	if language == dsLanguage {
+		return q, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(q.JSON, &fields); err != nil {
+		return q, fmt.Errorf("unmarshal json: %w", err)
+	}
+	raw, _ := fields["query"].(string)
+	if dsLanguage == models.LanguageSQL {
+		raw, _ = fields["rawSql"].(string)
+	}
+
+	switch language {
+	case models.LanguageSQL:
+		fields["rawSql"] = raw
+	case models.LanguageInfluxQL:
+		fields["query"] = raw
+		fields["rawQuery"] = true
+	case models.LanguageFlux:
+		fields["query"] = raw
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return q, fmt.Errorf("marshal json: %w", err)
+	}
+	q.JSON = b
+	return q, nil
+}
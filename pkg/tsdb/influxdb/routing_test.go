@@ -0,0 +1,110 @@
+package influxdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cs := []struct {
+		query      string
+		dsLanguage string
+		expected   string
+	}{
+		{query: `from(bucket: "b") |> range(start: v.timeRangeStart)`, expected: models.LanguageFlux},
+		{query: `import "strings"` + "\n" + `from(bucket: "b")`, expected: models.LanguageFlux},
+		{query: `SELECT mean("usage") FROM "cpu" WHERE $timeFilter GROUP BY time($__interval) fill(null)`, expected: models.LanguageInfluxQL},
+		{query: `SHOW MEASUREMENTS`, expected: models.LanguageInfluxQL},
+		{query: `SHOW TABLES`, dsLanguage: models.LanguageSQL, expected: ""},
+		{query: `SHOW COLUMNS FROM cpu`, dsLanguage: models.LanguageSQL, expected: ""},
+		{query: `SELECT date_bin(INTERVAL '1 minute', time) AS t, avg(usage) FROM cpu WHERE $__timeFilter(time) GROUP BY t`, expected: models.LanguageSQL},
+		{query: `SELECT * FROM information_schema.tables`, expected: models.LanguageSQL},
+		{query: `SELECT usage FROM cpu`, expected: ""},
+		{query: ``, expected: ""},
+	}
+	for _, c := range cs {
+		t.Run(c.query, func(t *testing.T) {
+			require.Equal(t, c.expected, detectLanguage(c.query, c.dsLanguage))
+		})
+	}
+}
+
+func TestQueryLanguage(t *testing.T) {
+	cs := []struct {
+		name       string
+		json       string
+		dsLanguage string
+		expected   string
+		err        string
+	}{
+		{name: "language of the query", json: `{"language": "sql", "query": "select 1"}`, dsLanguage: models.LanguageInfluxQL, expected: models.LanguageSQL},
+		{name: "unknown language", json: `{"language": "promql"}`, dsLanguage: models.LanguageFlux, err: `unknown query language "promql"`},
+		{name: "sql datasource reads rawSql", json: `{"query": "from(bucket: \"b\") |> range(start: -1h)"}`, dsLanguage: models.LanguageSQL, expected: models.LanguageSQL},
+		{name: "detected flux in raw sql", json: `{"rawSql": "from(bucket: \"b\") |> range(start: -1h)"}`, dsLanguage: models.LanguageSQL, expected: models.LanguageFlux},
+		{name: "detected sql", json: `{"query": "select * from cpu where $__timeFilter(time)"}`, dsLanguage: models.LanguageFlux, expected: models.LanguageSQL},
+		{name: "raw influxql", json: `{"rawQuery": true, "query": "from(bucket: \"b\") |> range(start: -1h)"}`, dsLanguage: models.LanguageInfluxQL, expected: models.LanguageFlux},
+		{name: "influxql built by the editor", json: `{"query": "from(bucket: \"b\") |> range(start: -1h)"}`, dsLanguage: models.LanguageInfluxQL, expected: models.LanguageInfluxQL},
+		{name: "show statement of a sql datasource", json: `{"rawSql": "SHOW TABLES"}`, dsLanguage: models.LanguageSQL, expected: models.LanguageSQL},
+		{name: "show statement of a flux datasource", json: `{"query": "SHOW MEASUREMENTS"}`, dsLanguage: models.LanguageFlux, expected: models.LanguageInfluxQL},
+		{name: "undetected", json: `{"query": "select usage from cpu"}`, dsLanguage: models.LanguageFlux, expected: models.LanguageFlux},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			language, err := queryLanguage(backend.DataQuery{JSON: []byte(c.json)}, c.dsLanguage)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, language)
+		})
+	}
+}
+
+func TestRouteQuery(t *testing.T) {
+	cs := []struct {
+		name       string
+		json       string
+		dsLanguage string
+		language   string
+		expected   string
+	}{
+		{name: "same language", json: `{"query": "q"}`, dsLanguage: models.LanguageFlux, language: models.LanguageFlux, expected: `{"query": "q"}`},
+		{name: "to sql", json: `{"query": "q", "refId": "A"}`, dsLanguage: models.LanguageFlux, language: models.LanguageSQL, expected: `{"query": "q", "rawSql": "q", "refId": "A"}`},
+		{name: "to influxql", json: `{"query": "q"}`, dsLanguage: models.LanguageFlux, language: models.LanguageInfluxQL, expected: `{"query": "q", "rawQuery": true}`},
+		{name: "to flux", json: `{"rawSql": "q"}`, dsLanguage: models.LanguageSQL, language: models.LanguageFlux, expected: `{"query": "q", "rawSql": "q"}`},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			q, err := routeQuery(backend.DataQuery{JSON: []byte(c.json)}, c.dsLanguage, c.language)
+			require.NoError(t, err)
+			require.JSONEq(t, c.expected, string(q.JSON))
+		})
+	}
+}
+
+func TestQueryData_Routing(t *testing.T) {
+	s := GetMockService(influxVersionInfluxQL, RoundTripper{
+		Body: `{"results": [{"statement_id": 0, "series": [{"name": "cpu", "columns": ["time", "mean"], "values": [[1672531200000, 1.5]]}]}]}`,
+	})
+	s.im.(*fakeInstance).product = models.ProductCore3
+
+	resp, err := s.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"refId": "A", "rawQuery": true, "query": "SELECT mean(\"usage\") FROM \"cpu\" WHERE $timeFilter GROUP BY time(1m)"}`)},
+			{RefID: "B", JSON: []byte(`{"refId": "B", "rawQuery": true, "query": "from(bucket: \"b\") |> range(start: -1h)"}`)},
+			{RefID: "C", JSON: []byte(`{"refId": "C", "language": "promql"}`)},
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Len(t, resp.Responses["A"].Frames, 1)
+	require.EqualError(t, resp.Responses["B"].Error, "InfluxDB 3 Core does not support Flux queries, supported query languages: SQL, InfluxQL")
+	require.EqualError(t, resp.Responses["C"].Error, `bad request: unknown query language "promql"`)
+}
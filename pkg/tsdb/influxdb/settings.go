@@ -1,7 +1,9 @@
 package influxdb
 
+import "github.com/grafana/grafana/pkg/tsdb/influxdb/models"
+
 const (
-	influxVersionFlux     = "Flux"
-	influxVersionInfluxQL = "InfluxQL"
-	influxVersionSQL      = "SQL"
+	influxVersionFlux     = models.LanguageFlux
+	influxVersionInfluxQL = models.LanguageInfluxQL
+	influxVersionSQL      = models.LanguageSQL
 )
@@ -0,0 +1,53 @@
+package influxdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/grafana/grafana/pkg/tsdb/influxdb/fsql"
+)
+
+var _ backend.StreamHandler = (*Service)(nil)
+
+// SubscribeStream subscribes to the Live channel of a SQL push or streamed
+// query.
+func (s *Service) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	dsInfo, err := s.getDSInfo(ctx, req.PluginContext)
+	if err != nil {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, err
+	}
+	if dsInfo.Version == influxVersionSQL {
+		switch {
+		case fsql.IsPushPath(req.Path):
+			return fsql.SubscribePush(dsInfo, req.Path)
+		case fsql.IsStreamPath(req.Path):
+			return fsql.SubscribeStream(dsInfo, req.Path)
+		}
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+}
+
+// RunStream runs the SQL push or streamed query of a Live channel, once for
+// all its subscribers.
+func (s *Service) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	dsInfo, err := s.getDSInfo(ctx, req.PluginContext)
+	if err != nil {
+		return err
+	}
+	if dsInfo.Version == influxVersionSQL {
+		switch {
+		case fsql.IsPushPath(req.Path):
+			return fsql.RunPush(ctx, dsInfo, req.Path, sender)
+		case fsql.IsStreamPath(req.Path):
+			return fsql.RunStream(ctx, dsInfo, req.Path, sender)
+		}
+	}
+	return fmt.Errorf("unknown channel path %q", req.Path)
+}
+
+// PublishStream refuses publications: channels only carry query results.
+func (s *Service) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
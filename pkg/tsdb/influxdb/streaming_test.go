@@ -0,0 +1,36 @@
+package influxdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreaming(t *testing.T) {
+	t.Run("channels of other languages are not found", func(t *testing.T) {
+		s := GetMockService(influxVersionFlux, RoundTripper{})
+		resp, err := s.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "push/abc"})
+		require.NoError(t, err)
+		assert.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+
+		err = s.RunStream(context.Background(), &backend.RunStreamRequest{Path: "push/abc"}, nil)
+		assert.EqualError(t, err, `unknown channel path "push/abc"`)
+	})
+
+	t.Run("unknown push channels are not found", func(t *testing.T) {
+		s := GetMockService(influxVersionSQL, RoundTripper{})
+		resp, err := s.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "push/abc"})
+		require.NoError(t, err)
+		assert.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+	})
+
+	t.Run("publishing is denied", func(t *testing.T) {
+		s := GetMockService(influxVersionSQL, RoundTripper{})
+		resp, err := s.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: "push/abc"})
+		require.NoError(t, err)
+		assert.Equal(t, backend.PublishStreamStatusPermissionDenied, resp.Status)
+	})
+}